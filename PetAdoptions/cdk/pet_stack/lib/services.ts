@@ -20,6 +20,8 @@ import { PayForAdoptionService } from './services/pay-for-adoption-service'
 import { ListAdoptionsService } from './services/list-adoptions-service'
 import { SearchService } from './services/search-service'
 import { TrafficGeneratorService } from './services/traffic-generator-service'
+import { TrafficGeneratorGoService } from './services/traffic-generator-go-service'
+import { HistoryService } from './services/history-service'
 import { StatusUpdaterService } from './services/status-updater-service'
 import { PetAdoptionsStepFn } from './services/stepfn'
 import { KubernetesVersion } from '@aws-cdk/aws-eks';
@@ -190,7 +192,8 @@ export class Services extends cdk.Stack {
             logGroupName: "/ecs/PetListAdoptions",
             cpu: 1024,
             memoryLimitMiB: 2048,
-            healthCheck: '/health/status',
+            // the dependencies are reported by /health/status
+            healthCheck: '/health/live',
             instrumentation: 'otel',
             // build locally
             //repositoryURI: repositoryURI,
@@ -200,6 +203,15 @@ export class Services extends cdk.Stack {
         });
         listAdoptionsService.taskDefinition.taskRole?.addToPrincipalPolicy(readSSMParamsPolicy);
 
+        // Adoption events invalidating the pet search cache of PetListAdoptions
+        const sqsPetCacheInvalidation = new sqs.Queue(this, 'sqs_petcacheinvalidation', {
+            retentionPeriod: cdk.Duration.hours(1)
+        });
+        topic_petadoption.addSubscription(new subs.SqsSubscription(sqsPetCacheInvalidation, {
+            rawMessageDelivery: true
+        }));
+        sqsPetCacheInvalidation.grantConsumeMessages(listAdoptionsService.taskDefinition.taskRole);
+
         // PetSearch service definitions-----------------------------------------------------------------------
         const searchService = new SearchService(this, 'search-service', {
             cluster: new ecs.Cluster(this, "PetSearch", {
@@ -230,6 +242,92 @@ export class Services extends cdk.Stack {
         })
         trafficGeneratorService.taskDefinition.taskRole?.addToPrincipalPolicy(readSSMParamsPolicy);
 
+        // Go traffic generator calling the services directly, its traces carry
+        // petadoptions.traffic.synthetic=true. APP_PROFILE and APP_RPS shape the
+        // rate, it pauses when the chaos parameters change.
+        const trafficGeneratorGoService = new TrafficGeneratorGoService(this, 'traffic-generator-go-service', {
+            cluster: ecsPetListAdoptionCluster,
+            logGroupName: "/ecs/PetTrafficGeneratorGo",
+            cpu: 256,
+            memoryLimitMiB: 512,
+            instrumentation: 'otel',
+            //repositoryURI: repositoryURI,
+            desiredTaskCount: 1,
+            region: region
+        })
+        trafficGeneratorGoService.taskDefinition.taskRole?.addToPrincipalPolicy(readSSMParamsPolicy);
+
+        // Adoption history worker consuming the adoptions queue---------------------------------------------------
+        const dynamodb_petadoptionhistory = new ddb.Table(this, 'ddb_petadoptionhistory', {
+            partitionKey: {
+                name: 'petid',
+                type: ddb.AttributeType.STRING
+            },
+            sortKey: {
+                name: 'adoptedat',
+                type: ddb.AttributeType.STRING
+            },
+            billingMode: ddb.BillingMode.PAY_PER_REQUEST,
+            removalPolicy:  RemovalPolicy.DESTROY
+        });
+        // petlistadoptions pages the adoptions in time order and per user with
+        // queries, the history worker writes the keys of both indexes. Stacks
+        // that already have the table must add the indexes one deploy at a time.
+        dynamodb_petadoptionhistory.addGlobalSecondaryIndex({
+            indexName: 'adoptions-by-time',
+            partitionKey: {
+                name: 'feed',
+                type: ddb.AttributeType.STRING
+            },
+            sortKey: {
+                name: 'adoptedns',
+                type: ddb.AttributeType.NUMBER
+            },
+            projectionType: ddb.ProjectionType.INCLUDE,
+            nonKeyAttributes: ['messageid']
+        });
+        dynamodb_petadoptionhistory.addGlobalSecondaryIndex({
+            indexName: 'adoptions-by-user',
+            partitionKey: {
+                name: 'userid',
+                type: ddb.AttributeType.STRING
+            },
+            sortKey: {
+                name: 'adoptedns',
+                type: ddb.AttributeType.NUMBER
+            },
+            projectionType: ddb.ProjectionType.INCLUDE,
+            nonKeyAttributes: ['messageid']
+        });
+
+        const historyService = new HistoryService(this, 'history-service', {
+            cluster: ecsPetListAdoptionCluster,
+            logGroupName: "/ecs/PetAdoptionsHistory",
+            cpu: 256,
+            memoryLimitMiB: 512,
+            instrumentation: 'otel',
+            //repositoryURI: repositoryURI,
+            desiredTaskCount: 1,
+            region: region,
+            queue: sqsQueue,
+            table: dynamodb_petadoptionhistory
+        })
+        historyService.taskDefinition.taskRole?.addToPrincipalPolicy(readSSMParamsPolicy);
+        // PetListAdoptions reads the history with APP_ADOPTIONS_BACKEND=dynamodb
+        dynamodb_petadoptionhistory.grantReadData(listAdoptionsService.taskDefinition.taskRole);
+
+        // The CloudWatch agent scrapes /metrics on the admin port (9090) of the Go
+        // services, which the load balancers do not route. Deploy
+        // cwagent-ecs-prometheus-metric-for-awsvpc.yaml with this group as SecurityGroupID.
+        const cwAgentSG = new ec2.SecurityGroup(this, 'CWAgentSecurityGroup', {
+            vpc: theVPC,
+            description: 'CloudWatch agent scraping the Prometheus metrics of the ECS tasks',
+            allowAllOutbound: true
+        });
+        payForAdoptionService.service.service.connections.allowFrom(cwAgentSG, ec2.Port.tcp(9090), 'Prometheus scrape of the admin port');
+        listAdoptionsService.service.service.connections.allowFrom(cwAgentSG, ec2.Port.tcp(9090), 'Prometheus scrape of the admin port');
+        historyService.worker.connections.allowFrom(cwAgentSG, ec2.Port.tcp(9090), 'Prometheus scrape of the admin port');
+
         //PetStatusUpdater Lambda Function and APIGW--------------------------------------
         const statusUpdaterService = new StatusUpdaterService(this, 'status-updater-service', {
             tableName: dynamodb_petadoption.tableName
@@ -564,29 +662,40 @@ export class Services extends cdk.Stack {
             '/petstore/petadoptionsstepfnarn': petAdoptionsStepFn.stepFn.stateMachineArn,
             '/petstore/updateadoptionstatusurl': statusUpdaterService.api.url,
             '/petstore/queueurl': sqsQueue.queueUrl,
+            '/petstore/petcacheinvalidationqueueurl': sqsPetCacheInvalidation.queueUrl,
             '/petstore/snsarn': topic_petadoption.topicArn,
             '/petstore/dynamodbtablename': dynamodb_petadoption.tableName,
+            '/petstore/historytablename': dynamodb_petadoptionhistory.tableName,
             '/petstore/s3bucketname': s3_observabilitypetadoptions.bucketName,
             '/petstore/searchapiurl': `http://${searchService.service.loadBalancer.loadBalancerDnsName}/api/search?`,
             '/petstore/petlistadoptionsurl': `http://${listAdoptionsService.service.loadBalancer.loadBalancerDnsName}/api/adoptionlist/`,
-            '/petstore/petlistadoptionsmetricsurl': `http://${listAdoptionsService.service.loadBalancer.loadBalancerDnsName}/metrics`,
             '/petstore/paymentapiurl': `http://${payForAdoptionService.service.loadBalancer.loadBalancerDnsName}/api/home/completeadoption`,
-            '/petstore/payforadoptionmetricsurl': `http://${payForAdoptionService.service.loadBalancer.loadBalancerDnsName}/metrics`,
             '/petstore/cleanupadoptionsurl': `http://${payForAdoptionService.service.loadBalancer.loadBalancerDnsName}/api/home/cleanupadoptions`,
             '/petstore/rdssecretarn': `${auroraCluster.secret?.secretArn}`,
             '/petstore/rdsendpoint': auroraCluster.clusterEndpoint.hostname,
+            // no /petstore/rdsreaderendpoint: Aurora Serverless v1 has no
+            // replicas, its read endpoint is the writer. petlistadoptions-go
+            // only opens a reader pool when the parameter or
+            // RDS_READER_ENDPOINT names a provisioned cluster's reader.
             '/petstore/stackname': stackName,
             '/petstore/petsiteurl': `http://${alb.loadBalancerDnsName}`,
             '/eks/petsite/OIDCProviderUrl': cluster.clusterOpenIdConnectIssuerUrl,
             '/eks/petsite/OIDCProviderArn': cluster.openIdConnectProvider.openIdConnectProviderArn,
-            '/petstore/errormode1':"false"
+            '/petstore/errormode1':"false",
+            '/petstore/degradation_scenario':"none",
+            '/petstore/latencyinjection':'{"enabled":false,"baseMs":2500,"jitterMs":500,"percent":5}',
+            '/petstore/errorinjection':'{"enabled":false,"percent":10,"status":503}',
+            '/petstore/loglevel':"info",
+            // upper bounds in seconds of the Go services' latency histograms
+            '/petstore/latencybuckets':"0.001,0.0025,0.005,0.01,0.02,0.035,0.05,0.075,0.1,0.15,0.25,0.5,1,2.5,5,10"
         })));
 
         this.createOuputs(new Map(Object.entries({
             'QueueURL': sqsQueue.queueUrl,
             'UpdateAdoptionStatusurl': statusUpdaterService.api.url,
             'SNSTopicARN': topic_petadoption.topicArn,
-            'RDSServerName': auroraCluster.clusterEndpoint.hostname
+            'RDSServerName': auroraCluster.clusterEndpoint.hostname,
+            'CWAgentSecurityGroupId': cwAgentSG.securityGroupId
         })));
     }
 
@@ -101,6 +101,10 @@ export abstract class EcsService extends cdk.Construct {
     }).addPortMappings({
       containerPort: 80,
       protocol: ecs.Protocol.TCP
+    }, {
+      // metrics and pprof admin listener, not routed through the load balancer
+      containerPort: 9090,
+      protocol: ecs.Protocol.TCP
     });
 
     this.taskDefinition.addFirelensLogRouter('firelensrouter', {
@@ -160,6 +164,17 @@ export abstract class EcsService extends cdk.Construct {
 
   abstract createContainerImage(): ecs.ContainerImage;
 
+  // The Go services are built with the PetAdoptions directory as context, so
+  // the images include the shared observability module next to them. Its
+  // .dockerignore only lets the Go modules in.
+  protected goServiceImage(service: string, repositoryName: string) : ecs.ContainerImage {
+    return ecs.ContainerImage.fromAsset("../..", {
+      file: `${service}/Dockerfile`,
+      ignoreMode: cdk.IgnoreMode.DOCKER,
+      repositoryName: repositoryName
+    })
+  }
+
   private addXRayContainer(taskDefinition: ecs.FargateTaskDefinition, logging: ecs.AwsLogDriver) {
     taskDefinition.addContainer('xraydaemon', {
       image: ecs.ContainerImage.fromRegistry('public.ecr.aws/xray/aws-xray-daemon:latest'),
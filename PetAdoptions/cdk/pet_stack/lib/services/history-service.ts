@@ -0,0 +1,38 @@
+import * as cdk from '@aws-cdk/core';
+import * as ecs from '@aws-cdk/aws-ecs';
+import * as sqs from '@aws-cdk/aws-sqs';
+import * as ddb from '@aws-cdk/aws-dynamodb';
+import { EcsService, EcsServiceProps } from './ecs-service'
+
+export interface HistoryServiceProps extends EcsServiceProps {
+  queue: sqs.Queue,
+  table: ddb.Table
+}
+
+// Queue worker, it only serves its health check and metrics so it runs
+// without a load balancer
+export class HistoryService extends EcsService {
+
+  public readonly worker: ecs.FargateService;
+
+  constructor(scope: cdk.Construct, id: string, props: HistoryServiceProps  ) {
+    super(scope, id, { ...props, disableService: true });
+
+    props.queue.grantConsumeMessages(this.taskDefinition.taskRole);
+    props.table.grantWriteData(this.taskDefinition.taskRole);
+
+    this.worker = new ecs.FargateService(this, "ecs-worker", {
+      cluster: props.cluster!,
+      taskDefinition: this.taskDefinition,
+      desiredCount: props.desiredTaskCount
+    });
+  }
+
+  containerImageFromRepository(repositoryURI: string) : ecs.ContainerImage {
+    return ecs.ContainerImage.fromRegistry(`${repositoryURI}/pet-adoptionshistory:latest`)
+  }
+
+  createContainerImage() : ecs.ContainerImage {
+    return this.goServiceImage("petadoptionshistory-go", "pet-adoptionshistory")
+  }
+}
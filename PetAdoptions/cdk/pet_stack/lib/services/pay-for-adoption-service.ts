@@ -20,8 +20,6 @@ export class PayForAdoptionService extends EcsService {
   }
 
   createContainerImage() : ecs.ContainerImage {
-    return ecs.ContainerImage.fromAsset("./resources/microservices/payforadoption-go", {
-      repositoryName: "pet-payforadoption"
-    })
+    return this.goServiceImage("payforadoption-go", "pet-payforadoption")
   }
 }
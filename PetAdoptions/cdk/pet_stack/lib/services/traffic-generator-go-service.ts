@@ -0,0 +1,28 @@
+import * as cdk from '@aws-cdk/core';
+import * as ecs from '@aws-cdk/aws-ecs';
+import { EcsService, EcsServiceProps } from './ecs-service'
+
+// Traffic generator calling the Go services directly, it only serves its
+// health check and metrics so it runs without a load balancer
+export class TrafficGeneratorGoService extends EcsService {
+
+  public readonly worker: ecs.FargateService;
+
+  constructor(scope: cdk.Construct, id: string, props: EcsServiceProps  ) {
+    super(scope, id, { ...props, disableService: true });
+
+    this.worker = new ecs.FargateService(this, "ecs-worker", {
+      cluster: props.cluster!,
+      taskDefinition: this.taskDefinition,
+      desiredCount: props.desiredTaskCount
+    });
+  }
+
+  containerImageFromRepository(repositoryURI: string) : ecs.ContainerImage {
+    return ecs.ContainerImage.fromRegistry(`${repositoryURI}/pet-trafficgenerator-go:latest`)
+  }
+
+  createContainerImage() : ecs.ContainerImage {
+    return this.goServiceImage("trafficgenerator-go", "pet-trafficgenerator-go")
+  }
+}
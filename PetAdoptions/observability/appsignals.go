@@ -0,0 +1,260 @@
+package observability
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric/controller/push"
+	"go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+)
+
+// Attributes CloudWatch Application Signals builds its service map, operations
+// and SLOs from, the ADOT SDKs of the Java and .NET services set the same
+const (
+	awsSpanKind        = label.Key("aws.span.kind")
+	awsLocalService    = label.Key("aws.local.service")
+	awsLocalOperation  = label.Key("aws.local.operation")
+	awsRemoteService   = label.Key("aws.remote.service")
+	awsRemoteOperation = label.Key("aws.remote.operation")
+)
+
+const (
+	spanKindLocalRoot       = "LOCAL_ROOT"
+	unknownRemoteService    = "UnknownRemoteService"
+	unknownRemoteOperation  = "UnknownRemoteOperation"
+	appSignalsPushInterval  = time.Minute
+	appSignalsDefaultTarget = "localhost:4316"
+)
+
+// latencyBoundaries are in milliseconds
+var latencyBoundaries = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// appSignalsEnabled reports whether OTEL_AWS_APPLICATION_SIGNALS_ENABLED is
+// set, the variable the ADOT SDKs use. The environment of the service map is
+// the deployment.environment of the resource, from DEPLOYMENT_ENVIRONMENT or
+// ENVIRONMENT.
+func appSignalsEnabled() bool {
+	return os.Getenv("OTEL_AWS_APPLICATION_SIGNALS_ENABLED") == "true"
+}
+
+// appSignalsProcessor stamps the aws.* attributes on the spans and records the
+// Latency, Error and Fault metrics of the server and client spans, which the
+// CloudWatch agent receives on its Application Signals OTLP endpoint
+type appSignalsProcessor struct {
+	service string
+
+	pusher   *push.Controller
+	exporter *otlp.Exporter
+
+	latency metric.Float64ValueRecorder
+	errors  metric.Int64Counter
+	faults  metric.Int64Counter
+}
+
+// newAppSignalsProcessor pushes the metrics to the endpoint of
+// OTEL_AWS_APPLICATION_SIGNALS_EXPORTER_ENDPOINT, the local agent by default
+func newAppSignalsProcessor(ctx context.Context, cfg Config) (*appSignalsProcessor, error) {
+	res, err := DetectResource(ctx, cfg.ServiceName, cfg.ResourceAttributes...)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := otlphttp.NewDriver(
+		otlphttp.WithEndpoint(appSignalsEndpoint(os.Getenv("OTEL_AWS_APPLICATION_SIGNALS_EXPORTER_ENDPOINT"))),
+		otlphttp.WithInsecure(),
+	)
+
+	startCtx, cancel := context.WithTimeout(ctx, exporterStartTimeout)
+	exporter, err := otlp.NewExporter(startCtx, driver)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	pusher := push.New(
+		basic.New(
+			simple.NewWithHistogramDistribution(latencyBoundaries),
+			exporter,
+		),
+		exporter,
+		push.WithPeriod(appSignalsPushInterval),
+		push.WithResource(res),
+	)
+	pusher.Start()
+
+	meter := metric.Must(pusher.MeterProvider().Meter("github.com/aws-samples/one-observability-demo/PetAdoptions/observability"))
+
+	return &appSignalsProcessor{
+		service:  cfg.ServiceName,
+		pusher:   pusher,
+		exporter: exporter,
+		latency:  meter.NewFloat64ValueRecorder("Latency", metric.WithUnit(unit.Milliseconds)),
+		errors:   meter.NewInt64Counter("Error"),
+		faults:   meter.NewInt64Counter("Fault"),
+	}, nil
+}
+
+// appSignalsEndpoint accepts the URL form the ADOT SDKs document,
+// http://localhost:4316/v1/metrics, as well as host:port
+func appSignalsEndpoint(s string) string {
+	if s == "" {
+		return appSignalsDefaultTarget
+	}
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return s
+}
+
+func (p *appSignalsProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	attrs := attributeMap(s.Attributes())
+
+	kind := s.SpanKind().String()
+	operation := ""
+	// the parent is an SDK span when it was started in this service
+	if ps, ok := trace.SpanFromContext(parent).(sdktrace.ReadOnlySpan); ok {
+		operation = attributeMap(ps.Attributes())[awsLocalOperation].AsString()
+	} else {
+		kind = spanKindLocalRoot
+	}
+	if operation == "" || s.SpanKind() == trace.SpanKindServer {
+		operation = localOperation(s, attrs)
+	}
+
+	s.SetAttributes(
+		awsSpanKind.String(strings.ToUpper(kind)),
+		awsLocalService.String(p.service),
+		awsLocalOperation.String(operation),
+	)
+	if isOutgoing(s.SpanKind()) {
+		service, op := remoteOperation(attrs)
+		s.SetAttributes(awsRemoteService.String(service), awsRemoteOperation.String(op))
+	}
+}
+
+// OnEnd records the metrics, the remote attributes are derived again as some
+// are only set once the call is done
+func (p *appSignalsProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	attrs := attributeMap(s.Attributes())
+
+	labels := []label.KeyValue{
+		awsSpanKind.String(attrs[awsSpanKind].AsString()),
+		awsLocalService.String(p.service),
+		awsLocalOperation.String(attrs[awsLocalOperation].AsString()),
+	}
+	switch {
+	case isOutgoing(s.SpanKind()):
+		service, op := remoteOperation(attrs)
+		labels = append(labels, awsRemoteService.String(service), awsRemoteOperation.String(op))
+	case s.SpanKind() == trace.SpanKindServer || attrs[awsSpanKind].AsString() == spanKindLocalRoot:
+	default:
+		// the internal spans are part of the operation of their root
+		return
+	}
+
+	status := attrs[semconv.HTTPStatusCodeKey].AsInt64()
+	var isError, isFault int64
+	switch {
+	case status >= 500 || (status == 0 && s.StatusCode() == codes.Error):
+		isFault = 1
+	case status >= 400:
+		isError = 1
+	}
+
+	ctx := context.Background()
+	p.latency.Record(ctx, float64(s.EndTime().Sub(s.StartTime()))/float64(time.Millisecond), labels...)
+	p.errors.Add(ctx, isError, labels...)
+	p.faults.Add(ctx, isFault, labels...)
+}
+
+// Shutdown pushes the last collection
+func (p *appSignalsProcessor) Shutdown(ctx context.Context) error {
+	p.pusher.Stop()
+	return p.exporter.Shutdown(ctx)
+}
+
+func (p *appSignalsProcessor) ForceFlush() {}
+
+func isOutgoing(kind trace.SpanKind) bool {
+	return kind == trace.SpanKindClient || kind == trace.SpanKindProducer
+}
+
+// localOperation is the method and route of an HTTP server span, the span name
+// otherwise, e.g. the gRPC method
+func localOperation(s sdktrace.ReadOnlySpan, attrs map[label.Key]label.Value) string {
+	method := attrs[semconv.HTTPMethodKey].AsString()
+	if s.SpanKind() != trace.SpanKindServer || method == "" {
+		return s.Name()
+	}
+	if route := attrs[semconv.HTTPRouteKey].AsString(); route != "" {
+		return method + " " + route
+	}
+	return method + " " + s.Name()
+}
+
+// remoteOperation names the dependency of a client span the way the ADOT SDKs
+// do, the database system, the AWS or RPC service, or the peer host with the
+// first segment of the path
+func remoteOperation(attrs map[label.Key]label.Value) (service, operation string) {
+	switch {
+	case attrs[semconv.DBSystemKey].AsString() != "":
+		service = attrs[semconv.DBSystemKey].AsString()
+		operation = attrs[semconv.DBOperationKey].AsString()
+		if operation == "" {
+			operation = strings.ToUpper(firstWord(attrs[semconv.DBStatementKey].AsString()))
+		}
+	case attrs[semconv.RPCServiceKey].AsString() != "":
+		service = attrs[semconv.RPCServiceKey].AsString()
+		if attrs[semconv.RPCSystemKey].AsString() == "aws-api" {
+			service = "AWS::" + service
+		}
+		operation = attrs[semconv.RPCMethodKey].AsString()
+	case attrs[semconv.MessagingSystemKey].AsString() != "":
+		service = attrs[semconv.MessagingSystemKey].AsString()
+		operation = attrs[semconv.MessagingOperationKey].AsString()
+	case attrs[semconv.HTTPURLKey].AsString() != "":
+		if u, err := url.Parse(attrs[semconv.HTTPURLKey].AsString()); err == nil {
+			service = u.Host
+			segment := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)[0]
+			operation = attrs[semconv.HTTPMethodKey].AsString() + " /" + segment
+		}
+	case attrs[semconv.NetPeerNameKey].AsString() != "":
+		service = attrs[semconv.NetPeerNameKey].AsString()
+	}
+
+	if service == "" {
+		service = unknownRemoteService
+	}
+	if strings.TrimSpace(operation) == "" {
+		operation = unknownRemoteOperation
+	}
+	return service, operation
+}
+
+func firstWord(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func attributeMap(kvs []label.KeyValue) map[label.Key]label.Value {
+	m := make(map[label.Key]label.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
@@ -0,0 +1,155 @@
+package observability
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Bounds of the traces held until their request completes, the spans beyond
+// them are dropped as they would have been without sampleOnError
+const (
+	maxPendingTraces   = 1024
+	maxSpansPerTrace   = 256
+	failedTracesQueue  = 64
+	failedTraceTimeout = 5 * time.Second
+
+	// maxPendingAge drops the traces whose local root never ends, e.g. after
+	// a span leaked by its caller, so they do not hold a pending slot forever
+	maxPendingAge = time.Minute
+)
+
+// failedTraceProcessor holds the spans recorded but not sampled by the
+// RuleSampler until the local root of their trace ends, and exports them when
+// one of them failed. The spans of the other services are not part of it, the
+// caller was told the trace is not sampled.
+type failedTraceProcessor struct {
+	exporter exporttrace.SpanExporter
+
+	mtx     sync.Mutex
+	pending map[trace.TraceID]*pendingTrace
+	swept   time.Time
+	stopped bool
+
+	failed chan []*exporttrace.SpanSnapshot
+	done   chan struct{}
+}
+
+type pendingTrace struct {
+	spans   []*exporttrace.SpanSnapshot
+	failed  bool
+	started time.Time
+}
+
+func newFailedTraceProcessor(exporter exporttrace.SpanExporter) *failedTraceProcessor {
+	p := &failedTraceProcessor{
+		exporter: exporter,
+		pending:  map[trace.TraceID]*pendingTrace{},
+		failed:   make(chan []*exporttrace.SpanSnapshot, failedTracesQueue),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *failedTraceProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+
+func (p *failedTraceProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	// the sampled spans go through the batcher
+	if s.SpanContext().IsSampled() {
+		return
+	}
+
+	snapshot := s.Snapshot()
+	traceID := snapshot.SpanContext.TraceID
+	localRoot := !snapshot.ParentSpanID.IsValid() || snapshot.HasRemoteParent
+
+	now := time.Now()
+
+	p.mtx.Lock()
+	if now.Sub(p.swept) > maxPendingAge/2 {
+		p.evictStale(now)
+		p.swept = now
+	}
+	t, ok := p.pending[traceID]
+	if !ok {
+		if len(p.pending) >= maxPendingTraces && !localRoot {
+			p.mtx.Unlock()
+			return
+		}
+		t = &pendingTrace{started: now}
+		p.pending[traceID] = t
+	}
+	if len(t.spans) < maxSpansPerTrace {
+		t.spans = append(t.spans, snapshot)
+	}
+	t.failed = t.failed || spanFailed(snapshot)
+	if localRoot {
+		delete(p.pending, traceID)
+		if t.failed && !p.stopped {
+			select {
+			case p.failed <- t.spans:
+			default:
+			}
+		}
+	}
+	p.mtx.Unlock()
+}
+
+// evictStale drops the traces pending for longer than maxPendingAge, p.mtx is
+// held
+func (p *failedTraceProcessor) evictStale(now time.Time) {
+	for id, t := range p.pending {
+		if now.Sub(t.started) > maxPendingAge {
+			delete(p.pending, id)
+		}
+	}
+}
+
+func (p *failedTraceProcessor) run() {
+	defer close(p.done)
+	for spans := range p.failed {
+		ctx, cancel := context.WithTimeout(context.Background(), failedTraceTimeout)
+		p.exporter.ExportSpans(ctx, spans)
+		cancel()
+	}
+}
+
+// Shutdown exports the failed traces already queued, the exporter itself is
+// shut down by the batcher
+func (p *failedTraceProcessor) Shutdown(ctx context.Context) error {
+	p.mtx.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.failed)
+	}
+	p.mtx.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *failedTraceProcessor) ForceFlush() {}
+
+// spanFailed reports an error status or an HTTP error status code
+func spanFailed(s *exporttrace.SpanSnapshot) bool {
+	if s.StatusCode == codes.Error {
+		return true
+	}
+	for _, kv := range s.Attributes {
+		if kv.Key == semconv.HTTPStatusCodeKey {
+			return kv.Value.AsInt64() >= 400
+		}
+	}
+	return false
+}
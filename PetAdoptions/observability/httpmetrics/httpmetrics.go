@@ -0,0 +1,195 @@
+// Package httpmetrics records the RED metrics of the HTTP servers of the Go
+// services, the rate, errors and duration of the requests, under the same
+// names and labels in every service so one dashboard template covers them:
+//
+//	http_server_requests_total{service, method, route, code, customer_segment, customer_region}
+//	http_server_request_duration_seconds{service, method, route, code, customer_segment, customer_region}
+//
+// The route is the template of the matched route, never the raw path, so the
+// label stays bounded. The customer segment and region are synthetic, taken
+// from the baggage by the services which assign them, unknown elsewhere.
+package httpmetrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBuckets resolve the sub-100ms requests the services mostly answer,
+// the client default starts at 5ms and jumps from 100ms to 250ms
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .02, .035, .05, .075, .1, .15, .25, .5, 1, 2.5, 5, 10}
+
+// Options shape the duration histogram. Buckets are upper bounds in seconds.
+// Native additionally exposes a native histogram, only scraped by a Prometheus
+// with the native-histograms feature enabled, while the classic buckets stay
+// available to the dashboards.
+type Options struct {
+	Buckets []float64
+	Native  bool
+	// TraceID returns the trace of a served request, attached to the
+	// duration as exemplar when not empty
+	TraceID func(r *http.Request, w http.ResponseWriter) string
+	// Segment returns the customer segment and region of a request, from a
+	// closed set of values as they label the metrics
+	Segment func(r *http.Request) (segment, region string)
+}
+
+// Metrics records the requests of a service
+type Metrics struct {
+	requests *stdprometheus.CounterVec
+	duration *stdprometheus.HistogramVec
+	traceID  func(*http.Request, http.ResponseWriter) string
+	segment  func(*http.Request) (string, string)
+}
+
+// unknownSegment labels the requests without a customer segment
+const unknownSegment = "unknown"
+
+// New registers the metrics with the default registry, the service is a label
+// rather than a prefix so they are named alike everywhere
+func New(service string, opts Options) *Metrics {
+	labels := []string{"method", "route", "code", "customer_segment", "customer_region"}
+	constLabels := stdprometheus.Labels{"service": service}
+
+	histOpts := stdprometheus.HistogramOpts{
+		Name:        "http_server_request_duration_seconds",
+		Help:        "Durations of the HTTP requests served in seconds",
+		ConstLabels: constLabels,
+		Buckets:     opts.Buckets,
+	}
+	if len(histOpts.Buckets) == 0 {
+		histOpts.Buckets = DefaultBuckets
+	}
+	if opts.Native {
+		// about 10% relative error, reset rather than grow past 160 buckets
+		histOpts.NativeHistogramBucketFactor = 1.1
+		histOpts.NativeHistogramMaxBucketNumber = 160
+		histOpts.NativeHistogramMinResetDuration = time.Hour
+	}
+
+	m := &Metrics{
+		requests: stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+			Name:        "http_server_requests_total",
+			Help:        "Number of HTTP requests served, by status code",
+			ConstLabels: constLabels,
+		}, labels),
+		// native client histogram, go-kit does not support exemplars
+		duration: stdprometheus.NewHistogramVec(histOpts, labels),
+		traceID:  opts.TraceID,
+		segment:  opts.Segment,
+	}
+	stdprometheus.MustRegister(m.requests, m.duration)
+
+	return m
+}
+
+// Middleware records the requests under the route returned by route, it is a
+// gorilla/mux middleware
+func (m *Metrics) Middleware(route func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.serve(route(r), next, w, r)
+		})
+	}
+}
+
+// Handler records the requests of next under a fixed route, for the servers
+// without a router
+func (m *Metrics) Handler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serve(route, next, w, r)
+	})
+}
+
+func (m *Metrics) serve(route string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+	next.ServeHTTP(sw, r)
+	took := time.Since(begin).Seconds()
+
+	segment, region := unknownSegment, unknownSegment
+	if m.segment != nil {
+		if s, rg := m.segment(r); s != "" && rg != "" {
+			segment, region = s, rg
+		}
+	}
+
+	labels := stdprometheus.Labels{
+		"method":           r.Method,
+		"route":            route,
+		"code":             strconv.Itoa(sw.code),
+		"customer_segment": segment,
+		"customer_region":  region,
+	}
+	m.requests.With(labels).Inc()
+
+	// attach the trace id so a latency spike can be followed to its trace
+	obs := m.duration.With(labels)
+	if eo, ok := obs.(stdprometheus.ExemplarObserver); ok && m.traceID != nil {
+		if traceID := m.traceID(r, w); traceID != "" {
+			eo.ObserveWithExemplar(took, stdprometheus.Labels{"traceID": traceID})
+			return
+		}
+	}
+	obs.Observe(took)
+}
+
+// statusWriter keeps the status code written, and lets the streamed responses
+// flush through it
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// ParseBuckets reads comma separated upper bounds in seconds, e.g.
+// "0.005,0.01,0.05". Repeated bounds are kept once, the histogram would panic
+// on them. An empty string returns nil.
+func ParseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil || b <= 0 {
+			return nil, fmt.Errorf("invalid histogram bucket %q", v)
+		}
+		buckets = append(buckets, b)
+	}
+	sort.Float64s(buckets)
+
+	unique := buckets[:0]
+	for i, b := range buckets {
+		if i == 0 || b != buckets[i-1] {
+			unique = append(unique, b)
+		}
+	}
+	return unique, nil
+}
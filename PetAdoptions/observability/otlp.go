@@ -0,0 +1,98 @@
+package observability
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"google.golang.org/grpc/credentials"
+)
+
+// OTLPDriver builds the OTLP driver from the standard exporter variables:
+//
+//	OTEL_EXPORTER_OTLP_PROTOCOL     grpc or http/protobuf (default)
+//	OTEL_EXPORTER_OTLP_ENDPOINT     host:port of the collector
+//	OTEL_EXPORTER_OTLP_CERTIFICATE  CA bundle, enables TLS
+//	OTEL_EXPORTER_OTLP_INSECURE     set to false for TLS with the system roots
+//	OTEL_EXPORTER_OTLP_HEADERS      comma separated key=value pairs
+//
+// Without any of them it keeps exporting in clear to the local ADOT collector.
+func OTLPDriver() (otlp.ProtocolDriver, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	headers := parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+
+	tlsConfig, err := otlpTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	secure := tlsConfig != nil
+
+	switch os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL") {
+	case "grpc":
+		if endpoint == "" {
+			endpoint = "0.0.0.0:4317"
+		}
+		opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(endpoint), otlpgrpc.WithHeaders(headers)}
+		if secure {
+			opts = append(opts, otlpgrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+		} else {
+			opts = append(opts, otlpgrpc.WithInsecure())
+		}
+		return otlpgrpc.NewDriver(opts...), nil
+
+	case "", "http/protobuf":
+		if endpoint == "" {
+			endpoint = "0.0.0.0:55681"
+		}
+		opts := []otlphttp.Option{otlphttp.WithEndpoint(endpoint), otlphttp.WithHeaders(headers)}
+		if secure {
+			opts = append(opts, otlphttp.WithTLSClientConfig(tlsConfig))
+		} else {
+			opts = append(opts, otlphttp.WithInsecure())
+		}
+		return otlphttp.NewDriver(opts...), nil
+	}
+
+	return nil, errors.New("unsupported OTLP protocol " + os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"))
+}
+
+// otlpTLSConfig returns the TLS configuration for the collector, nil to
+// export in clear
+func otlpTLSConfig() (*tls.Config, error) {
+	certificate := os.Getenv("OTEL_EXPORTER_OTLP_CERTIFICATE")
+	if certificate == "" && os.Getenv("OTEL_EXPORTER_OTLP_INSECURE") != "false" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if certificate != "" {
+		pem, err := ioutil.ReadFile(certificate)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + certificate)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// parseOTLPHeaders reads key=value pairs, e.g. for an authenticated collector
+func parseOTLPHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers
+}
@@ -0,0 +1,153 @@
+// Package paramcache shares the SSM parameters read by the tasks of a service
+// through Redis, ElastiCache in the workshop. Scaling out to dozens of tasks
+// then costs one Parameter Store call per TTL instead of one per task, which
+// keeps the services clear of the TooManyRequests throttling.
+//
+// The values are stored in clear in Redis, secrets are never read through the
+// cache.
+package paramcache
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis/v8"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultTTL = 5 * time.Minute
+	keyPrefix  = "petstore:paramcache:"
+
+	// SSM rejects GetParameters calls with more names
+	maxParametersPerCall = 10
+)
+
+// Options configure the cache, it is disabled without an address
+type Options struct {
+	Addr string
+	TLS  bool
+	TTL  time.Duration
+}
+
+// Cache reads through Redis. A nil *Cache calls AWS directly, and so does a
+// Cache whose Redis is unreachable, the cache only ever saves calls.
+type Cache struct {
+	client  *redis.Client
+	ttl     time.Duration
+	logger  log.Logger
+	lookups *stdprometheus.CounterVec
+}
+
+// New returns nil when opts has no address. It registers the lookup counter
+// under namespace, so it is called once per process.
+func New(namespace string, opts Options, logger log.Logger) *Cache {
+	if opts.Addr == "" {
+		return nil
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultTTL
+	}
+
+	ropts := &redis.Options{
+		Addr: opts.Addr,
+		// a slow cache must not hold the startup, AWS is called instead
+		DialTimeout:  time.Second,
+		ReadTimeout:  500 * time.Millisecond,
+		WriteTimeout: 500 * time.Millisecond,
+		MaxRetries:   1,
+	}
+	if opts.TLS {
+		ropts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	lookups := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "param_cache",
+		Name:      "lookups_total",
+		Help:      "Number of SSM parameter lookups through the shared cache, by kind and result",
+	}, []string{"kind", "result"})
+	stdprometheus.MustRegister(lookups)
+
+	return &Cache{
+		client:  redis.NewClient(ropts),
+		ttl:     opts.TTL,
+		logger:  log.With(logger, "component", "paramcache", "addr", opts.Addr),
+		lookups: lookups,
+	}
+}
+
+// Get returns the value cached under key, or calls fetch and caches its
+// result. Fetch errors are not cached.
+func (c *Cache) Get(ctx context.Context, kind, key string, fetch func(context.Context) (string, error)) (string, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+	key = keyPrefix + kind + ":" + key
+
+	v, err := c.client.Get(ctx, key).Result()
+	switch {
+	case err == nil:
+		c.lookups.WithLabelValues(kind, "hit").Inc()
+		return v, nil
+	case err == redis.Nil:
+		c.lookups.WithLabelValues(kind, "miss").Inc()
+	default:
+		c.lookups.WithLabelValues(kind, "error").Inc()
+		level.Warn(c.logger).Log("method", "Get", "kind", kind, "err", err)
+	}
+
+	if v, err = fetch(ctx); err != nil {
+		return "", err
+	}
+	if err := c.client.Set(ctx, key, v, c.ttl).Err(); err != nil {
+		level.Warn(c.logger).Log("method", "Set", "kind", kind, "err", err)
+	}
+	return v, nil
+}
+
+// GetParameters returns the values of the parameters by name, the parameters
+// that do not exist are left out like GetParameters does. Names are fetched
+// in batches of maxParametersPerCall and cached as a whole.
+func (c *Cache) GetParameters(ctx context.Context, svc *ssm.SSM, names []string) (map[string]string, error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	v, err := c.Get(ctx, "ssm", strings.Join(sorted, ","), func(ctx context.Context) (string, error) {
+		params := map[string]string{}
+		for start := 0; start < len(sorted); start += maxParametersPerCall {
+			end := start + maxParametersPerCall
+			if end > len(sorted) {
+				end = len(sorted)
+			}
+
+			res, err := svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{Names: aws.StringSlice(sorted[start:end])})
+			if err != nil {
+				return "", err
+			}
+			for _, p := range res.Parameters {
+				params[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+			}
+		}
+
+		b, err := json.Marshal(params)
+		return string(b), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{}
+	if err := json.Unmarshal([]byte(v), &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
@@ -0,0 +1,83 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pyroscope-io/client/pyroscope"
+)
+
+// Profilers selected with PROFILER. The pprof endpoints of the admin port are
+// always served, ProfilerPull leaves the collection to whoever scrapes them,
+// Pyroscope in pull mode or the ADOT collector. ProfilerPyroscope pushes the
+// profiles to PYROSCOPE_SERVER_ADDRESS, there is no CodeGuru Profiler agent
+// for Go.
+const (
+	ProfilerPull      = "pull"
+	ProfilerPyroscope = "pyroscope"
+)
+
+// StartProfiler starts the profiler selected with PROFILER. The CPU samples
+// carry the endpoint and pettype labels set on the requests, so the flamegraph
+// of the system stress scenario can be broken down by both.
+func StartProfiler(cfg Config, logger log.Logger) (Shutdown, error) {
+	switch name := os.Getenv("PROFILER"); name {
+	case "", "none", ProfilerPull:
+		return func(context.Context) error { return nil }, nil
+	case ProfilerPyroscope:
+	default:
+		return nil, fmt.Errorf("unknown profiler %q", name)
+	}
+
+	addr := os.Getenv("PYROSCOPE_SERVER_ADDRESS")
+	if addr == "" {
+		return nil, fmt.Errorf("PYROSCOPE_SERVER_ADDRESS is required by the %s profiler", ProfilerPyroscope)
+	}
+
+	tags := map[string]string{}
+	if v := os.Getenv("SERVICE_VERSION"); v != "" {
+		tags["service_version"] = v
+	}
+	if env := deploymentEnvironment(); env != "" {
+		tags["deployment_environment"] = env
+	}
+
+	p, err := pyroscope.Start(pyroscope.Config{
+		ApplicationName: "petadoptions." + cfg.ServiceName,
+		ServerAddress:   addr,
+		AuthToken:       os.Getenv("PYROSCOPE_AUTH_TOKEN"),
+		Tags:            tags,
+		Logger:          profilerLogger{log.With(logger, "profiler", ProfilerPyroscope)},
+		ProfileTypes: []pyroscope.ProfileType{
+			pyroscope.ProfileCPU,
+			pyroscope.ProfileAllocSpace,
+			pyroscope.ProfileInuseSpace,
+			pyroscope.ProfileInuseObjects,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(context.Context) error { return p.Stop() }, nil
+}
+
+// profilerLogger adapts the service logger to the pyroscope one
+type profilerLogger struct {
+	logger log.Logger
+}
+
+func (l profilerLogger) Infof(format string, args ...interface{}) {
+	level.Info(l.logger).Log("msg", fmt.Sprintf(format, args...))
+}
+
+func (l profilerLogger) Debugf(format string, args ...interface{}) {
+	level.Debug(l.logger).Log("msg", fmt.Sprintf(format, args...))
+}
+
+func (l profilerLogger) Errorf(format string, args ...interface{}) {
+	level.Error(l.logger).Log("msg", fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,61 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/contrib/detectors/aws/ec2"
+	"go.opentelemetry.io/contrib/detectors/aws/ecs"
+	"go.opentelemetry.io/contrib/detectors/aws/eks"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// DetectResource describes the service and the runtime it was found on, attrs
+// are the attributes specific to the service. OTEL_RESOURCE_ATTRIBUTES wins
+// over the service attributes, which win over the detected ones. Detectors for
+// other runtimes fail and are skipped, a malformed OTEL_RESOURCE_ATTRIBUTES is
+// returned as an error.
+func DetectResource(ctx context.Context, serviceName string, attrs ...label.KeyValue) (*resource.Resource, error) {
+	res, err := resource.FromEnv{}.Detect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+
+	attrs = append([]label.KeyValue{
+		// the service name used to display traces in backends
+		semconv.ServiceNameKey.String(serviceName),
+	}, attrs...)
+	if v := os.Getenv("SERVICE_VERSION"); v != "" {
+		attrs = append(attrs, semconv.ServiceVersionKey.String(v))
+	}
+	if env := deploymentEnvironment(); env != "" {
+		attrs = append(attrs, semconv.DeploymentEnvironmentKey.String(env))
+	}
+	serviceResource, _ := resource.New(ctx, resource.WithAttributes(attrs...))
+	res = resource.Merge(res, serviceResource)
+
+	detectors := []resource.Detector{
+		eks.NewResourceDetector(),
+		ecs.NewResourceDetector(),
+		ec2.NewResourceDetector(),
+	}
+	for _, d := range detectors {
+		detected, err := d.Detect(ctx)
+		if err != nil {
+			continue
+		}
+		res = resource.Merge(res, detected)
+	}
+
+	return res, nil
+}
+
+func deploymentEnvironment() string {
+	if env := os.Getenv("DEPLOYMENT_ENVIRONMENT"); env != "" {
+		return env
+	}
+	return os.Getenv("ENVIRONMENT")
+}
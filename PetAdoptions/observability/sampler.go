@@ -0,0 +1,165 @@
+package observability
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// Samplers selected with OTEL_TRACES_SAMPLER, the ratio comes from
+// OTEL_TRACES_SAMPLER_ARG
+const (
+	samplerAlwaysOn                = "always_on"
+	samplerAlwaysOff               = "always_off"
+	samplerTraceIDRatio            = "traceidratio"
+	samplerParentBasedAlwaysOn     = "parentbased_always_on"
+	samplerParentBasedAlwaysOff    = "parentbased_always_off"
+	samplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// TraceSampler returns the configured sampler. It defaults to
+// parentbased_always_on so the decision taken by the caller is kept.
+func TraceSampler(name, arg string) (sdktrace.Sampler, error) {
+	switch name {
+	case "", samplerParentBasedAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case samplerAlwaysOn:
+		return sdktrace.AlwaysSample(), nil
+	case samplerAlwaysOff:
+		return sdktrace.NeverSample(), nil
+	case samplerParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case samplerTraceIDRatio, samplerParentBasedTraceIDRatio:
+		ratio := 1.0
+		if arg != "" {
+			var err error
+			if ratio, err = strconv.ParseFloat(strings.TrimSpace(arg), 64); err != nil || ratio < 0 || ratio > 1 {
+				return nil, fmt.Errorf("invalid sampler ratio %q", arg)
+			}
+		}
+		if name == samplerTraceIDRatio {
+			return sdktrace.TraceIDRatioBased(ratio), nil
+		}
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q", name)
+}
+
+// SamplingRules are the trace sampling settings shared by the services in the
+// /petstore/samplingrules parameter, so they can be changed during a workshop
+// without a deployment, e.g.
+//
+//	{"defaultRatio": 0.05, "routes": {"/api/adoptionlist/": 0.5}, "sampleOnError": true}
+//
+// sampleAllInErrorMode only applies to payforadoption, which has the error mode.
+type SamplingRules struct {
+	// DefaultRatio applies to the routes without a rule, unset keeps the
+	// sampler of the environment for them
+	DefaultRatio *float64 `json:"defaultRatio,omitempty"`
+	// Routes maps a route template to its ratio
+	Routes map[string]float64 `json:"routes,omitempty"`
+	// SampleOnError keeps the traces of the requests which failed
+	SampleOnError bool `json:"sampleOnError,omitempty"`
+	// SampleAllInErrorMode samples every adoption while error mode is on
+	SampleAllInErrorMode bool `json:"sampleAllInErrorMode,omitempty"`
+}
+
+// ParseSamplingRules reads the JSON rules, an empty string returns no rules
+func ParseSamplingRules(s string) (*SamplingRules, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var rules SamplingRules
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("invalid sampling rules: %v", err)
+	}
+
+	if r := rules.DefaultRatio; r != nil && (*r < 0 || *r > 1) {
+		return nil, fmt.Errorf("invalid default sampling ratio %g", *r)
+	}
+	for route, r := range rules.Routes {
+		if r < 0 || r > 1 {
+			return nil, fmt.Errorf("invalid sampling ratio %g for %s", r, route)
+		}
+	}
+	return &rules, nil
+}
+
+// RuleSampler takes the decisions with the last rules it was given, and with
+// the sampler of the environment until then and for the routes without a
+// ratio. The rules override the decision of the caller. With sampleOnError
+// the traces it drops are still recorded, and the ones which fail are exported
+// when their request completes.
+type RuleSampler struct {
+	rules    atomic.Value
+	fallback sdktrace.Sampler
+}
+
+// NewRuleSampler returns a sampler to pass in Config, without rules yet
+func NewRuleSampler() *RuleSampler {
+	s := &RuleSampler{fallback: sdktrace.ParentBased(sdktrace.AlwaysSample())}
+	s.rules.Store((*SamplingRules)(nil))
+	return s
+}
+
+// Update swaps the rules, nil goes back to the sampler of the environment
+func (s *RuleSampler) Update(rules *SamplingRules) {
+	s.rules.Store(rules)
+}
+
+func (s *RuleSampler) load() *SamplingRules {
+	return s.rules.Load().(*SamplingRules)
+}
+
+func (s *RuleSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	rules := s.load()
+	if rules == nil {
+		return s.fallback.ShouldSample(p)
+	}
+
+	var result sdktrace.SamplingResult
+	if p.ParentContext.IsValid() && !p.HasRemoteParent {
+		// the spans of a request follow its root
+		result = sdktrace.ParentBased(sdktrace.AlwaysSample()).ShouldSample(p)
+	} else if ratio, ok := rules.ratio(route(p)); ok {
+		result = sdktrace.TraceIDRatioBased(ratio).ShouldSample(p)
+	} else {
+		result = s.fallback.ShouldSample(p)
+	}
+
+	if result.Decision == sdktrace.Drop && rules.SampleOnError {
+		result.Decision = sdktrace.RecordOnly
+	}
+	return result
+}
+
+func (s *RuleSampler) Description() string {
+	return "RuleSampler{" + s.fallback.Description() + "}"
+}
+
+func (r *SamplingRules) ratio(route string) (float64, bool) {
+	if ratio, ok := r.Routes[route]; ok {
+		return ratio, true
+	}
+	if r.DefaultRatio != nil {
+		return *r.DefaultRatio, true
+	}
+	return 0, false
+}
+
+// route is the route template of an HTTP server span, which otelmux names
+// after it, the span name otherwise
+func route(p sdktrace.SamplingParameters) string {
+	for _, kv := range p.Attributes {
+		if kv.Key == semconv.HTTPRouteKey {
+			return kv.Value.AsString()
+		}
+	}
+	return p.Name
+}
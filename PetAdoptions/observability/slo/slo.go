@@ -0,0 +1,378 @@
+// Package slo tracks the availability and latency SLIs of the endpoints of a
+// service against their objectives. The good and total requests are exported
+// as counters, so the error budget and the burn rates over the windows of
+// multi-window burn-rate alerts are computed over every task of the service.
+// Handler serves them as seen by the task alone.
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SLIs tracked for every endpoint
+const (
+	SLIAvailability = "availability"
+	SLILatency      = "latency"
+)
+
+// DefaultPeriod is the compliance period of the error budget reported by
+// Handler. The counts are held in memory, a restarted task starts with a full
+// budget.
+const DefaultPeriod = 24 * time.Hour
+
+// MinPeriod is the shortest period, it covers the burn rate windows of the
+// page alerts
+const MinPeriod = time.Hour
+
+// Windows of the burn rates, the short and long windows of the page and
+// ticket alerts
+var Windows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+// Objective is the target of an endpoint
+type Objective struct {
+	// Availability is the share of requests which must not fail with a 5xx
+	Availability float64 `json:"availability"`
+	// Latency is the threshold a request must be answered under
+	Latency Duration `json:"latency"`
+	// LatencyTarget is the share of requests answered under Latency
+	LatencyTarget float64 `json:"latencyTarget"`
+}
+
+// DefaultObjective applies to the endpoints without an objective of their own
+var DefaultObjective = Objective{
+	Availability:  0.99,
+	Latency:       Duration(time.Second),
+	LatencyTarget: 0.95,
+}
+
+// Objectives are read from JSON, the endpoints are named by method and route,
+// e.g.
+//
+//	{"default": {"availability": 0.99, "latency": "1s", "latencyTarget": 0.95},
+//	 "endpoints": {"POST /api/home/completeadoption": {"availability": 0.995, "latency": "500ms", "latencyTarget": 0.9}}}
+type Objectives struct {
+	Default   *Objective           `json:"default,omitempty"`
+	Endpoints map[string]Objective `json:"endpoints,omitempty"`
+}
+
+// ParseObjectives reads the JSON objectives, an empty string applies
+// DefaultObjective to every endpoint
+func ParseObjectives(s string) (Objectives, error) {
+	var o Objectives
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &o); err != nil {
+			return o, fmt.Errorf("invalid SLO objectives: %v", err)
+		}
+	}
+	if o.Default == nil {
+		d := DefaultObjective
+		o.Default = &d
+	}
+
+	if err := o.Default.validate(); err != nil {
+		return o, fmt.Errorf("default SLO objective: %v", err)
+	}
+	for endpoint, obj := range o.Endpoints {
+		if err := obj.validate(); err != nil {
+			return o, fmt.Errorf("SLO objective of %s: %v", endpoint, err)
+		}
+	}
+	return o, nil
+}
+
+func (o Objectives) of(endpoint string) Objective {
+	if obj, ok := o.Endpoints[endpoint]; ok {
+		return obj
+	}
+	return *o.Default
+}
+
+func (o Objective) validate() error {
+	if o.Availability <= 0 || o.Availability >= 1 {
+		return fmt.Errorf("availability must be between 0 and 1 excluded, got %g", o.Availability)
+	}
+	if o.LatencyTarget <= 0 || o.LatencyTarget >= 1 {
+		return fmt.Errorf("latency target must be between 0 and 1 excluded, got %g", o.LatencyTarget)
+	}
+	if o.Latency <= 0 {
+		return fmt.Errorf("latency must be positive")
+	}
+	return nil
+}
+
+// Duration reads a duration string such as "500ms" from JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// bucket counts the requests of a minute
+type bucket struct {
+	minute int64
+	total  uint64
+	failed uint64
+	slow   uint64
+}
+
+// series holds the buckets of an endpoint over the period, indexed by minute
+type series struct {
+	buckets []bucket
+}
+
+func (s *series) add(minute int64, failed, slow bool) {
+	b := &s.buckets[minute%int64(len(s.buckets))]
+	if b.minute != minute {
+		*b = bucket{minute: minute}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+// sum adds the buckets of the minutes after since up to now
+func (s *series) sum(now, since int64) (total, failed, slow uint64) {
+	for _, b := range s.buckets {
+		if b.minute > since && b.minute <= now {
+			total += b.total
+			failed += b.failed
+			slow += b.slow
+		}
+	}
+	return total, failed, slow
+}
+
+// Tracker records the requests of the endpoints. It is a Prometheus
+// collector of the objectives and of the request counters.
+type Tracker struct {
+	service    string
+	objectives Objectives
+	period     time.Duration
+
+	mtx       sync.Mutex
+	endpoints map[string]*series
+
+	objective *prometheus.Desc
+	requests  *prometheus.CounterVec
+	good      *prometheus.CounterVec
+}
+
+// New returns a tracker registered with the default registry, a zero period
+// is DefaultPeriod. The metrics carry the service as a label rather than a
+// prefix, so one set of alerting rules covers every service.
+func New(service string, objectives Objectives, period time.Duration) (*Tracker, error) {
+	if period == 0 {
+		period = DefaultPeriod
+	}
+	if period < MinPeriod {
+		return nil, fmt.Errorf("SLO period must be at least %s, got %s", MinPeriod, period)
+	}
+	if objectives.Default == nil {
+		d := DefaultObjective
+		objectives.Default = &d
+	}
+
+	constLabels := prometheus.Labels{"service": service}
+	t := &Tracker{
+		service:    service,
+		objectives: objectives,
+		period:     period,
+		endpoints:  map[string]*series{},
+		objective: prometheus.NewDesc("slo_objective",
+			"Target of the SLI of the endpoint",
+			[]string{"endpoint", "sli"}, constLabels),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "slo_requests_total",
+			Help:        "Number of requests of the endpoint counted by its SLIs",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		good: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "slo_requests_good_total",
+			Help:        "Number of requests of the endpoint meeting the SLI, requests not failing with a 5xx for availability and answered under the threshold for latency",
+			ConstLabels: constLabels,
+		}, []string{"endpoint", "sli"}),
+	}
+	prometheus.MustRegister(t)
+
+	return t, nil
+}
+
+// Observe records a request of endpoint, a nil tracker ignores it
+func (t *Tracker) Observe(endpoint string, took time.Duration, failed bool) {
+	if t == nil {
+		return
+	}
+	slow := took > time.Duration(t.objectives.of(endpoint).Latency)
+	minute := time.Now().Unix() / 60
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s, ok := t.endpoints[endpoint]
+	if !ok {
+		s = &series{buckets: make([]bucket, int(t.period/time.Minute))}
+		t.endpoints[endpoint] = s
+		// the good counters start at 0 rather than with the first good request
+		t.good.WithLabelValues(endpoint, SLIAvailability)
+		t.good.WithLabelValues(endpoint, SLILatency)
+	}
+	s.add(minute, failed, slow)
+
+	t.requests.WithLabelValues(endpoint).Inc()
+	if !failed {
+		t.good.WithLabelValues(endpoint, SLIAvailability).Inc()
+	}
+	if !slow {
+		t.good.WithLabelValues(endpoint, SLILatency).Inc()
+	}
+}
+
+// SLIReport is the state of an SLI of an endpoint
+type SLIReport struct {
+	Objective float64 `json:"objective"`
+	// Compliance is the share of good requests over the period
+	Compliance      float64            `json:"compliance"`
+	BudgetRemaining float64            `json:"errorBudgetRemaining"`
+	BurnRates       map[string]float64 `json:"burnRates"`
+}
+
+// Report is the state of the SLIs of an endpoint
+type Report struct {
+	Endpoint         string    `json:"endpoint"`
+	Requests         uint64    `json:"requests"`
+	LatencyThreshold Duration  `json:"latencyThreshold"`
+	Availability     SLIReport `json:"availability"`
+	Latency          SLIReport `json:"latency"`
+}
+
+// Reports returns the state of every endpoint seen, sorted by endpoint
+func (t *Tracker) Reports() []Report {
+	now := time.Now().Unix() / 60
+	periodStart := now - int64(t.period/time.Minute)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	reports := make([]Report, 0, len(t.endpoints))
+	for endpoint, s := range t.endpoints {
+		obj := t.objectives.of(endpoint)
+		total, failed, slow := s.sum(now, periodStart)
+
+		r := Report{
+			Endpoint:         endpoint,
+			Requests:         total,
+			LatencyThreshold: obj.Latency,
+			Availability:     newSLIReport(obj.Availability, total, failed),
+			Latency:          newSLIReport(obj.LatencyTarget, total, slow),
+		}
+		for _, w := range t.windows() {
+			wTotal, wFailed, wSlow := s.sum(now, now-int64(w/time.Minute))
+			r.Availability.BurnRates[windowName(w)] = burnRate(obj.Availability, wTotal, wFailed)
+			r.Latency.BurnRates[windowName(w)] = burnRate(obj.LatencyTarget, wTotal, wSlow)
+		}
+		reports = append(reports, r)
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Endpoint < reports[j].Endpoint })
+	return reports
+}
+
+// windows are the burn rate windows within the period
+func (t *Tracker) windows() []time.Duration {
+	var windows []time.Duration
+	for _, w := range Windows {
+		if w <= t.period {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+// windowName is the window label, e.g. 5m or 6h
+func windowName(w time.Duration) string {
+	if w%time.Hour == 0 {
+		return fmt.Sprintf("%dh", w/time.Hour)
+	}
+	return fmt.Sprintf("%dm", w/time.Minute)
+}
+
+func newSLIReport(objective float64, total, bad uint64) SLIReport {
+	r := SLIReport{
+		Objective:       objective,
+		Compliance:      1,
+		BudgetRemaining: 1,
+		BurnRates:       map[string]float64{},
+	}
+	if total > 0 {
+		r.Compliance = 1 - float64(bad)/float64(total)
+		r.BudgetRemaining = 1 - burnRate(objective, total, bad)
+	}
+	return r
+}
+
+// burnRate is the error rate over the error budget, 0 without requests
+func burnRate(objective float64, total, bad uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / (1 - objective)
+}
+
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.objective
+	t.requests.Describe(ch)
+	t.good.Describe(ch)
+}
+
+// Collect exports the counters and the objectives of the endpoints seen. The
+// burn rate of an SLI over a window is 1 - rate(good) / rate(total) over the
+// window, divided by 1 - objective.
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.mtx.Lock()
+	endpoints := make([]string, 0, len(t.endpoints))
+	for endpoint := range t.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	t.mtx.Unlock()
+
+	for _, endpoint := range endpoints {
+		obj := t.objectives.of(endpoint)
+		ch <- prometheus.MustNewConstMetric(t.objective, prometheus.GaugeValue, obj.Availability, endpoint, SLIAvailability)
+		ch <- prometheus.MustNewConstMetric(t.objective, prometheus.GaugeValue, obj.LatencyTarget, endpoint, SLILatency)
+	}
+	t.requests.Collect(ch)
+	t.good.Collect(ch)
+}
+
+// Handler serves the reports of the task as JSON
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"service":   t.service,
+			"period":    t.period.String(),
+			"endpoints": t.Reports(),
+		})
+	})
+}
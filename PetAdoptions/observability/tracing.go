@@ -0,0 +1,143 @@
+// Package observability sets up OpenTelemetry the same way in every Go
+// service: resource detection, OTLP export, sampling and propagation, driven
+// by the standard OTEL_* variables. The services only pass what is specific
+// to them in Config.
+//
+// It is built on OpenTelemetry Go 0.17, payforadoption traces with X-Ray and
+// OpenTelemetry 0.18 and keeps its own variant of the package. Its
+// subpackages have no OpenTelemetry dependency and serve every service.
+package observability
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	otelxray "go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Trace exporters selected with TRACE_EXPORTER
+const (
+	TraceExporterOTLP   = "otlp"
+	TraceExporterXRay   = "xray"
+	TraceExporterStdout = "stdout"
+	TraceExporterNone   = "none"
+)
+
+// exporterStartTimeout bounds the connection to the collector at startup
+const exporterStartTimeout = 5 * time.Second
+
+// Config holds the settings of a service, the rest comes from the environment
+type Config struct {
+	ServiceName string
+	// ResourceAttributes are added to the service name, version and
+	// environment
+	ResourceAttributes []label.KeyValue
+	// TraceExporter overrides TRACE_EXPORTER
+	TraceExporter string
+	// Sampler, when set, takes the sampling decisions with the rules it is
+	// given, and with the sampler of the environment until then
+	Sampler *RuleSampler
+}
+
+// Shutdown flushes the telemetry still buffered, bounded by the context
+type Shutdown func(context.Context) error
+
+// InitTracerProvider sets up the global tracer provider with the selected
+// exporter, otlp by default. Spans are batched so an unreachable collector
+// drops spans instead of slowing down requests. The OTel SDK has no direct
+// X-Ray exporter, xray sends the spans over OTLP to the collector, whose
+// awsxray exporter delivers them, with the X-Ray ID generator and propagator.
+func InitTracerProvider(ctx context.Context, cfg Config) (Shutdown, error) {
+	exporterName := cfg.TraceExporter
+	if exporterName == "" {
+		exporterName = os.Getenv("TRACE_EXPORTER")
+	}
+
+	res, err := DetectResource(ctx, cfg.ServiceName, cfg.ResourceAttributes...)
+	if err != nil {
+		return nopShutdown, err
+	}
+
+	var exporter exporttrace.SpanExporter
+
+	switch exporterName {
+	case "", TraceExporterOTLP, TraceExporterXRay:
+		var driver otlp.ProtocolDriver
+		if driver, err = OTLPDriver(); err != nil {
+			return nopShutdown, err
+		}
+		// the collector may be absent, starting the exporter must not hang
+		// the service
+		startCtx, cancel := context.WithTimeout(ctx, exporterStartTimeout)
+		exporter, err = otlp.NewExporter(startCtx, driver)
+		cancel()
+	case TraceExporterStdout:
+		exporter, err = stdout.NewExporter(stdout.WithoutMetricExport())
+	case TraceExporterNone:
+	default:
+		return nopShutdown, fmt.Errorf("unknown trace exporter %q", exporterName)
+	}
+	if err != nil {
+		return nopShutdown, err
+	}
+
+	sampler, err := TraceSampler(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if err != nil {
+		return nopShutdown, err
+	}
+	if cfg.Sampler != nil {
+		cfg.Sampler.fallback = sampler
+		sampler = cfg.Sampler
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithConfig(sdktrace.Config{
+			DefaultSampler: sampler,
+		}),
+		// A custom ID Generator to generate traceIDs that conform to
+		// AWS X-Ray traceID format
+		sdktrace.WithIDGenerator(otelxray.NewIDGenerator()),
+		sdktrace.WithResource(res),
+	}
+	// the spans and metrics CloudWatch Application Signals needs, the
+	// processor is shut down with the provider
+	if appSignalsEnabled() {
+		p, err := newAppSignalsProcessor(ctx, cfg)
+		if err != nil {
+			return nopShutdown, err
+		}
+		opts = append(opts, sdktrace.WithSpanProcessor(p))
+	}
+	if exporter != nil {
+		// registered first, the batcher shuts the exporter down
+		if cfg.Sampler != nil {
+			opts = append(opts, sdktrace.WithSpanProcessor(newFailedTraceProcessor(exporter)))
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	// Set the traceprovider and the propagator we want to use
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		otelxray.Propagator{},
+		// userId, sessionId, customerSegment and customerRegion set by
+		// payforadoption
+		propagation.Baggage{},
+	))
+
+	// flushes the spans still batched, bounded by the context
+	return tp.Shutdown, nil
+}
+
+func nopShutdown(context.Context) error { return nil }
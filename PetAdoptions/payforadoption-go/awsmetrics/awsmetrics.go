@@ -0,0 +1,65 @@
+// Package awsmetrics records Prometheus metrics for the AWS SDK calls, so the
+// dependencies can be watched and alarmed on without a trace collector.
+package awsmetrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	labels = []string{"service", "operation"}
+
+	latency = kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "payforadoption",
+		Subsystem: "aws",
+		Name:      "request_duration_seconds",
+		Help:      "AWS SDK call durations in seconds, retries included",
+	}, append(labels, "error"))
+
+	failures = kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "payforadoption",
+		Subsystem: "aws",
+		Name:      "request_errors_total",
+		Help:      "Number of failed AWS SDK calls by error code",
+	}, append(labels, "code", "throttled"))
+
+	retries = kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "payforadoption",
+		Subsystem: "aws",
+		Name:      "request_retries_total",
+		Help:      "Number of AWS SDK call retries",
+	}, labels)
+)
+
+// Instrument observes every call sent with the handlers, typically those of
+// a service client
+func Instrument(h *request.Handlers) {
+	h.Complete.PushBackNamed(request.NamedHandler{
+		Name: "awsmetrics.Complete",
+		Fn:   observe,
+	})
+}
+
+func observe(r *request.Request) {
+	lv := []string{"service", r.ClientInfo.ServiceName, "operation", r.Operation.Name}
+
+	latency.With(append(lv, "error", fmt.Sprint(r.Error != nil))...).Observe(time.Since(r.Time).Seconds())
+
+	if r.RetryCount > 0 {
+		retries.With(lv...).Add(float64(r.RetryCount))
+	}
+
+	if r.Error != nil {
+		code := "unknown"
+		if aerr, ok := r.Error.(awserr.Error); ok {
+			code = aerr.Code()
+		}
+		failures.With(append(lv, "code", code, "throttled", fmt.Sprint(request.IsErrorThrottle(r.Error)))...).Add(1)
+	}
+}
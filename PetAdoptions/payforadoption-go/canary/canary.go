@@ -0,0 +1,150 @@
+// Package canary sends a trickle of synthetic requests to the service itself,
+// so the dashboards and the SLOs keep data when the traffic generator is off.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Header marks the synthetic requests, the services tag their traces with
+// synthetic=true so they can be filtered out
+const Header = "X-Synthetic"
+
+// Options of the canary, it is off when Interval is 0
+type Options struct {
+	// Addr is the listen address of the service, e.g. :80
+	Addr string
+	// Paths are requested in turn, one per Interval, with their query
+	Paths    []string
+	Interval time.Duration
+	// Timeout bounds every request, 5s when 0
+	Timeout time.Duration
+}
+
+// ParsePaths splits a comma separated list of paths
+func ParsePaths(s string) []string {
+	var paths []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// IsSynthetic reports whether r was sent by a canary
+func IsSynthetic(r *http.Request) bool {
+	return r.Header.Get(Header) == "true"
+}
+
+// Run requests the paths until ctx is done. The outcome is recorded in the
+// <namespace>_canary_requests_total counter and the
+// <namespace>_canary_request_duration_seconds histogram, labelled
+// synthetic="true" like the traces of the requests.
+func Run(ctx context.Context, namespace string, opts Options, logger log.Logger) {
+	if opts.Interval <= 0 || len(opts.Paths) == 0 {
+		return
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	logger = log.With(logger, "component", "canary")
+
+	constLabels := prometheus.Labels{"synthetic": "true"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "canary",
+		Name:        "requests_total",
+		Help:        "Number of synthetic requests sent by the canary, by result",
+		ConstLabels: constLabels,
+	}, []string{"endpoint", "result"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   "canary",
+		Name:        "request_duration_seconds",
+		Help:        "Duration of the synthetic requests sent by the canary",
+		ConstLabels: constLabels,
+	}, []string{"endpoint"})
+	prometheus.MustRegister(requests, duration)
+
+	base := baseURL(opts.Addr)
+	// not instrumented, the service traces the requests it receives
+	client := &http.Client{Timeout: opts.Timeout}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		path := opts.Paths[i%len(opts.Paths)]
+		endpoint := strings.SplitN(path, "?", 2)[0]
+
+		begin := time.Now()
+		err := probe(ctx, client, base+path)
+		duration.WithLabelValues(endpoint).Observe(time.Since(begin).Seconds())
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+			level.Warn(logger).Log("path", path, "err", err)
+		}
+		requests.WithLabelValues(endpoint, result).Inc()
+	}
+}
+
+// probe fails on transport errors and on any status but 2xx
+func probe(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(Header, "true")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{resp.StatusCode}
+	}
+	return nil
+}
+
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("returned %d %s", e.status, http.StatusText(e.status))
+}
+
+// baseURL turns the listen address into the URL of the local listener
+func baseURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
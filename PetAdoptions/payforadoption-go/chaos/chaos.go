@@ -0,0 +1,142 @@
+// Package chaos lets workshop facilitators turn degradation scenarios on and
+// off at runtime. A scenario is a named feature flag, running it overrides the
+// flag attributes for a limited duration.
+package chaos
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownScenario = errors.New("unknown chaos scenario")
+	ErrNotRunning      = errors.New("chaos scenario is not running")
+)
+
+const (
+	defaultDuration = 15 * time.Minute
+	maxDuration     = 4 * time.Hour
+)
+
+// Scenario describes a flag that can be overridden through the chaos API
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Run is an active scenario
+type Run struct {
+	Scenario  string                 `json:"scenario"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	StartedAt time.Time              `json:"startedAt"`
+	ExpiresAt time.Time              `json:"expiresAt"`
+}
+
+// Controller keeps track of the running scenarios, runs expire on their own so
+// a forgotten scenario does not outlive the workshop
+type Controller struct {
+	mu        sync.Mutex
+	scenarios map[string]Scenario
+	runs      map[string]Run
+	now       func() time.Time
+}
+
+func NewController(scenarios ...Scenario) *Controller {
+	c := &Controller{
+		scenarios: map[string]Scenario{},
+		runs:      map[string]Run{},
+		now:       time.Now,
+	}
+	for _, s := range scenarios {
+		c.scenarios[s.Name] = s
+	}
+	return c
+}
+
+// Scenarios lists the available scenarios by name
+func (c *Controller) Scenarios() []Scenario {
+	res := make([]Scenario, 0, len(c.scenarios))
+	for _, s := range c.scenarios {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Start runs the scenario for d, replacing a previous run of the same scenario
+func (c *Controller) Start(name string, params map[string]interface{}, d time.Duration) (Run, error) {
+	if _, ok := c.scenarios[name]; !ok {
+		return Run{}, ErrUnknownScenario
+	}
+
+	if d <= 0 {
+		d = defaultDuration
+	}
+	if d > maxDuration {
+		d = maxDuration
+	}
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	params["enabled"] = true
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	run := Run{Scenario: name, Params: params, StartedAt: now, ExpiresAt: now.Add(d)}
+	c.runs[name] = run
+
+	return run, nil
+}
+
+// Stop ends the scenario before it expires
+func (c *Controller) Stop(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	if _, ok := c.runs[name]; !ok {
+		return ErrNotRunning
+	}
+	delete(c.runs, name)
+
+	return nil
+}
+
+// Status returns the running scenarios
+func (c *Controller) Status() []Run {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	res := make([]Run, 0, len(c.runs))
+	for _, r := range c.runs {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Scenario < res[j].Scenario })
+	return res
+}
+
+// Overrides returns the flag attributes of the running scenarios, keyed by
+// scenario name
+func (c *Controller) Overrides() map[string]map[string]interface{} {
+	res := map[string]map[string]interface{}{}
+	for _, r := range c.Status() {
+		res[r.Scenario] = r.Params
+	}
+	return res
+}
+
+// expire must be called with the lock held
+func (c *Controller) expire() {
+	now := c.now()
+	for name, r := range c.runs {
+		if !now.Before(r.ExpiresAt) {
+			delete(c.runs, name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// ErrBadRequest is returned for malformed start and stop requests
+var ErrBadRequest = errors.New("invalid chaos request")
+
+type startRequest struct {
+	Scenario string                 `json:"scenario"`
+	Params   map[string]interface{} `json:"params"`
+	Duration string                 `json:"duration"`
+}
+
+type stopRequest struct {
+	Scenario string `json:"scenario"`
+}
+
+// RegisterRoutes mounts the /api/chaos endpoints on r. Every endpoint goes
+// through mw, so the caller decides how the API is authenticated, and the
+// options carry the caller's error encoding.
+func RegisterRoutes(r *mux.Router, c *Controller, mw endpoint.Middleware, options ...httptransport.ServerOption) {
+	r.Methods("GET").Path("/api/chaos/scenarios").Handler(httptransport.NewServer(
+		mw(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return c.Scenarios(), nil
+		}),
+		decodeEmptyRequest,
+		encodeJSONResponse,
+		options...,
+	))
+
+	r.Methods("GET").Path("/api/chaos/status").Handler(httptransport.NewServer(
+		mw(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return c.Status(), nil
+		}),
+		decodeEmptyRequest,
+		encodeJSONResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/api/chaos/start").Handler(httptransport.NewServer(
+		mw(func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(startRequest)
+			var d time.Duration
+			if req.Duration != "" {
+				var err error
+				if d, err = time.ParseDuration(req.Duration); err != nil {
+					return nil, ErrBadRequest
+				}
+			}
+			return c.Start(req.Scenario, req.Params, d)
+		}),
+		decodeStartRequest,
+		encodeJSONResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/api/chaos/stop").Handler(httptransport.NewServer(
+		mw(func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err := c.Stop(request.(stopRequest).Scenario); err != nil {
+				return nil, err
+			}
+			return c.Status(), nil
+		}),
+		decodeStopRequest,
+		encodeJSONResponse,
+		options...,
+	))
+}
+
+func decodeEmptyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeStartRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req startRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Scenario == "" {
+		return nil, ErrBadRequest
+	}
+	return req, nil
+}
+
+func decodeStopRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req stopRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Scenario == "" {
+		return nil, ErrBadRequest
+	}
+	return req, nil
+}
+
+func encodeJSONResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
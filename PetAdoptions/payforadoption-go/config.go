@@ -4,15 +4,28 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/paramcache"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/slo"
 	"net/url"
 	"os"
+	"petadoptions/awsmetrics"
+	"petadoptions/dbsecret"
+	"petadoptions/flags"
+	"petadoptions/httpclient"
+	"petadoptions/logging"
 	"petadoptions/payforadoption"
+	"petadoptions/servertls"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
 	"github.com/spf13/viper"
 )
 
@@ -23,69 +36,242 @@ type dbConfig struct {
 
 // config is injected as environment variable
 
+// configCache shares the parameters between the tasks, nil
+// unless CONFIG_CACHE_REDIS_ADDR is set
+var configCache *paramcache.Cache
+
+func newConfigCache(logger log.Logger) *paramcache.Cache {
+	viper.AutomaticEnv()
+
+	return paramcache.New("payforadoption", paramcache.Options{
+		Addr: viper.GetString("CONFIG_CACHE_REDIS_ADDR"),
+		TLS:  viper.GetBool("CONFIG_CACHE_REDIS_TLS"),
+		TTL:  viper.GetDuration("CONFIG_CACHE_TTL"),
+	}, logger)
+}
+
 func fetchConfig() (payforadoption.Config, error) {
 
 	// fetch from env
 	viper.AutomaticEnv() // Bind automatically all env vars that have the same prefix
 
 	cfg := payforadoption.Config{
-		UpdateAdoptionURL: viper.GetString("UPDATE_ADOPTION_URL"),
-		RDSSecretArn:      viper.GetString("RDS_SECRET_ARN"),
-		AWSRegion:         viper.GetString("AWS_REGION"),
+		UpdateAdoptionURL:   viper.GetString("UPDATE_ADOPTION_URL"),
+		RDSSecretArn:        viper.GetString("RDS_SECRET_ARN"),
+		S3BucketName:        viper.GetString("S3_BUCKET_NAME"),
+		EventBusName:        viper.GetString("EVENT_BUS_NAME"),
+		SNSTopicArn:         viper.GetString("SNS_TOPIC_ARN"),
+		StorageBackend:      viper.GetString("STORAGE_BACKEND"),
+		TransactionsTable:   viper.GetString("TRANSACTIONS_TABLE_NAME"),
+		ArchiveMode:         viper.GetString("CLEANUP_ARCHIVE_MODE"),
+		AuditLogGroup:       viper.GetString("AUDIT_LOG_GROUP"),
+		AllowedRoleArns:     splitList(viper.GetString("ALLOWED_ROLE_ARNS")),
+		AllowDiskPressure:   viper.GetBool("ALLOW_DISK_PRESSURE"),
+		AllowScenarioHeader: viper.GetBool("ALLOW_SCENARIO_HEADER"),
+		DBAuthMode:          viper.GetString("DB_AUTH_MODE"),
+		DBIAMUser:           viper.GetString("DB_IAM_USER"),
+		DBSSLMode:           viper.GetString("DB_SSLMODE"),
+		DBSSLRootCert:       viper.GetString("DB_SSLROOTCERT"),
+		DBConnectTimeout:    viper.GetInt("DB_CONNECT_TIMEOUT"),
+		DBProxyEndpoint:     viper.GetString("DB_PROXY_ENDPOINT"),
+		LogLevel:            viper.GetString("LOG_LEVEL"),
+		AWSRegion:           viper.GetString("AWS_REGION"),
+		RequestTimeout:      viper.GetDuration("REQUEST_TIMEOUT"),
+		NativeHistograms:    viper.GetBool("NATIVE_HISTOGRAMS"),
+
+		AccessLogSampleRate: 1,
+	}
+
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = 10 * time.Second
+	}
+	cfg.HTTPClient = httpclient.Options{
+		Timeout:             viper.GetDuration("HTTP_CLIENT_TIMEOUT"),
+		MaxIdleConnsPerHost: viper.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST"),
+		MaxConnsPerHost:     viper.GetInt("HTTP_MAX_CONNS_PER_HOST"),
+	}
+	if viper.IsSet("ACCESS_LOG_SAMPLE_RATE") {
+		cfg.AccessLogSampleRate = viper.GetFloat64("ACCESS_LOG_SAMPLE_RATE")
+		if cfg.AccessLogSampleRate < 0 || cfg.AccessLogSampleRate > 1 {
+			return cfg, fmt.Errorf("ACCESS_LOG_SAMPLE_RATE must be between 0 and 1, got %v", cfg.AccessLogSampleRate)
+		}
+	}
+
+	buckets, err := httpmetrics.ParseBuckets(viper.GetString("LATENCY_BUCKETS"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LatencyBuckets = buckets
+
+	if cfg.SamplingRules, err = payforadoption.ParseSamplingRules(viper.GetString("SAMPLING_RULES")); err != nil {
+		return cfg, err
 	}
 
-	if cfg.UpdateAdoptionURL == "" || cfg.RDSSecretArn == "" {
-		return fetchConfigFromParameterStore(cfg.AWSRegion)
+	if cfg.UpdateAdoptionURL == "" || (cfg.RDSSecretArn == "" && !cfg.UsesDynamoDB()) {
+		return fetchConfigFromParameterStore(cfg)
 	}
 
 	return cfg, nil
 }
 
-func fetchConfigFromParameterStore(region string) (payforadoption.Config, error) {
+func fetchConfigFromParameterStore(envCfg payforadoption.Config) (payforadoption.Config, error) {
+	region := envCfg.AWSRegion
 	svc := ssm.New(session.New(&aws.Config{Region: aws.String(region)}))
 	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
 	ctx, seg := xray.BeginSegment(context.Background(), "payforadoption")
 	defer seg.Close(nil)
 
-	res, err := svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{
-		Names: []*string{
-			aws.String("/petstore/updateadoptionstatusurl"),
-			aws.String("/petstore/rdssecretarn"),
-			aws.String("/petstore/s3bucketname"),
-			aws.String("/petstore/dynamodbtablename"),
-		},
+	params, err := configCache.GetParameters(ctx, svc, []string{
+		"/petstore/updateadoptionstatusurl",
+		"/petstore/rdssecretarn",
+		"/petstore/s3bucketname",
+		"/petstore/dynamodbtablename",
+		"/petstore/eventbusname",
+		"/petstore/snsarn",
+		"/petstore/transactionstablename",
+		"/petstore/cleanuparchivemode",
+		"/petstore/auditloggroup",
+		"/petstore/allowedrolearns",
+		"/petstore/loglevel",
+		"/petstore/latencybuckets",
+		"/petstore/samplingrules",
 	})
 
 	cfg := payforadoption.Config{}
 	cfg.AWSRegion = region
+	cfg.StorageBackend = envCfg.StorageBackend
+	// filling the disk stays a per deployment decision
+	cfg.AllowDiskPressure = envCfg.AllowDiskPressure
+	cfg.AllowScenarioHeader = envCfg.AllowScenarioHeader
+	cfg.DBAuthMode = envCfg.DBAuthMode
+	cfg.DBIAMUser = envCfg.DBIAMUser
+	cfg.DBSSLMode = envCfg.DBSSLMode
+	cfg.DBSSLRootCert = envCfg.DBSSLRootCert
+	cfg.DBConnectTimeout = envCfg.DBConnectTimeout
+	cfg.DBProxyEndpoint = envCfg.DBProxyEndpoint
+	cfg.RequestTimeout = envCfg.RequestTimeout
+	cfg.LatencyBuckets = envCfg.LatencyBuckets
+	cfg.NativeHistograms = envCfg.NativeHistograms
+	cfg.AccessLogSampleRate = envCfg.AccessLogSampleRate
+	cfg.HTTPClient = envCfg.HTTPClient
+	cfg.SamplingRules = envCfg.SamplingRules
 
 	if err != nil {
 		return cfg, err
 	}
 
-	for _, p := range res.Parameters {
+	for name, value := range params {
 
-		switch aws.StringValue(p.Name) {
+		switch name {
 		case "/petstore/rdssecretarn":
-			cfg.RDSSecretArn = aws.StringValue(p.Value)
+			cfg.RDSSecretArn = value
 		case "/petstore/updateadoptionstatusurl":
-			cfg.UpdateAdoptionURL = aws.StringValue(p.Value)
+			cfg.UpdateAdoptionURL = value
 		case "/petstore/s3bucketname":
-			cfg.S3BucketName = aws.StringValue(p.Value)
+			cfg.S3BucketName = value
 		case "/petstore/dynamodbtablename":
-			cfg.DynamoDBTable = aws.StringValue(p.Value)
+			cfg.DynamoDBTable = value
+		case "/petstore/eventbusname":
+			cfg.EventBusName = value
+		case "/petstore/snsarn":
+			cfg.SNSTopicArn = value
+		case "/petstore/transactionstablename":
+			cfg.TransactionsTable = value
+		case "/petstore/cleanuparchivemode":
+			cfg.ArchiveMode = value
+		case "/petstore/auditloggroup":
+			cfg.AuditLogGroup = value
+		case "/petstore/allowedrolearns":
+			cfg.AllowedRoleArns = splitList(value)
+		case "/petstore/loglevel":
+			cfg.LogLevel = value
+		case "/petstore/latencybuckets":
+			// buckets set on the task win over the shared parameter
+			if len(cfg.LatencyBuckets) > 0 {
+				continue
+			}
+			if cfg.LatencyBuckets, err = httpmetrics.ParseBuckets(value); err != nil {
+				return cfg, err
+			}
+		case "/petstore/samplingrules":
+			// rules set on the task win over the shared parameter
+			if cfg.SamplingRules != nil {
+				continue
+			}
+			if cfg.SamplingRules, err = payforadoption.ParseSamplingRules(value); err != nil {
+				return cfg, err
+			}
 		}
 	}
 
+	// a level set on the task wins over the shared parameter
+	if envCfg.LogLevel != "" {
+		cfg.LogLevel = envCfg.LogLevel
+	}
+
 	return cfg, err
 }
 
+// newSLOTracker tracks the endpoints against the objectives of SLO_OBJECTIVES
+// over SLO_PERIOD, the defaults of the slo package otherwise
+func newSLOTracker() (*slo.Tracker, error) {
+	viper.AutomaticEnv()
+
+	objectives, err := slo.ParseObjectives(viper.GetString("SLO_OBJECTIVES"))
+	if err != nil {
+		return nil, err
+	}
+	return slo.New("payforadoption", objectives, viper.GetDuration("SLO_PERIOD"))
+}
+
+// serverTLS reads the certificate of the HTTPS listener from TLS_CERT_FILE and
+// TLS_KEY_FILE, or from the PEM values of TLS_CERT and TLS_KEY, and the CAs of
+// the clients from TLS_CLIENT_CA_FILE
+func serverTLS() servertls.Options {
+	viper.AutomaticEnv()
+
+	return servertls.Options{
+		CertFile:     viper.GetString("TLS_CERT_FILE"),
+		KeyFile:      viper.GetString("TLS_KEY_FILE"),
+		CertPEM:      viper.GetString("TLS_CERT"),
+		KeyPEM:       viper.GetString("TLS_KEY"),
+		ClientCAFile: viper.GetString("TLS_CLIENT_CA_FILE"),
+	}
+}
+
+// logRedaction reads the personal data fields to mask from LOG_REDACT_FIELDS,
+// LOG_COMPLIANCE_MODE=true drops them from the records instead
+func logRedaction() logging.Redaction {
+	r := logging.Redaction{
+		Fields:         logging.DefaultRedactedFields,
+		ComplianceMode: os.Getenv("LOG_COMPLIANCE_MODE") == "true",
+	}
+	if v := os.Getenv("LOG_REDACT_FIELDS"); v != "" {
+		r.Fields = splitList(v)
+	}
+	return r
+}
+
+// splitList parses comma separated values, as used by SSM StringList parameters
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
 func getSecretValue(secretID, region string) (string, error) {
 
 	svc := secretsmanager.New(session.New(&aws.Config{Region: aws.String(region)}))
 	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
 	ctx, seg := xray.BeginSegment(context.Background(), "payforadoption")
 
+	// the secret is not shared through the cache, Redis would hold it in clear
 	res, err := svc.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
 		SecretId: aws.String(secretID),
 	})
@@ -98,9 +284,11 @@ func getSecretValue(secretID, region string) (string, error) {
 	return aws.StringValue(res.SecretString), nil
 }
 
-// Call aws secrets manager and return parsed sql server query str
-func getRDSConnectionString(secretid string) (string, error) {
-	jsonstr, err := getSecretValue(secretid, os.Getenv("AWS_REGION"))
+// Call aws secrets manager and return parsed sql server query str. With IAM
+// authentication the secret only locates the database, the password is a token.
+func getRDSConnectionString(cfg payforadoption.Config) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	jsonstr, err := getSecretValue(cfg.RDSSecretArn, region)
 	if err != nil {
 		return "", err
 	}
@@ -111,12 +299,76 @@ func getRDSConnectionString(secretid string) (string, error) {
 		return "", err
 	}
 
+	// the proxy accepts the same credentials as the cluster it fronts
+	if cfg.UsesRDSProxy() {
+		c.Host = cfg.DBProxyEndpoint
+	}
+
 	u := &url.URL{
-		Scheme: c.Engine,
-		User:   url.UserPassword(c.Username, c.Password),
-		Host:   fmt.Sprintf("%s:%d", c.Host, c.Port),
-		Path:   c.Dbname,
+		Scheme:   c.Engine,
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:     c.Dbname,
+		RawQuery: connectionParams(cfg).Encode(),
+	}
+
+	if cfg.UsesIAMAuth() {
+		user := c.Username
+		if cfg.DBIAMUser != "" {
+			user = cfg.DBIAMUser
+		}
+
+		token, err := dbsecret.IAMAuthToken(c.Host, c.Port, user, region)
+		if err != nil {
+			return "", err
+		}
+
+		u.User = url.UserPassword(user, token)
 	}
 
 	return u.String(), nil
 }
+
+// connectionParams sets the TLS and timeout options, lib/pq defaults to
+// sslmode=require when none is configured
+func connectionParams(cfg payforadoption.Config) url.Values {
+	params := url.Values{}
+
+	sslmode := cfg.DBSSLMode
+	// IAM authentication is only accepted over TLS
+	if cfg.UsesIAMAuth() && (sslmode == "" || sslmode == "disable" || sslmode == "allow" || sslmode == "prefer") {
+		sslmode = "require"
+	}
+	if sslmode != "" {
+		params.Set("sslmode", sslmode)
+	}
+	if cfg.DBSSLRootCert != "" {
+		params.Set("sslrootcert", cfg.DBSSLRootCert)
+	}
+	if cfg.DBConnectTimeout > 0 {
+		params.Set("connect_timeout", strconv.Itoa(cfg.DBConnectTimeout))
+	}
+	// send parameters inline instead of preparing statements, which would pin
+	// the proxy connection to the session
+	if cfg.UsesRDSProxy() {
+		params.Set("binary_parameters", "yes")
+	}
+
+	return params
+}
+
+// feature flags come from AppConfig when the profile is configured, the legacy
+// error mode parameter is used otherwise
+func newFlagSource(region string) flags.Source {
+	viper.SetDefault("APPCONFIG_AGENT_URL", "http://localhost:2772")
+
+	app := viper.GetString("APPCONFIG_APPLICATION")
+	env := viper.GetString("APPCONFIG_ENVIRONMENT")
+	profile := viper.GetString("APPCONFIG_PROFILE")
+
+	if app == "" || env == "" || profile == "" {
+		return flags.NewSSMSource(region)
+	}
+
+	return flags.NewAppConfigAgentSource(viper.GetString("APPCONFIG_AGENT_URL"), app, env, profile)
+}
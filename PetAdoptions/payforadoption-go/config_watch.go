@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"petadoptions/payforadoption"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// watchConfig polls the configuration sources every interval (plus up to 20%
+// jitter so tasks don't hit parameter store in lockstep) and swaps the store
+// content when a parameter changed
+func watchConfig(ctx context.Context, store *payforadoption.ConfigStore, interval time.Duration, logger log.Logger) {
+	logger = log.With(logger, "component", "configwatcher")
+
+	for {
+		jitter := time.Duration(rand.Int63n(int64(interval)/5 + 1))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval + jitter):
+		}
+
+		cfg, err := fetchConfig()
+		if err != nil {
+			level.Error(logger).Log("err", err)
+			continue
+		}
+
+		if reflect.DeepEqual(cfg, store.Load()) {
+			continue
+		}
+
+		logger.Log("config", "updated")
+		store.Store(cfg)
+	}
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"petadoptions/awsmetrics"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	source = "petstore.payforadoption"
+
+	AdoptionCompleted = "AdoptionCompleted"
+	AdoptionFailed    = "AdoptionFailed"
+	AdoptionCleanup   = "AdoptionCleanup"
+	SeedingTriggered  = "SeedingTriggered"
+)
+
+// Publisher emits adoption lifecycle events
+type Publisher interface {
+	Publish(ctx context.Context, detailType string, data interface{}) error
+}
+
+// detail wraps the event data with the X-Ray trace context so consumers can
+// continue the trace
+type detail struct {
+	Data        interface{} `json:"data,omitempty"`
+	TraceID     string      `json:"traceId,omitempty"`
+	TraceHeader string      `json:"traceHeader,omitempty"`
+}
+
+type publisher struct {
+	svc     *eventbridge.EventBridge
+	busName string
+	logger  log.Logger
+}
+
+// NewPublisher returns a Publisher writing to the given EventBridge bus.
+// Events are dropped when no bus is configured.
+func NewPublisher(busName, region string, logger log.Logger) Publisher {
+	logger = log.With(logger, "events", "eventbridge")
+
+	if busName == "" {
+		return nopPublisher{}
+	}
+
+	svc := eventbridge.New(session.New(&aws.Config{Region: aws.String(region)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
+
+	return &publisher{
+		svc:     svc,
+		busName: busName,
+		logger:  logger,
+	}
+}
+
+func (p *publisher) Publish(ctx context.Context, detailType string, data interface{}) error {
+	d := detail{Data: data}
+
+	if seg := xray.GetSegment(ctx); seg != nil {
+		h := seg.DownstreamHeader()
+		d.TraceID = h.TraceID
+		d.TraceHeader = h.String()
+	}
+
+	body, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.svc.PutEventsWithContext(ctx, &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{
+			{
+				EventBusName: aws.String(p.busName),
+				Source:       aws.String(source),
+				DetailType:   aws.String(detailType),
+				Detail:       aws.String(string(body)),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if aws.Int64Value(res.FailedEntryCount) > 0 {
+		return fmt.Errorf("unable to publish %s: %s", detailType, aws.StringValue(res.Entries[0].ErrorMessage))
+	}
+
+	p.logger.Log("detailType", detailType, "traceId", d.TraceID)
+	return nil
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, string, interface{}) error { return nil }
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SwappablePublisher delegates to a Publisher that can be replaced at
+// runtime, for instance when the event bus name changes
+type SwappablePublisher struct {
+	v atomic.Value
+}
+
+func NewSwappablePublisher(p Publisher) *SwappablePublisher {
+	s := &SwappablePublisher{}
+	s.Swap(p)
+	return s
+}
+
+func (s *SwappablePublisher) Swap(p Publisher) {
+	s.v.Store(&p)
+}
+
+func (s *SwappablePublisher) Publish(ctx context.Context, detailType string, data interface{}) error {
+	return (*s.v.Load().(*Publisher)).Publish(ctx, detailType, data)
+}
+
+// SwappableNotifier delegates to a Notifier that can be replaced at runtime
+type SwappableNotifier struct {
+	v atomic.Value
+}
+
+func NewSwappableNotifier(n Notifier) *SwappableNotifier {
+	s := &SwappableNotifier{}
+	s.Swap(n)
+	return s
+}
+
+func (s *SwappableNotifier) Swap(n Notifier) {
+	s.v.Store(&n)
+}
+
+func (s *SwappableNotifier) Notify(ctx context.Context, subject, message string, attributes map[string]string) error {
+	return (*s.v.Load().(*Notifier)).Notify(ctx, subject, message, attributes)
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+
+	"petadoptions/awsmetrics"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+)
+
+// Notifier fans out a message to the subscribers of an SNS topic
+type Notifier interface {
+	Notify(ctx context.Context, subject, message string, attributes map[string]string) error
+}
+
+type notifier struct {
+	svc      *sns.SNS
+	topicArn string
+	logger   log.Logger
+}
+
+// NewNotifier returns a Notifier publishing to the given SNS topic.
+// Notifications are dropped when no topic is configured.
+func NewNotifier(topicArn, region string, logger log.Logger) Notifier {
+	if topicArn == "" {
+		return nopNotifier{}
+	}
+
+	svc := sns.New(session.New(&aws.Config{Region: aws.String(region)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
+
+	return &notifier{
+		svc:      svc,
+		topicArn: topicArn,
+		logger:   log.With(logger, "events", "sns"),
+	}
+}
+
+func (n *notifier) Notify(ctx context.Context, subject, message string, attributes map[string]string) error {
+	attrs := make(map[string]*sns.MessageAttributeValue, len(attributes))
+	for k, v := range attributes {
+		// SNS rejects empty attribute values
+		if v == "" {
+			continue
+		}
+		attrs[k] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+
+	res, err := n.svc.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(n.topicArn),
+		Subject:           aws.String(subject),
+		Message:           aws.String(message),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		return err
+	}
+
+	n.logger.Log("subject", subject, "messageId", aws.StringValue(res.MessageId))
+	return nil
+}
+
+type nopNotifier struct{}
+
+func (nopNotifier) Notify(context.Context, string, string, map[string]string) error { return nil }
@@ -0,0 +1,48 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// appConfigAgentSource reads a feature flag configuration profile through
+// the AppConfig Agent sidecar, which handles the AppConfig sessions and caching
+type appConfigAgentSource struct {
+	url    string
+	client *http.Client
+}
+
+func NewAppConfigAgentSource(agentURL, application, environment, profile string) Source {
+	return &appConfigAgentSource{
+		url: fmt.Sprintf("%s/applications/%s/environments/%s/configurations/%s",
+			agentURL, application, environment, profile),
+		client: &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+func (s *appConfigAgentSource) Fetch(ctx context.Context) (Flags, error) {
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("appconfig agent returned %s", resp.Status)
+	}
+
+	var f Flags
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,163 @@
+package flags
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Known flag names
+const (
+	ErrorMode        = "errorMode"
+	SlowMode         = "slowMode"
+	ChaosScenario    = "chaosScenario"
+	LatencyInjection = "latencyInjection"
+	ErrorInjection   = "errorInjection"
+)
+
+// Flag holds the attributes of a feature flag as defined in AppConfig,
+// e.g. {"enabled": true, "delayMs": 500}
+type Flag map[string]interface{}
+
+func (f Flag) Enabled() bool {
+	v, _ := f["enabled"].(bool)
+	return v
+}
+
+// String returns a string attribute, or "" when missing
+func (f Flag) String(attr string) string {
+	v, _ := f[attr].(string)
+	return v
+}
+
+// Int returns a numeric attribute, or def when missing
+func (f Flag) Int(attr string, def int) int {
+	if v, ok := f[attr].(float64); ok {
+		return int(v)
+	}
+	return def
+}
+
+// Flags is a snapshot of all the flags keyed by name
+type Flags map[string]Flag
+
+// Get never returns nil, unknown flags are disabled
+func (f Flags) Get(name string) Flag {
+	if flag, ok := f[name]; ok {
+		return flag
+	}
+	return Flag{}
+}
+
+// Source fetches the current flags from a configuration backend
+type Source interface {
+	Fetch(ctx context.Context) (Flags, error)
+}
+
+// Client serves flags from memory and refreshes them in the background,
+// keeping configuration lookups off the request path. Flags older than ttl
+// are still served, but counted as stale reads.
+type Client struct {
+	updated  int64 // unix nanoseconds of the last successful refresh, first for 64-bit alignment
+	source   Source
+	interval time.Duration
+	ttl      time.Duration
+	logger   log.Logger
+	reads    metrics.Counter
+	flags    atomic.Value
+	overlay  func() Flags
+}
+
+// NewClient performs a first fetch so flags are available right away
+func NewClient(ctx context.Context, source Source, interval, ttl time.Duration, logger log.Logger) *Client {
+	c := &Client{
+		source:   source,
+		interval: interval,
+		ttl:      ttl,
+		logger:   log.With(logger, "component", "flags"),
+		reads: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "payforadoption",
+			Subsystem: "flags",
+			Name:      "reads_total",
+			Help:      "Number of flag reads by cache result: hit, miss or stale",
+		}, []string{"result"}),
+	}
+	c.flags.Store(Flags{})
+	c.refresh(ctx)
+	return c
+}
+
+// SetOverlay registers runtime overrides, e.g. from the chaos API, which take
+// precedence over the flags read from the source. It must be called before
+// the client is shared.
+func (c *Client) SetOverlay(overlay func() Flags) {
+	c.overlay = overlay
+}
+
+// Flags returns the last known flags
+func (c *Client) Flags() Flags {
+	f := c.flags.Load().(Flags)
+	c.reads.With("result", c.cacheResult()).Add(1)
+	if c.overlay == nil {
+		return f
+	}
+
+	overrides := c.overlay()
+	if len(overrides) == 0 {
+		return f
+	}
+
+	merged := make(Flags, len(f)+len(overrides))
+	for name, flag := range f {
+		merged[name] = flag
+	}
+	for name, flag := range overrides {
+		merged[name] = flag
+	}
+	return merged
+}
+
+// Run polls the source until ctx is done
+func (c *Client) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh(ctx)
+		}
+	}
+}
+
+// refresh keeps serving the previous flags when the source is unavailable
+func (c *Client) refresh(ctx context.Context) {
+	f, err := c.source.Fetch(ctx)
+	if err != nil {
+		level.Error(c.logger).Log("err", err)
+		return
+	}
+	c.flags.Store(f)
+	atomic.StoreInt64(&c.updated, time.Now().UnixNano())
+}
+
+// cacheResult is a miss until the first successful refresh
+func (c *Client) cacheResult() string {
+	updated := atomic.LoadInt64(&c.updated)
+	switch {
+	case updated == 0:
+		return "miss"
+	case time.Since(time.Unix(0, updated)) > c.ttl:
+		return "stale"
+	default:
+		return "hit"
+	}
+}
@@ -0,0 +1,73 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+
+	"petadoptions/awsmetrics"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	errorModeParameter = "/petstore/errormode1"
+	scenarioParameter  = "/petstore/degradation_scenario"
+	latencyParameter   = "/petstore/latencyinjection"
+	errorsParameter    = "/petstore/errorinjection"
+)
+
+// jsonParameters maps the parameters holding a whole flag to the flag name
+var jsonParameters = map[string]string{
+	latencyParameter: LatencyInjection,
+	errorsParameter:  ErrorInjection,
+}
+
+// ssmSource maps the legacy parameters to the errorMode and chaosScenario
+// flags, for stacks that are not deployed with AppConfig. The latency and error
+// injection parameters hold the flag as JSON.
+type ssmSource struct {
+	svc *ssm.SSM
+}
+
+func NewSSMSource(region string) Source {
+	svc := ssm.New(session.New(&aws.Config{Region: aws.String(region)}))
+	awsmetrics.Instrument(&svc.Handlers)
+
+	return &ssmSource{svc: svc}
+}
+
+func (s *ssmSource) Fetch(ctx context.Context) (Flags, error) {
+	// missing parameters are reported as invalid rather than failing the call
+	res, err := s.svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{
+		Names: []*string{
+			aws.String(errorModeParameter),
+			aws.String(scenarioParameter),
+			aws.String(latencyParameter),
+			aws.String(errorsParameter),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f := Flags{}
+	for _, p := range res.Parameters {
+		value := aws.StringValue(p.Value)
+		switch aws.StringValue(p.Name) {
+		case errorModeParameter:
+			f[ErrorMode] = Flag{"enabled": value == "true"}
+		case scenarioParameter:
+			f[ChaosScenario] = Flag{"enabled": value != "" && value != "none", "scenario": value}
+		case latencyParameter, errorsParameter:
+			var flag Flag
+			if err := json.Unmarshal([]byte(value), &flag); err != nil {
+				return nil, err
+			}
+			f[jsonParameters[aws.StringValue(p.Name)]] = flag
+		}
+	}
+
+	return f, nil
+}
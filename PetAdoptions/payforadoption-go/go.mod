@@ -1,20 +1,36 @@
 module petadoptions
 
-go 1.15
+go 1.17
 
 require (
+	github.com/aws-samples/one-observability-demo/PetAdoptions/observability v0.0.0
 	github.com/aws/aws-sdk-go v1.35.28
 	github.com/aws/aws-xray-sdk-go v1.1.0
 	github.com/denisenkom/go-mssqldb v0.9.0
 	github.com/dghubble/sling v1.3.0
 	github.com/go-kit/kit v0.10.0
 	github.com/go-pg/pg/v10 v10.8.0
+	github.com/go-redis/redis/v8 v8.7.1
 	github.com/gofrs/uuid v3.3.0+incompatible
 	github.com/gorilla/mux v1.7.3
 	github.com/guregu/dynamo v1.10.2
 	github.com/jackc/pgx v3.6.2+incompatible // indirect
 	github.com/jackc/pgx/v4 v4.10.1
 	github.com/lib/pq v1.10.0
-	github.com/prometheus/client_golang v1.3.0
+	github.com/prometheus/client_golang v1.14.0
+	github.com/pyroscope-io/client v0.2.3
 	github.com/spf13/viper v1.7.1
+	go.opentelemetry.io/contrib/detectors/aws/ec2 v0.18.0
+	go.opentelemetry.io/contrib/detectors/aws/ecs v0.18.0
+	go.opentelemetry.io/contrib/detectors/aws/eks v0.18.0
+	go.opentelemetry.io/otel v0.18.0
+	go.opentelemetry.io/otel/exporters/otlp v0.18.0
+	go.opentelemetry.io/otel/metric v0.18.0
+	go.opentelemetry.io/otel/sdk v0.18.0
+	go.opentelemetry.io/otel/sdk/metric v0.18.0
+	google.golang.org/grpc v1.35.0
 )
+
+// the shared module is used from the repository, the images are built with
+// the PetAdoptions directory as context
+replace github.com/aws-samples/one-observability-demo/PetAdoptions/observability => ../observability
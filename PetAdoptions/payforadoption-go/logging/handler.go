@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type levelRequest struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler reports the current level on GET and changes it on PUT with
+// a {"level":"debug"} body
+func LevelHandler(lv *LevelVar) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			var req levelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			l, err := ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			lv.Set(l)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(levelRequest{Level: lv.Level().String()})
+	})
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultRedactedFields are masked when no list is configured
+var DefaultRedactedFields = []string{
+	"userid", "email", "address", "phone", "creditcard", "cardnumber", "password",
+}
+
+// Redaction configures which record fields hold personal data. Keys are
+// matched case insensitively. The values are masked but for their last four
+// characters, or dropped from the records in compliance mode.
+type Redaction struct {
+	Fields         []string
+	ComplianceMode bool
+}
+
+var redacted = kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Name: "log_redacted_fields_total",
+	Help: "Number of log fields masked or dropped because they hold personal data",
+}, []string{"field"})
+
+// NewRedactor applies r to the records, it must sit under log.With like
+// NewFilter so it sees every field
+func NewRedactor(next log.Logger, r Redaction) log.Logger {
+	fields := r.fieldSet()
+	if len(fields) == 0 {
+		return next
+	}
+	return &redactor{next: next, fields: fields, drop: r.ComplianceMode}
+}
+
+// Value applies r to the value of a single field outside of a log record, e.g.
+// an audit record: it is masked, or empty in compliance mode. Fields r does
+// not cover are returned unchanged.
+func (r Redaction) Value(field, v string) string {
+	field = strings.ToLower(field)
+	if v == "" || !r.fieldSet()[field] {
+		return v
+	}
+
+	redacted.With("field", field).Add(1)
+	if r.ComplianceMode {
+		return ""
+	}
+	return mask(v)
+}
+
+func (r Redaction) fieldSet() map[string]bool {
+	fields := map[string]bool{}
+	for _, f := range r.Fields {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			fields[f] = true
+		}
+	}
+	return fields
+}
+
+type redactor struct {
+	next   log.Logger
+	fields map[string]bool
+	drop   bool
+}
+
+func (r *redactor) Log(keyvals ...interface{}) error {
+	out := make([]interface{}, 0, len(keyvals))
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		k, ok := keyvals[i].(string)
+		if !ok || !r.fields[strings.ToLower(k)] {
+			out = append(out, keyvals[i], keyvals[i+1])
+			continue
+		}
+
+		redacted.With("field", strings.ToLower(k)).Add(1)
+		if !r.drop {
+			out = append(out, keyvals[i], mask(keyvals[i+1]))
+		}
+	}
+	if len(keyvals)%2 == 1 {
+		out = append(out, keyvals[len(keyvals)-1])
+	}
+	return r.next.Log(out...)
+}
+
+func mask(v interface{}) string {
+	s := fmt.Sprint(v)
+	if len(s) <= 4 {
+		return "****"
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
@@ -0,0 +1,23 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+)
+
+// WithTrace adds the request id and the X-Ray trace and segment ids of ctx to
+// the records, so CloudWatch Logs Insights and X-Ray can jump from one to the
+// other
+func WithTrace(ctx context.Context, logger log.Logger) log.Logger {
+	if id := RequestID(ctx); id != "" {
+		logger = log.With(logger, "request_id", id)
+	}
+
+	seg := xray.GetSegment(ctx)
+	if seg == nil {
+		return logger
+	}
+	return log.With(logger, "trace_id", seg.DownstreamHeader().TraceID, "span_id", seg.ID)
+}
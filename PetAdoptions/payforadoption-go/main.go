@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -8,9 +9,21 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"petadoptions/canary"
+	"petadoptions/chaos"
+	"petadoptions/dbsecret"
+	"petadoptions/events"
+	"petadoptions/flags"
+	"petadoptions/httpclient"
+	"petadoptions/logging"
+	"petadoptions/observability"
 	"petadoptions/payforadoption"
+	"petadoptions/servertls"
 
+	"github.com/aws/aws-xray-sdk-go/awsplugins/ec2"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/ecs"
 	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
 	"github.com/aws/aws-xray-sdk-go/xray"
@@ -20,30 +33,77 @@ import (
 )
 
 func init() {
-	// conditionally load plugin
+	// conditionally load plugin, the ECS agent sets the metadata endpoint
 	if os.Getenv("ENVIRONMENT") != "development" {
-		ecs.Init()
+		if os.Getenv("ECS_CONTAINER_METADATA_URI") != "" || os.Getenv("ECS_CONTAINER_METADATA_URI_V4") != "" {
+			ecs.Init()
+		} else {
+			ec2.Init()
+		}
+	}
+
+	emitter, err := observability.TraceEmitter(os.Getenv("TRACE_EXPORTER"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
 	}
 
 	xray.Configure(xray.Config{
 		ContextMissingStrategy: ctxmissing.NewDefaultLogErrorStrategy(),
+		Emitter:                emitter,
+		ServiceVersion:         os.Getenv("SERVICE_VERSION"),
 	})
 }
 
 func main() {
 	var (
-		httpAddr = flag.String("http.addr", ":80", "HTTP Port binding")
+		httpAddr       = flag.String("http.addr", ":80", "HTTP Port binding")
+		httpsAddr      = flag.String("https.addr", ":443", "HTTPS Port binding, used when a certificate is configured")
+		adminAddr      = flag.String("admin.addr", ":9090", "Metrics and pprof port binding")
+		configRefresh  = flag.Duration("config.refresh", time.Minute, "Parameter store polling interval, 0 to disable")
+		flagsRefresh   = flag.Duration("flags.refresh", 30*time.Second, "Feature flags polling interval")
+		flagsTTL       = flag.Duration("flags.ttl", 2*time.Minute, "Age after which cached feature flags are reported as stale")
+		canaryInterval = flag.Duration("canary.interval", 0, "Interval of the synthetic requests to the service itself, 0 to disable")
+		canaryPaths    = flag.String("canary.paths", "/health/status,/api/adoptions/history?limit=5", "Comma separated paths requested by the canary, the authenticated ones fail unless ALLOWED_ROLE_ARNS is empty")
 	)
 
 	flag.Parse()
 
+	// GC, heap and scheduler metrics on /metrics
+	observability.RegisterRuntimeMetrics()
+
+	logLevel := new(logging.LevelVar)
+	logLevel.Set(logging.LevelInfo)
+
 	var logger log.Logger
 	{
-		logger = log.NewJSONLogger(os.Stderr)
-		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = logging.New(os.Stderr, logLevel, logRedaction())
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
+	{
+		shutdown, err := observability.InitMeterProvider(context.Background(), observability.Config{
+			ServiceName: "payforadoption",
+		})
+		if err != nil {
+			level.Error(logger).Log("otel", "metrics", "err", err)
+		} else {
+			defer shutdown(context.Background())
+		}
+	}
+
+	// PROFILER=pyroscope pushes the profiles, the admin port serves them anyway
+	{
+		shutdown, err := observability.StartProfiler(observability.Config{ServiceName: "payforadoption"}, logger)
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		defer shutdown(context.Background())
+	}
+
+	configCache = newConfigCache(logger)
+
 	var cfg payforadoption.Config
 	{
 		var err error
@@ -55,35 +115,155 @@ func main() {
 	}
 
 	var db *sql.DB
-	{
+	if !cfg.UsesDynamoDB() {
 		var err error
 		var connStr string
 
-		connStr, err = getRDSConnectionString(cfg.RDSSecretArn)
+		connStr, err = getRDSConnectionString(cfg)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
 
+		var maxAge time.Duration
+		if cfg.UsesIAMAuth() {
+			maxAge = dbsecret.IAMTokenMaxAge
+		}
+
+		// new connections pick up the rotated credentials
+		dbsecret.Register("postgres-secret", func() (string, error) {
+			return getRDSConnectionString(cfg)
+		}, maxAge, logger)
+
 		//xray as a wrapper for sql.Open
-		db, err = xray.SQLContext("postgres", connStr)
+		db, err = xray.SQLContext("postgres-secret", connStr)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
+
+		// the proxy does the pooling, idle client connections only hold proxy slots
+		if cfg.UsesRDSProxy() {
+			db.SetMaxIdleConns(2)
+			db.SetConnMaxIdleTime(30 * time.Second)
+			db.SetConnMaxLifetime(5 * time.Minute)
+		}
 		defer db.Close()
 	}
 
+	store := payforadoption.NewConfigStore(cfg)
+
+	// LOG_LEVEL or the /petstore/loglevel parameter, the admin endpoint
+	// overrides it until the parameter changes
+	setLogLevel(logLevel, cfg.LogLevel, logger)
+	store.Subscribe(func(prev, next payforadoption.Config) {
+		if prev.LogLevel != next.LogLevel {
+			setLogLevel(logLevel, next.LogLevel, logger)
+		}
+	})
+
+	c := chaos.NewController(
+		chaos.Scenario{Name: flags.ErrorMode, Description: "Memory leak and failure when adopting bunnies"},
+		chaos.Scenario{Name: flags.SlowMode, Description: "Delays adoptions by delayMs"},
+		chaos.Scenario{Name: flags.ChaosScenario, Description: "Runs the degradation named by scenario on adoptions"},
+		chaos.Scenario{Name: flags.LatencyInjection, Description: "Delays percent of the requests by baseMs plus up to jitterMs"},
+		chaos.Scenario{Name: flags.ErrorInjection, Description: "Fails percent of the adoptions with status 500 or 503"},
+	)
+
+	f := flags.NewClient(context.Background(), newFlagSource(cfg.AWSRegion), *flagsRefresh, *flagsTTL, logger)
+	f.SetOverlay(func() flags.Flags {
+		overrides := flags.Flags{}
+		for name, params := range c.Overrides() {
+			overrides[name] = params
+		}
+		return overrides
+	})
+	go f.Run(context.Background())
+
+	{
+		strategy, err := samplingStrategy(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+		if err == nil {
+			strategy, err = newRulesSampler(store, f, strategy)
+		}
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		xray.Configure(xray.Config{SamplingStrategy: strategy})
+	}
+
 	var s payforadoption.Service
 	{
-		repo := payforadoption.NewRepository(db, cfg, logger)
-		s = payforadoption.NewService(logger, repo)
-		s = payforadoption.NewInstrumenting(logger, s)
+		// one pool for the calls to the other services, the config reloads
+		// do not rebuild it
+		client := xray.Client(httpclient.New("payforadoption", cfg.HTTPClient))
+
+		var repo payforadoption.Repository
+		if cfg.UsesDynamoDB() {
+			repo = payforadoption.NewDynamoDBRepository(store, logger, client)
+		} else {
+			repo = payforadoption.NewRepository(db, store, logger, client)
+
+			// the history endpoint reads transactions_history, which is
+			// otherwise only created by seeding
+			ctx, seg := xray.BeginSegment(context.Background(), "payforadoption")
+			err := repo.CreateSQLTable(ctx)
+			seg.Close(err)
+			if err != nil {
+				level.Warn(logger).Log("msg", "unable to create the tables", "err", err)
+			}
+		}
+
+		pub := events.NewSwappablePublisher(events.NewPublisher(cfg.EventBusName, cfg.AWSRegion, logger))
+		n := events.NewSwappableNotifier(events.NewNotifier(cfg.SNSTopicArn, cfg.AWSRegion, logger))
+
+		// recreate the AWS clients whose destination changed
+		store.Subscribe(func(prev, next payforadoption.Config) {
+			if prev.EventBusName != next.EventBusName {
+				pub.Swap(events.NewPublisher(next.EventBusName, next.AWSRegion, logger))
+			}
+			if prev.SNSTopicArn != next.SNSTopicArn {
+				n.Swap(events.NewNotifier(next.SNSTopicArn, next.AWSRegion, logger))
+			}
+		})
+
+		s = payforadoption.NewService(logger, repo, store, pub, n, f)
+
+		sinks := []payforadoption.AuditSink{repo}
+		if cfg.AuditLogGroup != "" {
+			cw, err := payforadoption.NewCloudWatchAuditSink(cfg.AuditLogGroup, cfg.AWSRegion)
+			if err != nil {
+				level.Error(logger).Log("exit", err)
+				os.Exit(-1)
+			}
+			sinks = append(sinks, cw)
+		}
+		s = payforadoption.NewAuditing(logger, s, logRedaction(), sinks...)
+		s = payforadoption.NewInstrumenting(logger, s, os.Getenv("METRICS_SINK"))
+	}
+
+	slos, err := newSLOTracker()
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(-1)
 	}
 
 	var h http.Handler
 	{
-		h = payforadoption.MakeHTTPHandler(s, logger)
+		auth := payforadoption.NewSigV4Authentication(cfg.AllowedRoleArns, logger)
+		// chaos stays closed until roles are allowed, the listener is public
+		chaosAuth := payforadoption.NewRequiredSigV4Authentication(cfg.AllowedRoleArns, logger)
+		requests := httpmetrics.New("payforadoption", httpmetrics.Options{
+			Buckets: cfg.LatencyBuckets,
+			Native:  cfg.NativeHistograms,
+			TraceID: payforadoption.ResponseTraceID,
+			Segment: payforadoption.CustomerSegment,
+		})
+		h = payforadoption.MakeHTTPHandler(s, logger, auth, chaosAuth, f, c, store, slos, requests, cfg.RequestTimeout, cfg.AccessLogSampleRate)
+	}
+
+	if *configRefresh > 0 {
+		go watchConfig(context.Background(), store, *configRefresh, logger)
 	}
 
 	errs := make(chan error)
@@ -98,5 +278,45 @@ func main() {
 		errs <- http.ListenAndServe(*httpAddr, h)
 	}()
 
+	// the plain listener stays up for the load balancer health checks
+	if opts := serverTLS(); opts.Enabled() {
+		tlsConfig, err := servertls.Config(opts, logger)
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		srv := &http.Server{Addr: *httpsAddr, Handler: h, TLSConfig: tlsConfig}
+
+		go func() {
+			logger.Log("transport", "HTTPS", "addr", *httpsAddr, "mtls", opts.ClientCAFile != "")
+			errs <- srv.ListenAndServeTLS("", "")
+		}()
+	}
+
+	go canary.Run(context.Background(), "payforadoption", canary.Options{
+		Addr:     *httpAddr,
+		Paths:    canary.ParsePaths(*canaryPaths),
+		Interval: *canaryInterval,
+	}, logger)
+
+	go func() {
+		logger.Log("transport", "admin", "addr", *adminAddr)
+		errs <- http.ListenAndServe(*adminAddr, payforadoption.MakeAdminHandler(logLevel, slos))
+	}()
+
 	logger.Log("exit", <-errs)
 }
+
+// setLogLevel applies a configured level, an invalid one is reported and ignored
+func setLogLevel(lv *logging.LevelVar, s string, logger log.Logger) {
+	if s == "" {
+		return
+	}
+
+	l, err := logging.ParseLevel(s)
+	if err != nil {
+		level.Error(logger).Log("loglevel", s, "err", err)
+		return
+	}
+	lv.Set(l)
+}
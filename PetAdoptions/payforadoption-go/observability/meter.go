@@ -0,0 +1,69 @@
+package observability
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/sdk/metric/controller/push"
+	"go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+// exporterStartTimeout bounds the connection to the collector at startup
+const exporterStartTimeout = 5 * time.Second
+
+// Config holds the settings of a service, the rest comes from the environment
+type Config struct {
+	ServiceName string
+	// ResourceAttributes are added to the service name, version and
+	// environment
+	ResourceAttributes []attribute.KeyValue
+}
+
+// Shutdown flushes the telemetry still buffered, bounded by the context
+type Shutdown func(context.Context) error
+
+// InitMeterProvider pushes OTel metrics to the ADOT collector over OTLP.
+// Prometheus scraping on /metrics keeps working alongside it.
+func InitMeterProvider(ctx context.Context, cfg Config) (Shutdown, error) {
+	res, err := DetectResource(ctx, cfg.ServiceName, cfg.ResourceAttributes...)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := OTLPDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	// the collector may be absent, starting the exporter must not hang the
+	// service
+	startCtx, cancel := context.WithTimeout(ctx, exporterStartTimeout)
+	exporter, err := otlp.NewExporter(startCtx, driver)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	pusher := push.New(
+		basic.New(
+			simple.NewWithExactDistribution(),
+			exporter,
+		),
+		exporter,
+		push.WithPeriod(10*time.Second),
+		push.WithResource(res),
+	)
+
+	otel.SetMeterProvider(pusher.MeterProvider())
+	pusher.Start()
+
+	return func(ctx context.Context) error {
+		// the last collection is pushed before the exporter closes
+		pusher.Stop()
+		return exporter.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,23 @@
+package observability
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RegisterRuntimeMetrics replaces the Go collector of the default Prometheus
+// registry with one that also reads runtime/metrics: the GC pauses and cycles,
+// the heap broken down by class and the scheduler latencies, the time
+// goroutines wait to run. The memory leak and system stress scenarios show up
+// there before the requests slow down. The goroutine count and the memstats
+// gauges stay as they were.
+func RegisterRuntimeMetrics() {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(
+			collectors.MetricsGC,
+			collectors.MetricsMemory,
+			collectors.MetricsScheduler,
+		),
+	))
+}
@@ -0,0 +1,43 @@
+// Package observability sets up the telemetry the same way in every Go
+// service: resource detection, OTLP export and the exporter selection, driven
+// by the standard OTEL_* variables. payforadoption traces with the X-Ray SDK,
+// OpenTelemetry only carries its metrics.
+package observability
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// Trace exporters selected with TRACE_EXPORTER. The X-Ray SDK only emits to
+// the daemon port, which both the X-Ray daemon and the ADOT collector listen on.
+const (
+	TraceExporterOTLP   = "otlp"
+	TraceExporterXRay   = "xray"
+	TraceExporterStdout = "stdout"
+	TraceExporterNone   = "none"
+)
+
+// TraceEmitter returns the emitter for the selected exporter, nil keeps the
+// SDK default UDP emitter. stdout is rejected, the X-Ray SDK cannot write
+// segments anywhere but the daemon port.
+func TraceEmitter(exporterName string) (xray.Emitter, error) {
+	switch exporterName {
+	case "", TraceExporterOTLP, TraceExporterXRay:
+		return nil, nil
+	case TraceExporterNone:
+		return nopEmitter{}, nil
+	case TraceExporterStdout:
+		return nil, fmt.Errorf("trace exporter %q is not supported by the X-Ray SDK", exporterName)
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", exporterName)
+}
+
+// nopEmitter drops the segments, for running without any collector
+type nopEmitter struct{}
+
+func (nopEmitter) Emit(seg *xray.Segment) {}
+
+func (nopEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
@@ -0,0 +1,203 @@
+package payforadoption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"petadoptions/awsmetrics"
+	"petadoptions/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// AuditRecord describes who called a mutating operation, when and with what outcome
+type AuditRecord struct {
+	TraceID   string    `json:"traceId"`
+	Operation string    `json:"operation"`
+	Actor     string    `json:"actor"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AuditSink persists audit records
+type AuditSink interface {
+	Record(ctx context.Context, rec AuditRecord) error
+}
+
+type auditing struct {
+	logger    log.Logger
+	redaction logging.Redaction
+	sinks     []AuditSink
+	Service
+}
+
+// NewAuditing records every CompleteAdoption, CleanupAdoptions and
+// TriggerSeeding call into the given sinks. The records are redacted like the
+// logs before they reach any sink.
+func NewAuditing(logger log.Logger, s Service, r logging.Redaction, sinks ...AuditSink) Service {
+	return &auditing{
+		logger:    log.With(logger, "middleware", "audit"),
+		redaction: r,
+		sinks:     sinks,
+		Service:   s,
+	}
+}
+
+func (mw *auditing) CompleteAdoption(ctx context.Context, petId, petType, userId string) (a Adoption, err error) {
+	defer func(begin time.Time) {
+		details := fmt.Sprintf("petid=%s pettype=%s transactionid=%s", petId, petType, a.TransactionID)
+		mw.record(ctx, "CompleteAdoption", userId, details, begin, err)
+	}(time.Now())
+
+	return mw.Service.CompleteAdoption(ctx, petId, petType, userId)
+}
+
+func (mw *auditing) CleanupAdoptions(ctx context.Context) (res CleanupResult, err error) {
+	defer func(begin time.Time) {
+		details := fmt.Sprintf("archived=%d deleted=%d", res.Archived, res.Deleted)
+		mw.record(ctx, "CleanupAdoptions", "", details, begin, err)
+	}(time.Now())
+
+	return mw.Service.CleanupAdoptions(ctx)
+}
+
+func (mw *auditing) TriggerSeeding(ctx context.Context, opts SeedOptions) (err error) {
+	defer func(begin time.Time) {
+		details := fmt.Sprintf("count=%d pettypes=%v", opts.Count, opts.PetTypes)
+		mw.record(ctx, "TriggerSeeding", "", details, begin, err)
+	}(time.Now())
+
+	return mw.Service.TriggerSeeding(ctx, opts)
+}
+
+func (mw *auditing) record(ctx context.Context, operation, actor, details string, begin time.Time, err error) {
+	rec := AuditRecord{
+		Operation: operation,
+		Actor:     actor,
+		Details:   details,
+		Timestamp: begin.UTC(),
+	}
+
+	if segment := xray.GetSegment(ctx); segment != nil {
+		rec.TraceID = segment.DownstreamHeader().TraceID
+	}
+
+	// fall back to the caller address when there is no authenticated user
+	if rec.Actor == "" {
+		rec.Actor, _ = ctx.Value(httptransport.ContextKeyRequestXForwardedFor).(string)
+	}
+	if rec.Actor == "" {
+		rec.Actor, _ = ctx.Value(httptransport.ContextKeyRequestRemoteAddr).(string)
+	}
+	rec.UserAgent, _ = ctx.Value(httptransport.ContextKeyRequestUserAgent).(string)
+
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	rec = mw.redact(rec)
+
+	// auditing never fails the audited call
+	for _, sink := range mw.sinks {
+		if err := sink.Record(ctx, rec); err != nil {
+			level.Error(mw.logger).Log("operation", operation, "traceId", rec.TraceID, "err", err)
+		}
+	}
+}
+
+// redact masks the personal data of the record. The actor is a user id, or
+// the caller address when there is none, and is redacted as the userid field.
+// The other fields are matched by their JSON name.
+func (mw *auditing) redact(rec AuditRecord) AuditRecord {
+	rec.Actor = mw.redaction.Value("userid", rec.Actor)
+	rec.TraceID = mw.redaction.Value("traceid", rec.TraceID)
+	rec.UserAgent = mw.redaction.Value("useragent", rec.UserAgent)
+	rec.Details = mw.redaction.Value("details", rec.Details)
+	return rec
+}
+
+// cloudWatchAuditSink writes audit records as JSON events to a CloudWatch Logs group
+type cloudWatchAuditSink struct {
+	svc    *cloudwatchlogs.CloudWatchLogs
+	group  string
+	stream string
+
+	mtx   sync.Mutex
+	token *string
+}
+
+// NewCloudWatchAuditSink creates a log stream dedicated to this task in the given group
+func NewCloudWatchAuditSink(group, region string) (AuditSink, error) {
+	svc := cloudwatchlogs.New(session.New(&aws.Config{Region: aws.String(region)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
+
+	host, _ := os.Hostname()
+	stream := fmt.Sprintf("payforadoption/%s/%d", host, time.Now().Unix())
+
+	_, err := svc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudWatchAuditSink{
+		svc:    svc,
+		group:  group,
+		stream: stream,
+	}, nil
+}
+
+func (s *cloudWatchAuditSink) Record(ctx context.Context, rec AuditRecord) error {
+	msg, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	input := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(s.stream),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{
+			{
+				Message:   aws.String(string(msg)),
+				Timestamp: aws.Int64(rec.Timestamp.UnixNano() / int64(time.Millisecond)),
+			},
+		},
+		SequenceToken: s.token,
+	}
+
+	res, err := s.svc.PutLogEventsWithContext(ctx, input)
+
+	// another writer moved the stream forward, retry once with the expected token
+	if e, ok := err.(*cloudwatchlogs.InvalidSequenceTokenException); ok {
+		input.SequenceToken = e.ExpectedSequenceToken
+		res, err = s.svc.PutLogEventsWithContext(ctx, input)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.token = res.NextSequenceToken
+	return nil
+}
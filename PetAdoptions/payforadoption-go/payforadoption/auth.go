@@ -0,0 +1,335 @@
+package payforadoption
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// authScheme prefixes the Authorization header carrying a base64url encoded,
+// SigV4 presigned sts:GetCallerIdentity URL
+const authScheme = "STS "
+
+// requestHeader is signed into the presigned URL and binds it to one request:
+// its method, path and the hex SHA-256 of its body. A URL captured from one
+// request is rejected by STS when replayed with another.
+const requestHeader = "X-Petstore-Request"
+
+const (
+	presignExpiry = 5 * time.Minute
+	// identityTTL bounds how long a verified URL is trusted without asking
+	// STS again, retries of the same request then cost no STS call
+	identityTTL         = time.Minute
+	maxCachedIdentities = 1024
+)
+
+var stsHostPattern = regexp.MustCompile(`^sts(\.[a-z0-9-]+)?\.amazonaws\.com(\.cn)?$`)
+
+type getCallerIdentityResponse struct {
+	GetCallerIdentityResponse struct {
+		GetCallerIdentityResult struct {
+			Arn     string
+			Account string
+		}
+	}
+}
+
+type sigV4Authenticator struct {
+	allowed map[string]bool
+	client  *http.Client
+	logger  log.Logger
+
+	mu         sync.Mutex
+	identities map[string]cachedIdentity
+}
+
+type cachedIdentity struct {
+	roleArn string
+	expires time.Time
+}
+
+// NewSigV4Authentication only lets through requests signed by one of the
+// allowed IAM roles. Callers presign an sts:GetCallerIdentity request with
+// their credentials and send it as "Authorization: STS <base64url(url)>";
+// replaying it against STS proves the identity without sharing secrets. The
+// URL must sign the X-Petstore-Request header of the request, see SignRequest.
+// Authentication is disabled when no role is allowed.
+func NewSigV4Authentication(allowedRoleArns []string, logger log.Logger) endpoint.Middleware {
+	if len(allowedRoleArns) == 0 {
+		return func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+	}
+	return newSigV4Authenticator(allowedRoleArns, logger).middleware
+}
+
+// NewRequiredSigV4Authentication is NewSigV4Authentication for the APIs that
+// must never be open, such as chaos: every request is forbidden when no role
+// is allowed.
+func NewRequiredSigV4Authentication(allowedRoleArns []string, logger log.Logger) endpoint.Middleware {
+	if len(allowedRoleArns) == 0 {
+		return func(endpoint.Endpoint) endpoint.Endpoint {
+			return func(ctx context.Context, _ interface{}) (interface{}, error) {
+				xray.AddAnnotation(ctx, "AuthFailure", "no allowed roles")
+				return nil, ErrForbidden
+			}
+		}
+	}
+	return newSigV4Authenticator(allowedRoleArns, logger).middleware
+}
+
+func newSigV4Authenticator(allowedRoleArns []string, logger log.Logger) *sigV4Authenticator {
+	a := &sigV4Authenticator{
+		allowed:    map[string]bool{},
+		client:     xray.Client(&http.Client{Timeout: 5 * time.Second}),
+		logger:     log.With(logger, "middleware", "sigv4auth"),
+		identities: map[string]cachedIdentity{},
+	}
+	for _, arn := range allowedRoleArns {
+		a.allowed[strings.TrimSpace(arn)] = true
+	}
+	return a
+}
+
+func (a *sigV4Authenticator) middleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		roleArn, err := a.authenticate(ctx)
+		if err != nil {
+			xray.AddAnnotation(ctx, "AuthFailure", err.Error())
+			level.Warn(a.logger).Log("role", roleArn, "err", err)
+			return nil, err
+		}
+
+		xray.AddAnnotation(ctx, "CallerRole", roleArn)
+		return next(context.WithValue(ctx, callerRoleKey{}, roleArn), request)
+	}
+}
+
+type callerRoleKey struct{}
+
+// callerRole is the role a SigV4 authenticated request was signed by, empty
+// when the request was not authenticated
+func callerRole(ctx context.Context) string {
+	role, _ := ctx.Value(callerRoleKey{}).(string)
+	return role
+}
+
+func (a *sigV4Authenticator) authenticate(ctx context.Context) (string, error) {
+	header, _ := ctx.Value(httptransport.ContextKeyRequestAuthorization).(string)
+	if !strings.HasPrefix(header, authScheme) {
+		return "", ErrUnauthorized
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(header, authScheme))
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+
+	// never replay anything but a signed GetCallerIdentity call to STS
+	u, err := url.Parse(string(raw))
+	if err != nil || u.Scheme != "https" || !stsHostPattern.MatchString(u.Host) {
+		return "", ErrUnauthorized
+	}
+	q := u.Query()
+	if q.Get("Action") != "GetCallerIdentity" || q.Get("X-Amz-Signature") == "" {
+		return "", ErrUnauthorized
+	}
+	if !signsHeader(q.Get("X-Amz-SignedHeaders"), requestHeader) {
+		return "", ErrUnauthorized
+	}
+
+	binding, ok := requestBinding(ctx)
+	if !ok {
+		return "", ErrUnauthorized
+	}
+
+	key := header + "\n" + binding
+	if roleArn, ok := a.cached(key); ok {
+		return roleArn, nil
+	}
+
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req.Header.Set("Accept", "application/json")
+	// STS only accepts the signature when this is the request it was made for
+	req.Header.Set(requestHeader, binding)
+
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", dependencyError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrUnauthorized
+	}
+
+	var identity getCallerIdentityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return "", dependencyError(err)
+	}
+
+	roleArn := roleArnFrom(identity.GetCallerIdentityResponse.GetCallerIdentityResult.Arn)
+	if !a.allowed[roleArn] {
+		return roleArn, ErrForbidden
+	}
+
+	a.remember(key, roleArn, urlExpiry(q))
+	return roleArn, nil
+}
+
+func (a *sigV4Authenticator) cached(key string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, ok := a.identities[key]
+	if !ok || time.Now().After(id.expires) {
+		return "", false
+	}
+	return id.roleArn, true
+}
+
+// remember trusts the identity for identityTTL, never past the expiry of the
+// presigned URL
+func (a *sigV4Authenticator) remember(key, roleArn string, urlExpires time.Time) {
+	now := time.Now()
+	expires := now.Add(identityTTL)
+	if !urlExpires.IsZero() && urlExpires.Before(expires) {
+		expires = urlExpires
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.identities) >= maxCachedIdentities {
+		for k, id := range a.identities {
+			if now.After(id.expires) {
+				delete(a.identities, k)
+			}
+		}
+	}
+	if len(a.identities) < maxCachedIdentities {
+		a.identities[key] = cachedIdentity{roleArn: roleArn, expires: expires}
+	}
+}
+
+// urlExpiry is X-Amz-Date plus X-Amz-Expires, zero when either is missing
+func urlExpiry(q url.Values) time.Time {
+	signed, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return time.Time{}
+	}
+	seconds, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil {
+		return time.Time{}
+	}
+	return signed.Add(time.Duration(seconds) * time.Second)
+}
+
+func signsHeader(signedHeaders, name string) bool {
+	for _, h := range strings.Split(signedHeaders, ";") {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type bodyHashKey struct{}
+
+// populateBodyHash keeps the SHA-256 of the body the authenticated request is
+// bound to, the body is put back for the decoder
+func populateBodyHash(ctx context.Context, r *http.Request) context.Context {
+	body, err := rewindBody(r)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, bodyHashKey{}, sha256Hex(body))
+}
+
+// requestBinding is the X-Petstore-Request value of the request being served
+func requestBinding(ctx context.Context) (string, bool) {
+	method, _ := ctx.Value(httptransport.ContextKeyRequestMethod).(string)
+	path, _ := ctx.Value(httptransport.ContextKeyRequestPath).(string)
+	bodyHash, ok := ctx.Value(bodyHashKey{}).(string)
+	if method == "" || !ok {
+		return "", false
+	}
+	return bindingValue(method, path, bodyHash), true
+}
+
+func bindingValue(method, path, bodyHash string) string {
+	return method + " " + path + " " + bodyHash
+}
+
+// rewindBody reads the body and puts it back for the next reader
+func rewindBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignRequest is the client side of NewSigV4Authentication: a ClientBefore
+// option presigning sts:GetCallerIdentity with the credentials of sess, bound
+// to the method, path and body of the outgoing request
+func SignRequest(sess *session.Session) httptransport.RequestFunc {
+	svc := sts.New(sess)
+	return func(ctx context.Context, r *http.Request) context.Context {
+		body, err := rewindBody(r)
+		if err != nil {
+			return ctx
+		}
+
+		req, _ := svc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+		req.HTTPRequest.Header.Set(requestHeader, bindingValue(r.Method, r.URL.Path, sha256Hex(body)))
+		signed, err := req.Presign(presignExpiry)
+		if err != nil {
+			return ctx
+		}
+
+		r.Header.Set("Authorization", authScheme+base64.RawURLEncoding.EncodeToString([]byte(signed)))
+		return ctx
+	}
+}
+
+// roleArnFrom turns an assumed role session arn
+// (arn:aws:sts::123456789012:assumed-role/Role/session) into the role arn
+// (arn:aws:iam::123456789012:role/Role). Other arns are returned unchanged.
+func roleArnFrom(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return arn
+	}
+
+	role := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")[0]
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], role)
+}
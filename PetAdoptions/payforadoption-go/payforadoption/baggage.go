@@ -0,0 +1,154 @@
+package payforadoption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"net/http"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Baggage entries carried with each request, they let latency be broken down
+// per customer segment across the services
+const (
+	baggageUserID          = attribute.Key("userId")
+	baggageSessionID       = attribute.Key("sessionId")
+	baggageCustomerSegment = attribute.Key("customerSegment")
+	baggageCustomerRegion  = attribute.Key("customerRegion")
+)
+
+// Headers setting the session and the customer segment of a request, the
+// traffic generator and the workshop exercises pick a segment with them
+const (
+	sessionHeader         = "X-Session-Id"
+	customerSegmentHeader = "X-Customer-Segment"
+	customerRegionHeader  = "X-Customer-Region"
+)
+
+// The synthetic segments, a closed set so they can label metrics. One user in
+// premiumPercent is premium.
+var (
+	customerSegments = []string{"free", "premium"}
+	customerRegions  = []string{"ap-southeast", "eu-west", "us-east", "us-west"}
+)
+
+const premiumPercent = 20
+
+var baggageKeys = []attribute.Key{baggageUserID, baggageSessionID, baggageCustomerSegment, baggageCustomerRegion}
+
+// populateBaggage is a router middleware extracting the W3C baggage header and
+// adding the user, the session and the synthetic customer segment and region
+// to it, ahead of the request metrics labelled by them. A session id is
+// generated when the caller sent none.
+func populateBaggage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.Baggage{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		session := r.Header.Get(sessionHeader)
+		if session == "" {
+			session = baggage.Value(ctx, baggageSessionID).AsString()
+		}
+		if session == "" {
+			session = newSessionID()
+		}
+
+		values := []attribute.KeyValue{baggageSessionID.String(session)}
+		userID := r.URL.Query().Get("userId")
+		if userID != "" {
+			values = append(values, baggageUserID.String(userID))
+		}
+
+		// the segment follows the user, the session stands in for anonymous
+		// requests
+		key := userID
+		if key == "" {
+			key = session
+		}
+		segment, region := customerSegment(ctx, r, key)
+		values = append(values,
+			baggageCustomerSegment.String(segment),
+			baggageCustomerRegion.String(region),
+		)
+
+		next.ServeHTTP(w, r.WithContext(baggage.ContextWithValues(ctx, values...)))
+	})
+}
+
+// customerSegment takes the segment and region of the headers, then of the
+// baggage sent by the caller, and otherwise derives them from a hash of key so
+// a user stays in the same segment. Values outside the known ones are ignored.
+func customerSegment(ctx context.Context, r *http.Request, key string) (segment, region string) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+
+	segment = "free"
+	if sum%100 < premiumPercent {
+		segment = "premium"
+	}
+	region = customerRegions[(sum/100)%uint32(len(customerRegions))]
+
+	for _, v := range []string{baggage.Value(ctx, baggageCustomerSegment).AsString(), r.Header.Get(customerSegmentHeader)} {
+		if oneOf(v, customerSegments) {
+			segment = v
+		}
+	}
+	for _, v := range []string{baggage.Value(ctx, baggageCustomerRegion).AsString(), r.Header.Get(customerRegionHeader)} {
+		if oneOf(v, customerRegions) {
+			region = v
+		}
+	}
+	return segment, region
+}
+
+// CustomerSegment returns the segment and region of a request, once
+// populateBaggage has run
+func CustomerSegment(r *http.Request) (segment, region string) {
+	ctx := r.Context()
+	return baggage.Value(ctx, baggageCustomerSegment).AsString(), baggage.Value(ctx, baggageCustomerRegion).AsString()
+}
+
+func oneOf(v string, values []string) bool {
+	for _, s := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// annotateBaggage stamps the baggage entries on the current segment so traces
+// can be filtered by them
+func annotateBaggage(ctx context.Context) {
+	for _, k := range baggageKeys {
+		if v := baggage.Value(ctx, k).AsString(); v != "" {
+			xray.AddAnnotation(ctx, string(k), v)
+		}
+	}
+}
+
+// injectBaggage forwards the baggage to a downstream service
+func injectBaggage(ctx context.Context, r *http.Request) {
+	propagation.Baggage{}.Inject(ctx, propagation.HeaderCarrier(r.Header))
+}
+
+// baggageAttributes adds the baggage entries to message attributes
+func baggageAttributes(ctx context.Context, attributes map[string]string) map[string]string {
+	for _, k := range baggageKeys {
+		if v := baggage.Value(ctx, k).AsString(); v != "" {
+			attributes[string(k)] = v
+		}
+	}
+	return attributes
+}
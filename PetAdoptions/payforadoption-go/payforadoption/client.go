@@ -0,0 +1,167 @@
+package payforadoption
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// MakeClientEndpoints returns the Endpoints of a remote instance, e.g.
+// http://payforadoption. They implement Service, for code in this module that
+// drives an instance. Every Go service module is named petadoptions, so other
+// modules cannot import this package: they go by /openapi.json instead. When
+// authentication is enabled, pass httptransport.ClientBefore(SignRequest(sess)).
+func MakeClientEndpoints(instance string, options ...httptransport.ClientOption) (Endpoints, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	tgt, err := url.Parse(instance)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	tgt.Path = ""
+
+	return Endpoints{
+		HealthCheckEndpoint: httptransport.NewClient("GET", withPath(tgt, "/health/status"),
+			encodeEmptyClientRequest, decodeEmptyClientResponse, options...).Endpoint(),
+		CompleteAdoptionEndpoint: httptransport.NewClient("POST", withPath(tgt, "/api/home/completeadoption"),
+			encodeCompleteAdoptionClientRequest, decodeClientResponse(func() interface{} { return new(Adoption) }), options...).Endpoint(),
+		CleanupAdoptionsEndpoint: httptransport.NewClient("POST", withPath(tgt, "/api/home/cleanupadoptions"),
+			encodeEmptyClientRequest, decodeClientResponse(func() interface{} { return new(CleanupResult) }), options...).Endpoint(),
+		TriggerSeedingEndpoint: httptransport.NewClient("POST", withPath(tgt, "/api/home/triggerseeding"),
+			encodeJSONClientRequest, decodeEmptyClientResponse, options...).Endpoint(),
+		AdoptionHistoryEndpoint: httptransport.NewClient("GET", withPath(tgt, "/api/adoptions/history"),
+			encodeAdoptionHistoryClientRequest, decodeClientResponse(func() interface{} { return new(adoptionHistoryResponse) }), options...).Endpoint(),
+	}, nil
+}
+
+func (e Endpoints) HealthCheck(ctx context.Context) error {
+	_, err := e.HealthCheckEndpoint(ctx, nil)
+	return err
+}
+
+func (e Endpoints) CompleteAdoption(ctx context.Context, petId, petType, userId string) (Adoption, error) {
+	res, err := e.CompleteAdoptionEndpoint(ctx, completeAdoptionRequest{petId, petType, userId})
+	if err != nil {
+		return Adoption{}, err
+	}
+	return *res.(*Adoption), nil
+}
+
+func (e Endpoints) CleanupAdoptions(ctx context.Context) (CleanupResult, error) {
+	res, err := e.CleanupAdoptionsEndpoint(ctx, nil)
+	if err != nil {
+		return CleanupResult{}, err
+	}
+	return *res.(*CleanupResult), nil
+}
+
+func (e Endpoints) TriggerSeeding(ctx context.Context, opts SeedOptions) error {
+	_, err := e.TriggerSeedingEndpoint(ctx, opts)
+	return err
+}
+
+func (e Endpoints) AdoptionHistory(ctx context.Context, q HistoryQuery) ([]Adoption, error) {
+	res, err := e.AdoptionHistoryEndpoint(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*adoptionHistoryResponse).Transactions, nil
+}
+
+var _ Service = Endpoints{}
+
+func withPath(base *url.URL, path string) *url.URL {
+	u := *base
+	u.Path = path
+	return &u
+}
+
+func encodeEmptyClientRequest(context.Context, *http.Request, interface{}) error {
+	return nil
+}
+
+func encodeJSONClientRequest(_ context.Context, r *http.Request, request interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(request); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func encodeCompleteAdoptionClientRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(completeAdoptionRequest)
+	params := url.Values{}
+	params.Set("petId", req.PetId)
+	params.Set("petType", req.PetType)
+	if req.UserId != "" {
+		params.Set("userId", req.UserId)
+	}
+	r.URL.RawQuery = params.Encode()
+	return nil
+}
+
+func encodeAdoptionHistoryClientRequest(_ context.Context, r *http.Request, request interface{}) error {
+	q := request.(HistoryQuery)
+	params := url.Values{}
+	if !q.From.IsZero() {
+		params.Set("from", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		params.Set("to", q.To.Format("2006-01-02"))
+	}
+	if q.Limit != 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		params.Set("offset", strconv.Itoa(q.Offset))
+	}
+	r.URL.RawQuery = params.Encode()
+	return nil
+}
+
+func decodeEmptyClientResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode >= http.StatusBadRequest {
+		return nil, decodeClientError(r)
+	}
+	return nil, nil
+}
+
+// decodeClientResponse decodes the body into a value made by newResponse, or
+// the error body into an *Error
+func decodeClientResponse(newResponse func() interface{}) httptransport.DecodeResponseFunc {
+	return func(_ context.Context, r *http.Response) (interface{}, error) {
+		if r.StatusCode >= http.StatusBadRequest {
+			return nil, decodeClientError(r)
+		}
+		res := newResponse()
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func decodeClientError(r *http.Response) error {
+	var res errorResponse
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil || res.Message == "" {
+		res.Message = http.StatusText(r.StatusCode)
+	}
+	return &Error{
+		Code:      res.Code,
+		Status:    r.StatusCode,
+		Retryable: res.Retryable,
+		Err:       errors.New(res.Message),
+		Details:   res.Details,
+	}
+}
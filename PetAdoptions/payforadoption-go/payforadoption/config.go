@@ -0,0 +1,47 @@
+package payforadoption
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConfigStore holds the current Config. Readers always get a consistent
+// snapshot while a watcher swaps in updated parameters.
+type ConfigStore struct {
+	v atomic.Value
+
+	mtx  sync.Mutex
+	subs []func(prev, next Config)
+}
+
+func NewConfigStore(cfg Config) *ConfigStore {
+	s := &ConfigStore{}
+	s.v.Store(cfg)
+	return s
+}
+
+func (s *ConfigStore) Load() Config {
+	return s.v.Load().(Config)
+}
+
+// Store swaps the config and notifies the subscribers
+func (s *ConfigStore) Store(cfg Config) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	prev := s.Load()
+	s.v.Store(cfg)
+
+	for _, fn := range s.subs {
+		fn(prev, cfg)
+	}
+}
+
+// Subscribe registers fn to be called after every config change, for
+// dependents that need to rebuild clients
+func (s *ConfigStore) Subscribe(fn func(prev, next Config)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.subs = append(s.subs, fn)
+}
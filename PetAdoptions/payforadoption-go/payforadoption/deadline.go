@@ -0,0 +1,117 @@
+package payforadoption
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// timeoutHeader carries the remaining budget of the caller in milliseconds,
+// for HTTP callers that cannot send grpc-timeout
+const timeoutHeader = "X-Amzn-Timeout-Ms"
+
+type timeoutHintKey struct{}
+
+// populateTimeoutHint keeps the budget announced by the caller, so work the
+// caller has already given up on is not carried on
+func populateTimeoutHint(ctx context.Context, r *http.Request) context.Context {
+	if d, ok := parseGRPCTimeout(r.Header.Get("grpc-timeout")); ok {
+		return context.WithValue(ctx, timeoutHintKey{}, d)
+	}
+	if ms, err := strconv.ParseInt(r.Header.Get(timeoutHeader), 10, 64); err == nil && ms > 0 {
+		return context.WithValue(ctx, timeoutHintKey{}, time.Duration(ms)*time.Millisecond)
+	}
+	return ctx
+}
+
+// parseGRPCTimeout parses the grpc-timeout format, up to 8 digits followed by
+// one of the units H, M, S, m, u or n
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+func newDeadlineExceededCounter() metrics.Counter {
+	return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "payforadoption",
+		Name:      "deadline_exceeded_total",
+		Help:      "Number of requests that ran out of their time budget",
+	}, []string{"endpoint"})
+}
+
+// withDeadline bounds the endpoint to budget, or to the caller's budget when
+// shorter, so slow SQL and HTTP calls downstream are cancelled instead of
+// holding the request. A zero budget only applies the caller's budget.
+func withDeadline(budget time.Duration, name string, exceeded metrics.Counter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			d := budget
+			if hint, ok := ctx.Value(timeoutHintKey{}).(time.Duration); ok && (d <= 0 || hint < d) {
+				d = hint
+			}
+			if d <= 0 {
+				return next(ctx, request)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			res, err := next(ctx, request)
+			if ctx.Err() != context.DeadlineExceeded {
+				return res, err
+			}
+
+			exceeded.With("endpoint", name).Add(1)
+			xray.AddAnnotation(ctx, "DeadlineExceeded", true)
+
+			// drivers report the cancellation in their own words, the deadline
+			// is what the caller needs to know about
+			if err != nil {
+				err = &Error{Code: CodeTimeout, Status: http.StatusGatewayTimeout, Retryable: true, Err: err}
+			}
+			return res, err
+		}
+	}
+}
+
+// detachedContext keeps the values of its parent, the trace segment and the
+// baggage, but neither its deadline nor its cancellation
+type detachedContext struct{ parent context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// detach returns a context with its own timeout for the work that must finish
+// even when the caller gave up, such as undoing a half done adoption
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(detachedContext{ctx}, timeout)
+}
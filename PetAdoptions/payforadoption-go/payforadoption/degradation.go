@@ -0,0 +1,253 @@
+package payforadoption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"petadoptions/flags"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Degradation scenarios that can be forced on CompleteAdoption
+const (
+	ScenarioCircuitBreaker = "circuit_breaker"
+	ScenarioSystemStress   = "system_stress"
+	ScenarioDBExhaustion   = "db_exhaustion"
+	ScenarioSlow           = "slow"
+	ScenarioDiskPressure   = "disk_pressure"
+	ScenarioSlowQuery      = "slow_query"
+	ScenarioLockContention = "lock_contention"
+)
+
+// randomScenarios are safe to pick when no scenario is named
+var randomScenarios = []string{
+	ScenarioCircuitBreaker,
+	ScenarioSystemStress,
+	ScenarioDBExhaustion,
+	ScenarioSlow,
+}
+
+var degradationScenarios = append(randomScenarios,
+	ScenarioDiskPressure,
+	ScenarioSlowQuery,
+	ScenarioLockContention,
+)
+
+// scenarioHeader lets a single request force a scenario, which keeps workshop
+// walkthroughs reproducible without touching the shared configuration. It is
+// only honoured when ALLOW_SCENARIO_HEADER is set and the request is SigV4
+// authenticated, anyone else could degrade the service at will.
+const scenarioHeader = "X-Degradation-Scenario"
+
+type scenarioKey struct{}
+
+// populateScenario is a ServerBefore func storing the header override in the context
+func populateScenario(ctx context.Context, r *http.Request) context.Context {
+	if v := r.Header.Get(scenarioHeader); v != "" {
+		return context.WithValue(ctx, scenarioKey{}, v)
+	}
+	return ctx
+}
+
+// degradationScenario resolves the active scenario: the request header wins
+// when allowHeader is set and the caller authenticated, then the scenario
+// attribute of the chaosScenario flag. An enabled flag without a scenario falls
+// back to a random pick. Unknown names are ignored.
+func degradationScenario(ctx context.Context, f flags.Flags, allowHeader bool) string {
+	if v, ok := ctx.Value(scenarioKey{}).(string); ok && allowHeader && callerRole(ctx) != "" && isScenario(v) {
+		return v
+	}
+
+	chaos := f.Get(flags.ChaosScenario)
+	if !chaos.Enabled() {
+		return ""
+	}
+
+	if v := chaos.String("scenario"); v != "" {
+		if isScenario(v) {
+			return v
+		}
+		return ""
+	}
+
+	return randomScenarios[rand.Intn(len(randomScenarios))]
+}
+
+func isScenario(name string) bool {
+	for _, s := range degradationScenarios {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// degrade runs the scenario and returns the error the adoption should fail with, if any.
+// Scenario settings are read from the chaosScenario flag attributes.
+func (s service) degrade(ctx context.Context, scenario string, chaos flags.Flag) error {
+	ctx, seg := xray.BeginSubsegment(ctx, "degradation")
+	seg.AddAnnotation("degradationScenario", scenario)
+
+	var err error
+	switch scenario {
+	case ScenarioCircuitBreaker:
+		err = dependencyError(errors.New("circuit breaker open for the pet status updater"))
+	case ScenarioSystemStress:
+		burnCPU(2 * time.Second)
+	case ScenarioDBExhaustion:
+		time.Sleep(2 * time.Second)
+		err = databaseError(errors.New("remaining connection slots are reserved"))
+	case ScenarioSlow:
+		time.Sleep(3 * time.Second)
+	case ScenarioDiskPressure:
+		if !s.config.Load().AllowDiskPressure {
+			seg.AddAnnotation("skipped", "ALLOW_DISK_PRESSURE not set")
+			break
+		}
+		ttl := time.Duration(chaos.Int("ttlSeconds", 300)) * time.Second
+		fillDisk(int64(chaos.Int("diskBytes", 512<<20)), ttl, s.logger)
+	case ScenarioSlowQuery:
+		err = s.repository.SlowQuery(ctx, float64(chaos.Int("sleepSeconds", 5)))
+	case ScenarioLockContention:
+		s.holdLocks(chaos.Int("lockers", 3), time.Duration(chaos.Int("holdSeconds", 5))*time.Second)
+		// wait in line behind the holders like any other writer would
+		err = s.repository.LockTransactions(ctx, 0)
+	}
+
+	seg.Close(err)
+	return err
+}
+
+// burnCPU keeps every core busy for d
+func burnCPU(d time.Duration) {
+	deadline := time.Now().Add(d)
+	done := make(chan struct{})
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for time.Now().Before(deadline) {
+			}
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+}
+
+func newDegradationCounter() metrics.Counter {
+	return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "payforadoption",
+		Name:      "degradation_scenarios_total",
+		Help:      "Number of adoptions that ran a degradation scenario",
+	}, []string{"scenario"})
+}
+
+// diskPressure tracks the files written by the disk_pressure scenario, a
+// single run is active at a time so the budget is never exceeded
+var diskPressure struct {
+	sync.Mutex
+	dir string
+}
+
+// fillDisk starts writing temp files until budget bytes are used, and removes
+// them after ttl
+func fillDisk(budget int64, ttl time.Duration, logger log.Logger) {
+	diskPressure.Lock()
+	defer diskPressure.Unlock()
+
+	if diskPressure.dir != "" {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "payforadoption-diskpressure")
+	if err != nil {
+		level.Error(logger).Log("scenario", ScenarioDiskPressure, "err", err)
+		return
+	}
+	diskPressure.dir = dir
+
+	time.AfterFunc(ttl, func() {
+		diskPressure.Lock()
+		defer diskPressure.Unlock()
+
+		if err := os.RemoveAll(dir); err != nil {
+			level.Error(logger).Log("scenario", ScenarioDiskPressure, "err", err)
+		}
+		diskPressure.dir = ""
+	})
+
+	// up to the budget is written in the background, the request and the
+	// other scenarios do not wait for it
+	go writeFill(dir, budget, ttl, logger)
+}
+
+// writeFill writes budget bytes to dir in 16MB files. It stops at the first
+// failure, which includes dir being removed once the ttl is over.
+func writeFill(dir string, budget int64, ttl time.Duration, logger log.Logger) {
+	chunk := make([]byte, 16<<20)
+	for written, i := int64(0), 0; written < budget; i++ {
+		n := int64(len(chunk))
+		if budget-written < n {
+			n = budget - written
+		}
+
+		name := filepath.Join(dir, fmt.Sprintf("fill-%04d", i))
+		if err := ioutil.WriteFile(name, chunk[:n], 0600); err != nil {
+			level.Error(logger).Log("scenario", ScenarioDiskPressure, "err", err)
+			return
+		}
+		written += n
+	}
+
+	level.Info(logger).Log("scenario", ScenarioDiskPressure, "dir", dir, "bytes", budget, "ttl", ttl)
+}
+
+// lockHolders is set while a batch of lock holders is running
+var lockHolders int32
+
+// holdLocks starts lockers goroutines that each keep the transactions row locks
+// for hold, they serialize on the same rows so the contention lasts lockers*hold
+func (s service) holdLocks(lockers int, hold time.Duration) {
+	if !atomic.CompareAndSwapInt32(&lockHolders, 0, 1) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < lockers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// holders outlive the request, they are traced on their own
+			ctx, seg := xray.BeginSegment(context.Background(), "payforadoption-lockholder")
+			err := s.repository.LockTransactions(ctx, hold)
+			seg.Close(err)
+			if err != nil {
+				level.Error(s.logger).Log("scenario", ScenarioLockContention, "err", err)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		atomic.StoreInt32(&lockHolders, 0)
+	}()
+}
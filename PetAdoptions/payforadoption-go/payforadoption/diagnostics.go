@@ -0,0 +1,91 @@
+package payforadoption
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// snapshotDir keeps the profiles written by the snapshot endpoint, they can be
+// copied out of the task with ECS Exec and opened with go tool pprof
+var snapshotDir = filepath.Join(os.TempDir(), "payforadoption-snapshots")
+
+type snapshotResponse struct {
+	Goroutines  int               `json:"goroutines"`
+	HeapAlloc   uint64            `json:"heapAlloc"`
+	HeapObjects uint64            `json:"heapObjects"`
+	NumGC       uint32            `json:"numGC"`
+	Files       map[string]string `json:"files"`
+}
+
+// snapshotHandler writes a goroutine dump and a heap profile on POST, so the
+// state of a degraded task can be kept before it gets replaced
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ts := time.Now().UTC().Format("20060102T150405Z")
+	res := snapshotResponse{Files: map[string]string{}}
+
+	for _, name := range []string{"goroutine", "heap"} {
+		path := filepath.Join(snapshotDir, fmt.Sprintf("%s-%s.pprof", name, ts))
+		if err := writeProfile(name, path); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Files[name] = path
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	res.Goroutines = runtime.NumGoroutine()
+	res.HeapAlloc = m.HeapAlloc
+	res.HeapObjects = m.HeapObjects
+	res.NumGC = m.NumGC
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
+func writeProfile(name, path string) error {
+	f, err := ioutil.TempFile(snapshotDir, name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// heap profiles reflect the last GC, run one so the leak shows up
+	if name == "heap" {
+		runtime.GC()
+	}
+
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,79 @@
+package payforadoption
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// MetricsSinkEMF selects the CloudWatch Embedded Metric Format sink
+const MetricsSinkEMF = "emf"
+
+const emfNamespace = "PetAdoptions"
+
+// emfWriter writes one EMF document per request, CloudWatch Logs extracts the
+// metrics so no Prometheus scrape path is needed
+type emfWriter struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	service string
+}
+
+func newEMFWriter(w io.Writer, service string) *emfWriter {
+	return &emfWriter{enc: json.NewEncoder(w), service: service}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+var emfDirectives = []emfDirective{{
+	Namespace:  emfNamespace,
+	Dimensions: [][]string{{"service", "endpoint", "petType"}},
+	Metrics: []emfMetric{
+		{Name: "Requests", Unit: "Count"},
+		{Name: "Errors", Unit: "Count"},
+		{Name: "Latency", Unit: "Milliseconds"},
+	},
+}}
+
+// emit records a request, traceID is kept as a property to link to X-Ray
+func (e *emfWriter) emit(endpoint, petType string, failed bool, took time.Duration, traceID string) {
+	errorCount := 0
+	if failed {
+		errorCount = 1
+	}
+
+	doc := map[string]interface{}{
+		"_aws": emfMetadata{
+			Timestamp:         time.Now().UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: emfDirectives,
+		},
+		"service":  e.service,
+		"endpoint": endpoint,
+		"petType":  petType,
+		"Requests": 1,
+		"Errors":   errorCount,
+		"Latency":  float64(took) / float64(time.Millisecond),
+	}
+	if traceID != "" {
+		doc["traceId"] = traceID
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(doc)
+}
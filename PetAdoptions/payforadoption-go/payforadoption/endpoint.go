@@ -11,14 +11,16 @@ type Endpoints struct {
 	CompleteAdoptionEndpoint endpoint.Endpoint
 	CleanupAdoptionsEndpoint endpoint.Endpoint
 	TriggerSeedingEndpoint   endpoint.Endpoint
+	AdoptionHistoryEndpoint  endpoint.Endpoint
 }
 
 func MakeEndpoints(s Service) Endpoints {
 	return Endpoints{
 		HealthCheckEndpoint:      makeHealthCheckEndpoint(s),
-		CompleteAdoptionEndpoint: makeCompleteAdoptionEndpoint(s),
+		CompleteAdoptionEndpoint: validateCompleteAdoption(newValidationFailuresCounter())(makeCompleteAdoptionEndpoint(s)),
 		CleanupAdoptionsEndpoint: makeCleanupAdoptionsEndpoint(s),
 		TriggerSeedingEndpoint:   makeTriggerSeedingEndpoint(s),
+		AdoptionHistoryEndpoint:  makeAdoptionHistoryEndpoint(s),
 	}
 }
 
@@ -31,18 +33,33 @@ func makeHealthCheckEndpoint(s Service) endpoint.Endpoint {
 func makeCompleteAdoptionEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(completeAdoptionRequest)
-		return s.CompleteAdoption(ctx, req.PetId, req.PetType)
+		return s.CompleteAdoption(ctx, req.PetId, req.PetType, req.UserId)
 	}
 }
 
 func makeCleanupAdoptionsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, _ interface{}) (interface{}, error) {
-		return nil, s.CleanupAdoptions(ctx)
+		return s.CleanupAdoptions(ctx)
 	}
 }
 
 func makeTriggerSeedingEndpoint(s Service) endpoint.Endpoint {
-	return func(ctx context.Context, _ interface{}) (interface{}, error) {
-		return nil, s.TriggerSeeding(ctx)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return nil, s.TriggerSeeding(ctx, request.(SeedOptions))
+	}
+}
+
+func makeAdoptionHistoryEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		q := request.(HistoryQuery)
+		res, err := s.AdoptionHistory(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return adoptionHistoryResponse{
+			Transactions: res,
+			Limit:        q.Limit,
+			Offset:       q.Offset,
+		}, nil
 	}
 }
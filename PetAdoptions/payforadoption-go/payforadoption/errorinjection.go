@@ -0,0 +1,52 @@
+package payforadoption
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"petadoptions/flags"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var errInjected = errors.New("injected failure")
+
+// injectErrors fails a share of the requests before they reach the service,
+// according to the errorInjection flag, e.g. {"enabled": true, "percent": 10, "status": 503}
+func injectErrors(f *flags.Client, injected metrics.Counter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			e := f.Flags().Get(flags.ErrorInjection)
+			if !e.Enabled() || rand.Intn(100) >= e.Int("percent", 0) {
+				return next(ctx, request)
+			}
+
+			status := http.StatusInternalServerError
+			if e.Int("status", status) == http.StatusServiceUnavailable {
+				status = http.StatusServiceUnavailable
+			}
+
+			injected.With("status", strconv.Itoa(status)).Add(1)
+			return nil, &Error{
+				Code:      CodeInjectedFailure,
+				Status:    status,
+				Retryable: status == http.StatusServiceUnavailable,
+				Err:       errInjected,
+			}
+		}
+	}
+}
+
+func newInjectedErrorsCounter() metrics.Counter {
+	return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "payforadoption",
+		Name:      "injected_errors_total",
+		Help:      "Number of responses failed by the error injection scenario",
+	}, []string{"status"})
+}
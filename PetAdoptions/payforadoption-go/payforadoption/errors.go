@@ -0,0 +1,87 @@
+package payforadoption
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"petadoptions/chaos"
+)
+
+// Error codes returned to API consumers
+const (
+	CodeBadRequest        = "BAD_REQUEST"
+	CodeValidationFailed  = "VALIDATION_FAILED"
+	CodeNotFound          = "NOT_FOUND"
+	CodeUnauthorized      = "UNAUTHORIZED"
+	CodeForbidden         = "FORBIDDEN"
+	CodeNotSupported      = "NOT_SUPPORTED"
+	CodeTimeout           = "TIMEOUT"
+	CodeDatabaseError     = "DATABASE_ERROR"
+	CodeDependencyFailure = "DEPENDENCY_FAILURE"
+	CodeInternal          = "INTERNAL_ERROR"
+	CodeInjectedFailure   = "INJECTED_FAILURE"
+)
+
+// Error attaches an API error code to an underlying error so consumers can
+// tell validation failures from dependency failures
+type Error struct {
+	Code      string
+	Status    int
+	Retryable bool
+	Err       error
+	Details   map[string]string
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// errorResponse is the body written for every failed request
+type errorResponse struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Retryable bool              `json:"retryable"`
+	TraceID   string            `json:"traceId,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+}
+
+func databaseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: CodeDatabaseError, Status: http.StatusServiceUnavailable, Retryable: true, Err: err}
+}
+
+func dependencyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: CodeDependencyFailure, Status: http.StatusBadGateway, Retryable: true, Err: err}
+}
+
+// errorFrom classifies any error into the API error model
+func errorFrom(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	switch {
+	case errors.Is(err, ErrBadRequest), errors.Is(err, chaos.ErrBadRequest), errors.Is(err, chaos.ErrUnknownScenario):
+		return &Error{Code: CodeBadRequest, Status: http.StatusBadRequest, Err: err}
+	case errors.Is(err, ErrUnauthorized):
+		return &Error{Code: CodeUnauthorized, Status: http.StatusUnauthorized, Err: err}
+	case errors.Is(err, ErrForbidden):
+		return &Error{Code: CodeForbidden, Status: http.StatusForbidden, Err: err}
+	case errors.Is(err, ErrNotFound), errors.Is(err, chaos.ErrNotRunning):
+		return &Error{Code: CodeNotFound, Status: http.StatusNotFound, Err: err}
+	case errors.Is(err, ErrNotSupported):
+		return &Error{Code: CodeNotSupported, Status: http.StatusNotImplemented, Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: CodeTimeout, Status: http.StatusGatewayTimeout, Retryable: true, Err: err}
+	default:
+		return &Error{Code: CodeInternal, Status: http.StatusInternalServerError, Err: err}
+	}
+}
@@ -0,0 +1,36 @@
+package payforadoption
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"petadoptions/flags"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// injectLatency delays a share of the requests according to the latencyInjection
+// flag, e.g. {"enabled": true, "baseMs": 2500, "jitterMs": 500, "percent": 5}
+// turns the p99 from ~200ms to ~3s
+func injectLatency(f *flags.Client, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := f.Flags().Get(flags.LatencyInjection)
+		if !l.Enabled() || rand.Intn(100) >= l.Int("percent", 100) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay := time.Duration(l.Int("baseMs", 0)) * time.Millisecond
+		if jitter := l.Int("jitterMs", 0); jitter > 0 {
+			delay += time.Duration(rand.Intn(jitter)) * time.Millisecond
+		}
+
+		_, seg := xray.BeginSubsegment(r.Context(), "latencyInjection")
+		seg.AddAnnotation("delayMs", int(delay/time.Millisecond))
+		time.Sleep(delay)
+		seg.Close(nil)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,41 +3,80 @@ package payforadoption
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
+	"petadoptions/logging"
+
 	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/metrics"
-	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
-	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type middleware struct {
-	logger         log.Logger
-	requestCount   metrics.Counter
-	requestLatency metrics.Histogram
+	logger             log.Logger
+	otelRequestCount   metric.Int64Counter
+	otelRequestLatency metric.Float64ValueRecorder
+	emf                *emfWriter
 	Service
 }
 
-func NewInstrumenting(logger log.Logger, s Service) Service {
-	labels := []string{"endpoint", "error", "pettype"}
-	return &middleware{
+// NewInstrumenting records the request metrics by endpoint and pet type on the
+// OTel instruments, with sink set to MetricsSinkEMF they are also written to
+// stdout in the Embedded Metric Format. The Prometheus request metrics are
+// recorded by the HTTP handler, see httpmetrics.
+func NewInstrumenting(logger log.Logger, s Service, sink string) Service {
+	meter := metric.Must(otel.Meter("payforadoption"))
+	mw := &middleware{
 		logger:  logger,
 		Service: s,
-		requestCount: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
-			Namespace: "payforadoption",
-			Name:      "requests_total",
-			Help:      "Number of requests received",
-		}, labels),
-		requestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
-			Namespace: "payforadoption",
-			Name:      "requests_latency_seconds",
-			Help:      "Request durations in seconds",
-		}, labels),
+		otelRequestCount: meter.NewInt64Counter(
+			"payforadoption.requests_total",
+			metric.WithDescription("Number of requests received"),
+		),
+		otelRequestLatency: meter.NewFloat64ValueRecorder(
+			"payforadoption.requests_latency_seconds",
+			metric.WithDescription("Request durations in seconds"),
+		),
+	}
+	if sink == MetricsSinkEMF {
+		mw.emf = newEMFWriter(os.Stdout, "payforadoption")
+	}
+	return mw
+}
+
+// observe records the request on the OTel instruments, labelled with the
+// customer segment of the baggage, and the EMF sink when enabled
+func (mw *middleware) observe(ctx context.Context, labelValues []string, begin time.Time) {
+	took := time.Since(begin).Seconds()
+
+	values := map[string]string{}
+	labels := make([]attribute.KeyValue, 0, len(labelValues)/2+2)
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		values[labelValues[i]] = labelValues[i+1]
+		labels = append(labels, attribute.String(labelValues[i], labelValues[i+1]))
+	}
+	labels = append(labels,
+		attribute.String("customer_segment", baggage.Value(ctx, baggageCustomerSegment).AsString()),
+		attribute.String("customer_region", baggage.Value(ctx, baggageCustomerRegion).AsString()),
+	)
+
+	mw.otelRequestCount.Add(ctx, 1, labels...)
+	mw.otelRequestLatency.Record(ctx, took, labels...)
+
+	if mw.emf != nil {
+		var traceID string
+		if segment := xray.GetSegment(ctx); segment != nil {
+			traceID = segment.DownstreamHeader().TraceID
+		}
+		mw.emf.emit(values["endpoint"], values["pettype"], values["error"] == "true", time.Since(begin), traceID)
 	}
 }
 
-func (mw *middleware) CompleteAdoption(ctx context.Context, petId, petType string) (a Adoption, err error) {
+func (mw *middleware) CompleteAdoption(ctx context.Context, petId, petType, userId string) (a Adoption, err error) {
 	defer func(begin time.Time) {
 
 		labelValues := []string{
@@ -45,28 +84,25 @@ func (mw *middleware) CompleteAdoption(ctx context.Context, petId, petType strin
 			"error", fmt.Sprint(err != nil),
 			"pettype", petType,
 		}
-		mw.requestCount.With(labelValues...).Add(1)
-		mw.requestLatency.With(labelValues...).Observe(time.Since(begin).Seconds())
-
-		segment := xray.GetSegment(ctx)
+		mw.observe(ctx, labelValues, begin)
 
 		xray.AddAnnotation(ctx, "PetId", petId)
 		xray.AddAnnotation(ctx, "PetType", petType)
 		xray.AddMetadata(ctx, "timeTakenSeconds", time.Since(begin).Seconds())
 
-		mw.logger.Log(
+		logging.WithTrace(ctx, mw.logger).Log(
 			"method", "In CompleteAdoption",
-			"traceId", segment.TraceID,
 			"PetId", petId,
 			"PetType", petType,
+			"UserId", userId,
 			"took", time.Since(begin),
 			"err", err)
 	}(time.Now())
 
-	return mw.Service.CompleteAdoption(ctx, petId, petType)
+	return mw.Service.CompleteAdoption(ctx, petId, petType, userId)
 }
 
-func (mw *middleware) CleanupAdoptions(ctx context.Context) (err error) {
+func (mw *middleware) CleanupAdoptions(ctx context.Context) (res CleanupResult, err error) {
 	defer func(begin time.Time) {
 
 		labelValues := []string{
@@ -74,15 +110,14 @@ func (mw *middleware) CleanupAdoptions(ctx context.Context) (err error) {
 			"error", fmt.Sprint(err != nil),
 			"pettype", "",
 		}
-		mw.requestCount.With(labelValues...).Add(1)
-		mw.requestLatency.With(labelValues...).Observe(time.Since(begin).Seconds())
+		mw.observe(ctx, labelValues, begin)
 
-		segment := xray.GetSegment(ctx)
 		xray.AddMetadata(ctx, "timeTakenSeconds", time.Since(begin).Seconds())
 
-		mw.logger.Log(
+		logging.WithTrace(ctx, mw.logger).Log(
 			"method", "In CleanupAdoptions",
-			"traceId", segment.TraceID,
+			"archived", res.Archived,
+			"deleted", res.Deleted,
 			"took", time.Since(begin),
 			"err", err)
 	}(time.Now())
@@ -90,6 +125,28 @@ func (mw *middleware) CleanupAdoptions(ctx context.Context) (err error) {
 	return mw.Service.CleanupAdoptions(ctx)
 }
 
+func (mw *middleware) AdoptionHistory(ctx context.Context, q HistoryQuery) (ax []Adoption, err error) {
+	defer func(begin time.Time) {
+
+		labelValues := []string{
+			"endpoint", "adoption_history",
+			"error", fmt.Sprint(err != nil),
+			"pettype", "",
+		}
+		mw.observe(ctx, labelValues, begin)
+
+		xray.AddMetadata(ctx, "timeTakenSeconds", time.Since(begin).Seconds())
+
+		logging.WithTrace(ctx, mw.logger).Log(
+			"method", "In AdoptionHistory",
+			"resultCount", len(ax),
+			"took", time.Since(begin),
+			"err", err)
+	}(time.Now())
+
+	return mw.Service.AdoptionHistory(ctx, q)
+}
+
 func (mw *middleware) HealthCheck(ctx context.Context) (err error) {
 	defer func(begin time.Time) {
 		labelValues := []string{
@@ -97,8 +154,7 @@ func (mw *middleware) HealthCheck(ctx context.Context) (err error) {
 			"error", fmt.Sprint(err != nil),
 			"pettype", "",
 		}
-		mw.requestCount.With(labelValues...).Add(1)
-		mw.requestLatency.With(labelValues...).Observe(time.Since(begin).Seconds())
+		mw.observe(ctx, labelValues, begin)
 	}(time.Now())
 	return mw.Service.HealthCheck(ctx)
 }
@@ -0,0 +1,265 @@
+package payforadoption
+
+import "net/http"
+
+// openAPISpec documents the public API, keep it in sync with MakeHTTPHandler
+// and chaos.RegisterRoutes
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "payforadoption",
+    "description": "Completes pet adoptions and serves the adoption history",
+    "version": "1.0.0"
+  },
+  "security": [{"sts": []}],
+  "paths": {
+    "/health/status": {
+      "get": {
+        "operationId": "healthCheck",
+        "security": [],
+        "responses": {
+          "200": {"description": "The service is up"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/home/completeadoption": {
+      "post": {
+        "operationId": "completeAdoption",
+        "parameters": [
+          {"name": "petId", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "petType", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "userId", "in": "query", "schema": {"type": "string"}},
+          {"name": "X-Session-Id", "in": "header", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The completed adoption",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Adoption"}}}
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/home/cleanupadoptions": {
+      "post": {
+        "operationId": "cleanupAdoptions",
+        "responses": {
+          "200": {
+            "description": "The archived and deleted adoptions",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CleanupResult"}}}
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/home/triggerseeding": {
+      "post": {
+        "operationId": "triggerSeeding",
+        "requestBody": {
+          "description": "Seeds the default catalog when empty",
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SeedOptions"}}}
+        },
+        "responses": {
+          "200": {"description": "The catalog was seeded"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/adoptions/history": {
+      "get": {
+        "operationId": "adoptionHistory",
+        "parameters": [
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date"}},
+          {"name": "to", "in": "query", "schema": {"type": "string", "format": "date"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 25}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}}
+        ],
+        "responses": {
+          "200": {
+            "description": "A page of past adoptions",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdoptionHistory"}}}
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/chaos/scenarios": {
+      "get": {
+        "operationId": "listChaosScenarios",
+        "responses": {
+          "200": {
+            "description": "The scenarios that can be started",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/ChaosScenario"}}
+              }
+            }
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/chaos/status": {
+      "get": {
+        "operationId": "chaosStatus",
+        "responses": {
+          "200": {"$ref": "#/components/responses/ChaosRuns"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/chaos/start": {
+      "post": {
+        "operationId": "startChaos",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["scenario"],
+                "properties": {
+                  "scenario": {"type": "string"},
+                  "params": {"type": "object", "additionalProperties": true},
+                  "duration": {"type": "string", "example": "5m"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The started run",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ChaosRun"}}}
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/chaos/stop": {
+      "post": {
+        "operationId": "stopChaos",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"type": "object", "properties": {"scenario": {"type": "string"}}}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/ChaosRuns"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "sts": {
+        "type": "apiKey",
+        "in": "header",
+        "name": "Authorization",
+        "description": "STS followed by the base64url encoded, presigned sts:GetCallerIdentity URL. The URL signs the X-Petstore-Request header, the method, path and hex SHA-256 of the body of the request separated by spaces. Only enforced when allowed roles are configured."
+      }
+    },
+    "schemas": {
+      "Adoption": {
+        "type": "object",
+        "properties": {
+          "transactionid": {"type": "string"},
+          "petid": {"type": "string"},
+          "pettype": {"type": "string"},
+          "userid": {"type": "string"},
+          "receiptkey": {"type": "string"},
+          "AdoptionDate": {"type": "string", "format": "date-time"}
+        }
+      },
+      "AdoptionHistory": {
+        "type": "object",
+        "properties": {
+          "transactions": {"type": "array", "items": {"$ref": "#/components/schemas/Adoption"}},
+          "limit": {"type": "integer"},
+          "offset": {"type": "integer"}
+        }
+      },
+      "CleanupResult": {
+        "type": "object",
+        "properties": {
+          "archived": {"type": "integer", "format": "int64"},
+          "deleted": {"type": "integer", "format": "int64"},
+          "location": {"type": "string"}
+        }
+      },
+      "SeedOptions": {
+        "type": "object",
+        "properties": {
+          "count": {"type": "integer", "minimum": 0},
+          "petTypes": {"type": "array", "items": {"type": "string"}},
+          "seed": {"type": "integer", "format": "int64"}
+        }
+      },
+      "ChaosScenario": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "description": {"type": "string"}
+        }
+      },
+      "ChaosRun": {
+        "type": "object",
+        "properties": {
+          "scenario": {"type": "string"},
+          "params": {"type": "object", "additionalProperties": true},
+          "startedAt": {"type": "string", "format": "date-time"},
+          "expiresAt": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message", "retryable"],
+        "properties": {
+          "code": {
+            "type": "string",
+            "enum": [
+              "BAD_REQUEST", "VALIDATION_FAILED", "NOT_FOUND", "UNAUTHORIZED", "FORBIDDEN", "NOT_SUPPORTED",
+              "TIMEOUT", "DATABASE_ERROR", "DEPENDENCY_FAILURE", "INTERNAL_ERROR", "INJECTED_FAILURE"
+            ]
+          },
+          "message": {"type": "string"},
+          "retryable": {"type": "boolean"},
+          "traceId": {"type": "string"},
+          "requestId": {"type": "string", "description": "X-Request-Id of the request, set on every response"},
+          "details": {"type": "object", "additionalProperties": {"type": "string"}}
+        }
+      }
+    },
+    "responses": {
+      "ChaosRuns": {
+        "description": "The running scenarios",
+        "content": {
+          "application/json": {
+            "schema": {"type": "array", "items": {"$ref": "#/components/schemas/ChaosRun"}}
+          }
+        }
+      },
+      "Error": {
+        "description": "The request failed",
+        "headers": {
+          "X-Request-Id": {
+            "description": "Id of the request, the one sent by the caller when valid",
+            "schema": {"type": "string"}
+          }
+        },
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  }
+}
+`
+
+func openAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(openAPISpec))
+}
@@ -0,0 +1,156 @@
+package payforadoption
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// Named queries, the name is the subsegment name of every execution so each
+// query can be found in the traces regardless of its parameters
+const (
+	queryInsertTransaction      = "InsertTransaction"
+	queryDeleteTransaction      = "DeleteTransaction"
+	queryDeleteTransactions     = "DeleteTransactions"
+	queryArchiveToHistory       = "ArchiveToHistory"
+	querySelectForArchive       = "SelectTransactionsForArchive"
+	querySelectHistory          = "SelectTransactionHistory"
+	querySlowQuery              = "SlowQuery"
+	queryLockOldestTransactions = "LockOldestTransactions"
+	queryInsertAuditRecord      = "InsertAuditRecord"
+)
+
+var queries = map[string]string{
+	queryInsertTransaction: `
+		INSERT INTO transactions (pet_id, transaction_id, adoption_date, user_id)
+		VALUES ($1, $2, $3, $4)
+	`,
+	queryDeleteTransaction:  `DELETE FROM transactions WHERE transaction_id = $1`,
+	queryDeleteTransactions: `DELETE FROM transactions`,
+	queryArchiveToHistory: `
+		WITH cleaned AS (
+			DELETE FROM transactions
+			RETURNING pet_id, adoption_date, transaction_id, user_id
+		)
+		INSERT INTO transactions_history (pet_id, adoption_date, transaction_id, user_id, cleaned_at)
+		SELECT pet_id, adoption_date, transaction_id, user_id, now() FROM cleaned
+	`,
+	querySelectForArchive: `SELECT pet_id, transaction_id, adoption_date FROM transactions FOR UPDATE`,
+	querySelectHistory: `
+		SELECT pet_id, transaction_id, adoption_date
+		FROM transactions_history
+		WHERE ($1::date IS NULL OR adoption_date >= $1::date)
+		AND ($2::date IS NULL OR adoption_date <= $2::date)
+		ORDER BY adoption_date DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`,
+	querySlowQuery: `
+		SELECT pg_sleep($1), count(*) FROM transactions
+	`,
+	queryLockOldestTransactions: `
+		SELECT transaction_id FROM transactions
+		ORDER BY adoption_date LIMIT 10 FOR UPDATE
+	`,
+	queryInsertAuditRecord: `
+		INSERT INTO audit_log (trace_id, operation, actor, user_agent, details, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`,
+}
+
+// statements prepares the named queries on first use and keeps them for the
+// life of the pool, database/sql prepares them again on new connections
+type statements struct {
+	db       *sql.DB
+	mu       sync.Mutex
+	prepared map[string]*sql.Stmt
+}
+
+func newStatements(db *sql.DB) *statements {
+	return &statements{db: db, prepared: map[string]*sql.Stmt{}}
+}
+
+func (s *statements) get(ctx context.Context, name string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stmt, ok := s.prepared[name]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := s.db.PrepareContext(ctx, queries[name])
+	if err != nil {
+		return nil, err
+	}
+	s.prepared[name] = stmt
+	return stmt, nil
+}
+
+// stmt returns the prepared statement for name, bound to tx when not nil.
+// exec and query fall back to the unit of work transaction from ctx.
+// Behind RDS Proxy nothing is prepared since prepared statements pin the
+// proxy connection, the query text is sent with each execution instead.
+func (r *repo) stmt(ctx context.Context, tx *sql.Tx, name string) (*sql.Stmt, error) {
+	if r.cfg().UsesRDSProxy() {
+		return nil, nil
+	}
+
+	stmt, err := r.stmts.get(ctx, name)
+	if err != nil || tx == nil {
+		return stmt, err
+	}
+	return tx.StmtContext(ctx, stmt), nil
+}
+
+// exec runs the named query in its own subsegment, within tx when not nil
+func (r *repo) exec(ctx context.Context, tx *sql.Tx, name string, args ...interface{}) (sql.Result, error) {
+	if tx == nil {
+		tx = txFrom(ctx)
+	}
+
+	var res sql.Result
+	err := xray.Capture(ctx, name, func(ctx context.Context) error {
+		r.logQuery(ctx, name)
+
+		stmt, err := r.stmt(ctx, tx, name)
+		switch {
+		case err != nil:
+			return err
+		case stmt != nil:
+			res, err = stmt.ExecContext(ctx, args...)
+		case tx != nil:
+			res, err = tx.ExecContext(ctx, queries[name], args...)
+		default:
+			res, err = r.db.ExecContext(ctx, queries[name], args...)
+		}
+		return err
+	})
+	return res, err
+}
+
+// query is exec for statements returning rows, the caller closes them
+func (r *repo) query(ctx context.Context, tx *sql.Tx, name string, args ...interface{}) (*sql.Rows, error) {
+	if tx == nil {
+		tx = txFrom(ctx)
+	}
+
+	var rows *sql.Rows
+	err := xray.Capture(ctx, name, func(ctx context.Context) error {
+		r.logQuery(ctx, name)
+
+		stmt, err := r.stmt(ctx, tx, name)
+		switch {
+		case err != nil:
+			return err
+		case stmt != nil:
+			rows, err = stmt.QueryContext(ctx, args...)
+		case tx != nil:
+			rows, err = tx.QueryContext(ctx, queries[name], args...)
+		default:
+			rows, err = r.db.QueryContext(ctx, queries[name], args...)
+		}
+		return err
+	})
+	return rows, err
+}
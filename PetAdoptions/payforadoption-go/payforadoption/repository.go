@@ -1,17 +1,26 @@
 package payforadoption
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"sync"
+	"time"
+
+	"petadoptions/awsmetrics"
+	"petadoptions/httpclient"
+	"petadoptions/logging"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/dghubble/sling"
 	"github.com/go-kit/kit/log"
@@ -21,12 +30,18 @@ import (
 
 // Repository as an interface to define data store interactions
 type Repository interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 	CreateTransaction(ctx context.Context, a Adoption) error
-	DropTransactions(ctx context.Context) error
+	DeleteTransaction(ctx context.Context, a Adoption) error
+	DropTransactions(ctx context.Context) (CleanupResult, error)
+	GetTransactionHistory(ctx context.Context, q HistoryQuery) ([]Adoption, error)
 	UpdateAvailability(ctx context.Context, a Adoption) error
-	TriggerSeeding(ctx context.Context) error
+	StoreReceipt(ctx context.Context, a Adoption) (string, error)
+	TriggerSeeding(ctx context.Context, opts SeedOptions) error
 	CreateSQLTable(ctx context.Context) error
-	ErrorModeOn(ctx context.Context) bool
+	SlowQuery(ctx context.Context, seconds float64) error
+	LockTransactions(ctx context.Context, hold time.Duration) error
+	AuditSink
 }
 
 type Config struct {
@@ -34,7 +49,63 @@ type Config struct {
 	RDSSecretArn      string
 	S3BucketName      string
 	DynamoDBTable     string
-	AWSRegion         string
+	EventBusName      string
+	SNSTopicArn       string
+	StorageBackend    string
+	TransactionsTable string
+	ArchiveMode       string
+	AuditLogGroup     string
+	AllowedRoleArns   []string
+	AllowDiskPressure bool
+	// honour X-Degradation-Scenario on SigV4 authenticated requests
+	AllowScenarioHeader bool
+	DBAuthMode          string
+	DBIAMUser           string
+	DBSSLMode           string
+	DBSSLRootCert       string
+	DBConnectTimeout    int
+	DBProxyEndpoint     string
+	LogLevel            string
+	AWSRegion           string
+	RequestTimeout      time.Duration
+	LatencyBuckets      []float64
+	NativeHistograms    bool
+	// share of the successful requests written to the access log
+	AccessLogSampleRate float64
+	// pooling and timeouts of the client calling the other services
+	HTTPClient httpclient.Options
+	// nil keeps the sampler of the environment
+	SamplingRules *SamplingRules
+}
+
+// UsesDynamoDB reports whether transactions are stored in DynamoDB instead of RDS
+func (c Config) UsesDynamoDB() bool {
+	return c.StorageBackend == "dynamodb"
+}
+
+// UsesRDSProxy reports whether connections go through an RDS Proxy endpoint
+func (c Config) UsesRDSProxy() bool {
+	return c.DBProxyEndpoint != ""
+}
+
+// UsesIAMAuth reports whether RDS connections authenticate with IAM tokens
+// instead of the secret password
+func (c Config) UsesIAMAuth() bool {
+	return c.DBAuthMode == "iam"
+}
+
+// ArchiveMode values controlling what DropTransactions keeps before deleting
+const (
+	ArchiveNone    = ""
+	ArchiveHistory = "history"
+	ArchiveS3      = "s3"
+)
+
+// CleanupResult counts the transactions handled by DropTransactions
+type CleanupResult struct {
+	Archived int64  `json:"archived"`
+	Deleted  int64  `json:"deleted"`
+	Location string `json:"location,omitempty"`
 }
 
 var RepoErr = errors.New("Unable to handle Repo Request")
@@ -42,58 +113,214 @@ var RepoErr = errors.New("Unable to handle Repo Request")
 //repo as an implementation of Repository with dependency injection
 type repo struct {
 	db     *sql.DB
-	cfg    Config
+	config *ConfigStore
 	logger log.Logger
+	seed   *seedCache
+	stmts  *statements
+	// shared by the calls so their connections are reused
+	client *http.Client
 }
 
-func NewRepository(db *sql.DB, cfg Config, logger log.Logger) Repository {
+func NewRepository(db *sql.DB, config *ConfigStore, logger log.Logger, client *http.Client) Repository {
 	return &repo{
 		db:     db,
-		cfg:    cfg,
+		config: config,
 		logger: log.With(logger, "repo", "sql"),
+		seed:   &seedCache{},
+		stmts:  newStatements(db),
+		client: client,
 	}
 }
 
-func (r *repo) CreateTransaction(ctx context.Context, a Adoption) error {
+// cfg returns the current configuration, which may change between calls
+func (r *repo) cfg() Config {
+	return r.config.Load()
+}
 
-	sql := `
-		INSERT INTO transactions (pet_id, transaction_id, adoption_date)
-		VALUES ($1, $2, $3)
-	`
+// logQuery logs the query name and tags the trace with the database access path
+func (r *repo) logQuery(ctx context.Context, name string) {
+	r.logger.Log("sql", name)
+	if seg := xray.GetSegment(ctx); seg != nil {
+		seg.AddAnnotation("db_proxy", r.cfg().UsesRDSProxy())
+	}
+	annotateBaggage(ctx)
+}
 
-	r.logger.Log("sql", sql)
-	_, err := r.db.ExecContext(ctx, sql, a.PetID, a.TransactionID, a.AdoptionDate)
+func (r *repo) CreateTransaction(ctx context.Context, a Adoption) error {
+
+	_, err := r.exec(ctx, nil, queryInsertTransaction, a.PetID, a.TransactionID, a.AdoptionDate, a.UserID)
 
 	if err != nil {
-		return err
+		return databaseError(err)
 	}
 	return nil
 }
 
-func (r *repo) DropTransactions(ctx context.Context) error {
+// DeleteTransaction removes a single transaction, compensating a failed adoption
+func (r *repo) DeleteTransaction(ctx context.Context, a Adoption) error {
 
-	sql := `DELETE FROM transactions`
+	_, err := r.exec(ctx, nil, queryDeleteTransaction, a.TransactionID)
 
-	r.logger.Log("sql", sql)
-	_, err := r.db.ExecContext(ctx, sql)
+	return databaseError(err)
+}
+
+func (r *repo) DropTransactions(ctx context.Context) (CleanupResult, error) {
+
+	switch r.cfg().ArchiveMode {
+	case ArchiveHistory:
+		return r.archiveToHistory(ctx)
+	case ArchiveS3:
+		return r.archiveToS3(ctx)
+	}
+
+	res, err := r.exec(ctx, nil, queryDeleteTransactions)
 	if err != nil {
-		return err
+		return CleanupResult{}, databaseError(err)
 	}
-	return nil
+
+	deleted, _ := res.RowsAffected()
+	return CleanupResult{Deleted: deleted}, nil
+}
+
+// archiveToHistory moves every transaction to transactions_history in a
+// single statement, stamping the rows with cleaned_at
+func (r *repo) archiveToHistory(ctx context.Context) (CleanupResult, error) {
+
+	res, err := r.exec(ctx, nil, queryArchiveToHistory)
+	if err != nil {
+		return CleanupResult{}, databaseError(err)
+	}
+
+	archived, _ := res.RowsAffected()
+	return CleanupResult{Archived: archived, Deleted: archived, Location: "transactions_history"}, nil
+}
+
+type archivedTransaction struct {
+	PetID         string    `json:"petid"`
+	TransactionID string    `json:"transactionid"`
+	AdoptionDate  time.Time `json:"adoptiondate"`
+	CleanedAt     time.Time `json:"cleaned_at"`
+}
+
+// archiveToS3 snapshots the transactions to a JSON object before deleting
+// them. Rows are locked for the duration so nothing is deleted unarchived.
+func (r *repo) archiveToS3(ctx context.Context) (CleanupResult, error) {
+	cfg := r.cfg()
+	if cfg.S3BucketName == "" {
+		return CleanupResult{}, errors.New("s3 archive mode requires a bucket name")
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return CleanupResult{}, databaseError(err)
+	}
+	defer tx.Rollback()
+
+	rows, err := r.query(ctx, tx, querySelectForArchive)
+	if err != nil {
+		return CleanupResult{}, databaseError(err)
+	}
+
+	cleanedAt := time.Now().UTC()
+	archive := []archivedTransaction{}
+	for rows.Next() {
+		t := archivedTransaction{CleanedAt: cleanedAt}
+		if err := rows.Scan(&t.PetID, &t.TransactionID, &t.AdoptionDate); err != nil {
+			rows.Close()
+			return CleanupResult{}, err
+		}
+		archive = append(archive, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return CleanupResult{}, err
+	}
+
+	body, err := json.Marshal(archive)
+	if err != nil {
+		return CleanupResult{}, err
+	}
+
+	key := fmt.Sprintf("archive/transactions-%s.json", cleanedAt.Format("20060102T150405Z"))
+
+	svc := s3.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
+
+	_, err = svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.S3BucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return CleanupResult{}, dependencyError(err)
+	}
+
+	res, err := r.exec(ctx, tx, queryDeleteTransactions)
+	if err != nil {
+		return CleanupResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return CleanupResult{}, err
+	}
+
+	deleted, _ := res.RowsAffected()
+	return CleanupResult{
+		Archived: int64(len(archive)),
+		Deleted:  deleted,
+		Location: fmt.Sprintf("s3://%s/%s", cfg.S3BucketName, key),
+	}, nil
+}
+
+// HistoryQuery filters and paginates archived transactions. A zero From or To
+// leaves that end of the date range open.
+type HistoryQuery struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+func (r *repo) GetTransactionHistory(ctx context.Context, q HistoryQuery) ([]Adoption, error) {
+
+	rows, err := r.query(ctx, nil, querySelectHistory, nullDate(q.From), nullDate(q.To), q.Limit, q.Offset)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	res := []Adoption{}
+	for rows.Next() {
+		var a Adoption
+		if err := rows.Scan(&a.PetID, &a.TransactionID, &a.AdoptionDate); err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+
+	return res, rows.Err()
+}
+
+// nullDate maps a zero time to a sql NULL so the bound stays open
+func nullDate(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
 }
 
 func (r *repo) UpdateAvailability(ctx context.Context, a Adoption) error {
 	logger := log.With(r.logger, "method", "UpdateAvailability")
 	subsegCtx, subseg := xray.BeginSubsegment(ctx, "UpdateAvailability")
 	defer subseg.Close(nil)
+	annotateBaggage(subsegCtx)
 
 	errs := make(chan error)
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	// using xray as a wrapper for http client
-	client := xray.Client(&http.Client{})
-
 	go func() {
 		defer wg.Done()
 
@@ -103,9 +330,11 @@ func (r *repo) UpdateAvailability(ctx context.Context, a Adoption) error {
 		)
 		defer updateAdoptionStatusSeg.Close(nil)
 
-		body := &completeAdoptionRequest{a.PetID, a.PetType}
-		req, _ := sling.New().Put(r.cfg.UpdateAdoptionURL).BodyJSON(body).Request()
-		resp, err := client.Do(req.WithContext(updateAdoptionStatusCtx))
+		body := &completeAdoptionRequest{PetId: a.PetID, PetType: a.PetType}
+		req, _ := sling.New().Put(r.cfg().UpdateAdoptionURL).BodyJSON(body).Request()
+		injectBaggage(updateAdoptionStatusCtx, req)
+		logging.InjectRequestID(updateAdoptionStatusCtx, req.Header)
+		resp, err := r.client.Do(req.WithContext(updateAdoptionStatusCtx))
 		if err != nil {
 			level.Error(logger).Log("err", err)
 			errs <- err
@@ -132,11 +361,15 @@ func (r *repo) UpdateAvailability(ctx context.Context, a Adoption) error {
 		defer availabilitySeg.Close(nil)
 
 		req, _ := http.NewRequest("GET", "https://amazon.com", nil)
-		_, err := client.Do(req.WithContext(availabilityCtx))
+		resp, err := r.client.Do(req.WithContext(availabilityCtx))
 		if err != nil {
 			level.Error(logger).Log("err", err)
 			errs <- err
+			return
 		}
+		// the connection only goes back to the pool once the body is read
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
 	}()
 
 	go func() {
@@ -147,13 +380,61 @@ func (r *repo) UpdateAvailability(ctx context.Context, a Adoption) error {
 	// return the first error
 	for err := range errs {
 		if err != nil {
-			return err
+			return dependencyError(err)
 		}
 	}
 
 	return nil
 }
 
+type receipt struct {
+	TransactionID string    `json:"transactionid"`
+	PetID         string    `json:"petid"`
+	PetType       string    `json:"pettype"`
+	AdoptionDate  time.Time `json:"adoptiondate"`
+	Status        string    `json:"status"`
+}
+
+// StoreReceipt renders the adoption receipt as JSON and writes it to the
+// configured S3 bucket. It returns the object key, or an empty key when no
+// bucket is configured.
+func (r *repo) StoreReceipt(ctx context.Context, a Adoption) (string, error) {
+	cfg := r.cfg()
+	if cfg.S3BucketName == "" {
+		return "", nil
+	}
+
+	body, err := json.MarshalIndent(receipt{
+		TransactionID: a.TransactionID,
+		PetID:         a.PetID,
+		PetType:       a.PetType,
+		AdoptionDate:  a.AdoptionDate,
+		Status:        "completed",
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("receipts/%s/%s.json", a.AdoptionDate.Format("2006-01-02"), a.TransactionID)
+
+	svc := s3.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
+
+	_, err = svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.S3BucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	r.logger.Log("method", "StoreReceipt", "bucket", cfg.S3BucketName, "key", key)
+	return key, nil
+}
+
 type Pet struct {
 	Availability string `dynamo:"availability"`
 	CutenessRate string `json:"cuteness_rate" dynamo:"cuteness_rate"`
@@ -164,24 +445,34 @@ type Pet struct {
 	Price        string `dynamo:"price"`
 }
 
-func (r *repo) TriggerSeeding(ctx context.Context) error {
-
-	seedRawData, err := r.fetchSeedData()
+func (r *repo) TriggerSeeding(ctx context.Context, opts SeedOptions) error {
 
-	if err != nil {
-		level.Error(r.logger).Log("err", err)
+	if err := r.seedPets(ctx, opts); err != nil {
 		return err
 	}
 
-	var pets []Pet
+	sqlErr := r.CreateSQLTable(ctx)
+	if sqlErr != nil {
+		return sqlErr
+	}
 
-	if err := json.Unmarshal([]byte(seedRawData), &pets); err != nil {
-		level.Error(r.logger).Log("err", err)
+	return nil
+
+}
+
+// seedPets loads the pet catalog into the DynamoDB pets table
+func (r *repo) seedPets(ctx context.Context, opts SeedOptions) error {
+	cfg := r.cfg()
+
+	pets, err := r.catalog(ctx, opts)
+	if err != nil {
 		return err
 	}
 
-	db := dynamo.New(session.New(), &aws.Config{Region: aws.String(r.cfg.AWSRegion)})
-	table := db.Table(r.cfg.DynamoDBTable)
+	sess := session.New()
+	awsmetrics.Instrument(&sess.Handlers)
+	db := dynamo.New(sess, &aws.Config{Region: aws.String(cfg.AWSRegion)})
+	table := db.Table(cfg.DynamoDBTable)
 
 	bw := table.Batch().Write()
 	for _, i := range pets {
@@ -192,18 +483,55 @@ func (r *repo) TriggerSeeding(ctx context.Context) error {
 
 	r.logger.Log("res", res, "err", err)
 
-	sqlErr := r.CreateSQLTable(ctx)
-	if sqlErr != nil {
-		return sqlErr
+	return nil
+}
+
+// catalog returns the pets to seed, either generated or from the seed file
+func (r *repo) catalog(ctx context.Context, opts SeedOptions) ([]Pet, error) {
+
+	if opts.Count > 0 {
+		r.logger.Log("seed", "generated", "count", opts.Count, "petTypes", fmt.Sprint(opts.PetTypes))
+		return generatePets(opts), nil
 	}
 
-	return nil
+	seedRawData, err := r.fetchSeedData(ctx)
+
+	if err != nil {
+		level.Error(r.logger).Log("err", err)
+		return nil, err
+	}
+
+	var pets []Pet
 
+	if err := json.Unmarshal([]byte(seedRawData), &pets); err != nil {
+		level.Error(r.logger).Log("err", err)
+		return nil, err
+	}
+
+	return pets, nil
 }
 
-func (r *repo) fetchSeedData() (string, error) {
+const seedObjectKey = "seed.json"
+
+// seedCache keeps the last seed file downloaded from S3 with its ETag
+type seedCache struct {
+	mtx  sync.Mutex
+	etag string
+	data []byte
+}
+
+// fetchSeedData prefers the seed file from the S3 bucket so operators can
+// customize the catalog, falling back to the one bundled in the image
+func (r *repo) fetchSeedData(ctx context.Context) (string, error) {
+
+	if r.cfg().S3BucketName != "" {
+		data, err := r.fetchSeedDataFromS3(ctx)
+		if err == nil {
+			return string(data), nil
+		}
+		level.Error(r.logger).Log("seed", "s3", "err", err)
+	}
 
-	//TODO Fetch from s3
 	data, err := ioutil.ReadFile("seed.json")
 	if err != nil {
 		r.logger.Log("err", err)
@@ -212,23 +540,84 @@ func (r *repo) fetchSeedData() (string, error) {
 	return string(data), nil
 }
 
-func (r *repo) ErrorModeOn(ctx context.Context) bool {
+// fetchSeedDataFromS3 only downloads the object again when its ETag changed
+func (r *repo) fetchSeedDataFromS3(ctx context.Context) ([]byte, error) {
+	cfg := r.cfg()
+	r.seed.mtx.Lock()
+	defer r.seed.mtx.Unlock()
 
-	svc := ssm.New(session.New(&aws.Config{Region: aws.String(r.cfg.AWSRegion)}))
+	svc := s3.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
 
-	res, err := svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
-		Name: aws.String("/petstore/errormode1"),
-	})
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(cfg.S3BucketName),
+		Key:    aws.String(seedObjectKey),
+	}
+	if r.seed.etag != "" {
+		input.IfNoneMatch = aws.String(r.seed.etag)
+	}
 
+	res, err := svc.GetObjectWithContext(ctx, input)
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotModified {
+		r.logger.Log("seed", "s3", "etag", r.seed.etag, "cache", "hit")
+		return r.seed.data, nil
+	}
 	if err != nil {
-		return false
+		return nil, err
 	}
+	defer res.Body.Close()
 
-	if aws.StringValue(res.Parameter.Value) == "true" {
-		return true
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	return false
+	r.seed.etag = aws.StringValue(res.ETag)
+	r.seed.data = data
+
+	r.logger.Log("seed", "s3", "etag", r.seed.etag, "cache", "miss")
+	return data, nil
+}
+
+// SlowQuery holds a real query open on the server with pg_sleep, so the wait
+// shows up in Performance Insights and the SQL subsegments
+func (r *repo) SlowQuery(ctx context.Context, seconds float64) error {
+
+	_, err := r.exec(ctx, nil, querySlowQuery, seconds)
+
+	return databaseError(err)
+}
+
+// LockTransactions takes row locks on the oldest transactions and keeps them
+// for hold, callers locking the same rows queue up behind it
+func (r *repo) LockTransactions(ctx context.Context, hold time.Duration) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return databaseError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := r.exec(ctx, tx, queryLockOldestTransactions); err != nil {
+		return databaseError(err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(hold):
+	}
+
+	return databaseError(tx.Commit())
+}
+
+// Record writes the audit record to the audit_log table
+func (r *repo) Record(ctx context.Context, rec AuditRecord) error {
+
+	_, err := r.exec(ctx, nil, queryInsertAuditRecord,
+		rec.TraceID, rec.Operation, rec.Actor, rec.UserAgent, rec.Details, rec.Error, rec.Timestamp)
+
+	return err
 }
 
 func (r *repo) CreateSQLTable(ctx context.Context) error {
@@ -238,6 +627,28 @@ func (r *repo) CreateSQLTable(ctx context.Context) error {
 		adoption_date DATE,
 		transaction_id VARCHAR
 	);
+	CREATE TABLE IF NOT EXISTS transactions_history (
+		id SERIAL PRIMARY KEY,
+		pet_id VARCHAR,
+		adoption_date DATE,
+		transaction_id VARCHAR
+	);
+	ALTER TABLE transactions_history ADD COLUMN IF NOT EXISTS cleaned_at TIMESTAMP;
+	ALTER TABLE transactions ADD COLUMN IF NOT EXISTS user_id VARCHAR;
+	ALTER TABLE transactions_history ADD COLUMN IF NOT EXISTS user_id VARCHAR;
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id SERIAL PRIMARY KEY,
+		trace_id VARCHAR,
+		operation VARCHAR,
+		actor VARCHAR,
+		user_agent VARCHAR,
+		details VARCHAR,
+		error VARCHAR,
+		created_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_trace_id_idx ON audit_log (trace_id);
+	CREATE INDEX IF NOT EXISTS transactions_adoption_date_idx ON transactions (adoption_date, id);
+	CREATE INDEX IF NOT EXISTS transactions_user_id_idx ON transactions (user_id, id);
 	`
 	_, err := r.db.ExecContext(ctx, sql)
 
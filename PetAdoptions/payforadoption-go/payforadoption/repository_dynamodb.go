@@ -0,0 +1,135 @@
+package payforadoption
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"petadoptions/awsmetrics"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+	"github.com/guregu/dynamo"
+)
+
+// transactionItem is the DynamoDB representation of a row of the
+// transactions table. History is captured by the table stream.
+type transactionItem struct {
+	TransactionID string    `dynamo:"transaction_id,hash"`
+	PetID         string    `dynamo:"pet_id"`
+	PetType       string    `dynamo:"pet_type"`
+	UserID        string    `dynamo:"user_id,omitempty"`
+	AdoptionDate  time.Time `dynamo:"adoption_date"`
+}
+
+// ddbRepo stores transactions in DynamoDB and shares everything else
+// (availability updates, receipts, seeding, error mode) with the sql repo
+type ddbRepo struct {
+	*repo
+	table dynamo.Table
+}
+
+func NewDynamoDBRepository(config *ConfigStore, logger log.Logger, client *http.Client) Repository {
+	cfg := config.Load()
+	svc := dynamodb.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+	xray.AWS(svc.Client)
+	awsmetrics.Instrument(&svc.Handlers)
+
+	return &ddbRepo{
+		repo: &repo{
+			config: config,
+			logger: log.With(logger, "repo", "dynamodb"),
+			seed:   &seedCache{},
+			client: client,
+		},
+		table: dynamo.NewFromIface(svc).Table(cfg.TransactionsTable),
+	}
+}
+
+// WithinTransaction runs fn without grouping the writes, each DynamoDB write
+// is applied on its own
+func (r *ddbRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (r *ddbRepo) CreateTransaction(ctx context.Context, a Adoption) error {
+	item := transactionItem{
+		TransactionID: a.TransactionID,
+		PetID:         a.PetID,
+		PetType:       a.PetType,
+		UserID:        a.UserID,
+		AdoptionDate:  a.AdoptionDate,
+	}
+
+	r.logger.Log("method", "CreateTransaction", "table", r.cfg().TransactionsTable)
+	return databaseError(r.table.Put(item).RunWithContext(ctx))
+}
+
+func (r *ddbRepo) DeleteTransaction(ctx context.Context, a Adoption) error {
+	r.logger.Log("method", "DeleteTransaction", "table", r.cfg().TransactionsTable)
+	return databaseError(r.table.Delete("transaction_id", a.TransactionID).RunWithContext(ctx))
+}
+
+// DropTransactions ignores the archive mode, deletions are already
+// captured by the table stream
+func (r *ddbRepo) DropTransactions(ctx context.Context) (CleanupResult, error) {
+	var items []transactionItem
+
+	err := r.table.Scan().Project("transaction_id").AllWithContext(ctx, &items)
+	if err != nil {
+		return CleanupResult{}, databaseError(err)
+	}
+
+	if len(items) == 0 {
+		return CleanupResult{}, nil
+	}
+
+	keys := make([]dynamo.Keyed, 0, len(items))
+	for _, i := range items {
+		keys = append(keys, dynamo.Keys{i.TransactionID})
+	}
+
+	res, err := r.table.Batch("transaction_id").Write().Delete(keys...).RunWithContext(ctx)
+
+	r.logger.Log("method", "DropTransactions", "deleted", res, "err", err)
+	return CleanupResult{Deleted: int64(res)}, databaseError(err)
+}
+
+// GetTransactionHistory is not supported, history lives in the table stream
+func (r *ddbRepo) GetTransactionHistory(ctx context.Context, q HistoryQuery) ([]Adoption, error) {
+	return nil, ErrNotSupported
+}
+
+// Record only logs the audit record, there is no audit table in this mode
+func (r *ddbRepo) Record(ctx context.Context, rec AuditRecord) error {
+	r.logger.Log(
+		"audit", rec.Operation,
+		"traceId", rec.TraceID,
+		"actor", rec.Actor,
+		"details", rec.Details,
+		"err", rec.Error)
+	return nil
+}
+
+// TriggerSeeding only seeds the pets table, there is no sql schema to create
+func (r *ddbRepo) TriggerSeeding(ctx context.Context, opts SeedOptions) error {
+	return r.seedPets(ctx, opts)
+}
+
+// CreateSQLTable is a no-op, the transactions table is provisioned with the stack
+func (r *ddbRepo) CreateSQLTable(ctx context.Context) error {
+	return nil
+}
+
+// SlowQuery is not supported, there is no SQL engine to hold a query on
+func (r *ddbRepo) SlowQuery(ctx context.Context, seconds float64) error {
+	return ErrNotSupported
+}
+
+// LockTransactions is not supported, DynamoDB has no row locks
+func (r *ddbRepo) LockTransactions(ctx context.Context, hold time.Duration) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,178 @@
+package payforadoption
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+)
+
+// historyDriver is a database/sql driver answering every query with the
+// same rows and recording the arguments it was called with
+type historyDriver struct {
+	mu   sync.Mutex
+	args []driver.Value
+	rows [][]driver.Value
+}
+
+func (d *historyDriver) Open(string) (driver.Conn, error) { return historyConn{d}, nil }
+
+func (d *historyDriver) lastArgs() []driver.Value {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.args
+}
+
+type historyConn struct{ d *historyDriver }
+
+func (c historyConn) Prepare(string) (driver.Stmt, error) { return historyStmt(c), nil }
+func (c historyConn) Close() error                        { return nil }
+func (c historyConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type historyStmt struct{ d *historyDriver }
+
+func (s historyStmt) Close() error  { return nil }
+func (s historyStmt) NumInput() int { return -1 }
+
+func (s historyStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s historyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.args = args
+	return &historyRows{rows: s.d.rows}, nil
+}
+
+type historyRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *historyRows) Columns() []string {
+	return []string{"pet_id", "transaction_id", "adoption_date"}
+}
+
+func (r *historyRows) Close() error { return nil }
+
+func (r *historyRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+var historyDrv = &historyDriver{}
+
+func init() {
+	sql.Register("history-test", historyDrv)
+}
+
+func newHistoryRepo(t *testing.T, rows ...[]driver.Value) *repo {
+	historyDrv.mu.Lock()
+	historyDrv.args = nil
+	historyDrv.rows = rows
+	historyDrv.mu.Unlock()
+
+	db, err := sql.Open("history-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewRepository(db, NewConfigStore(Config{}), log.NewNopLogger(), nil).(*repo)
+}
+
+func TestGetTransactionHistoryDateRange(t *testing.T) {
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		q        HistoryQuery
+		from, to driver.Value
+	}{
+		{"open range", HistoryQuery{Limit: 25}, nil, nil},
+		{"from only", HistoryQuery{From: from, Limit: 25}, from, nil},
+		{"to only", HistoryQuery{To: to, Limit: 25}, nil, to},
+		{"both bounds", HistoryQuery{From: from, To: to, Limit: 25}, from, to},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newHistoryRepo(t)
+			ctx, seg := xray.BeginSegment(context.Background(), "test")
+			defer seg.Close(nil)
+
+			if _, err := r.GetTransactionHistory(ctx, tt.q); err != nil {
+				t.Fatal(err)
+			}
+
+			args := historyDrv.lastArgs()
+			if len(args) != 4 {
+				t.Fatalf("got %d arguments, want 4", len(args))
+			}
+			if args[0] != tt.from {
+				t.Errorf("from = %v, want %v", args[0], tt.from)
+			}
+			if args[1] != tt.to {
+				t.Errorf("to = %v, want %v", args[1], tt.to)
+			}
+		})
+	}
+}
+
+func TestGetTransactionHistoryPagination(t *testing.T) {
+	day := time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)
+	r := newHistoryRepo(t,
+		[]driver.Value{"024", "tx-2", day},
+		[]driver.Value{"011", "tx-1", day.AddDate(0, 0, -1)},
+	)
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	res, err := r.GetTransactionHistory(ctx, HistoryQuery{Limit: 2, Offset: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := historyDrv.lastArgs()
+	if args[2] != int64(2) || args[3] != int64(4) {
+		t.Errorf("limit, offset = %v, %v, want 2, 4", args[2], args[3])
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(res))
+	}
+	if res[0].TransactionID != "tx-2" || res[0].PetID != "024" || !res[0].AdoptionDate.Equal(day) {
+		t.Errorf("unexpected first transaction %+v", res[0])
+	}
+	if res[1].TransactionID != "tx-1" {
+		t.Errorf("unexpected second transaction %+v", res[1])
+	}
+}
+
+func TestGetTransactionHistoryEmpty(t *testing.T) {
+	r := newHistoryRepo(t)
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	res, err := r.GetTransactionHistory(ctx, HistoryQuery{Limit: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// encoded as [] rather than null
+	if res == nil || len(res) != 0 {
+		t.Errorf("got %#v, want an empty list", res)
+	}
+}
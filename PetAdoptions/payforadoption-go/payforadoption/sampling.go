@@ -0,0 +1,86 @@
+package payforadoption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// SamplingRules are the trace sampling settings shared by the services in the
+// /petstore/samplingrules parameter, so they can be changed during a workshop
+// without a deployment, e.g.
+//
+//	{"defaultRatio": 0.05, "routes": {"/api/home/completeadoption": 0.5},
+//	 "sampleOnError": true, "sampleAllInErrorMode": true}
+type SamplingRules struct {
+	// DefaultRatio applies to the routes without a rule, unset keeps the
+	// sampler of the environment for them
+	DefaultRatio *float64 `json:"defaultRatio,omitempty"`
+	// Routes maps a request path to its ratio
+	Routes map[string]float64 `json:"routes,omitempty"`
+	// SampleOnError keeps the traces of the requests which failed
+	SampleOnError bool `json:"sampleOnError,omitempty"`
+	// SampleAllInErrorMode samples every adoption while error mode is on
+	SampleAllInErrorMode bool `json:"sampleAllInErrorMode,omitempty"`
+}
+
+// ParseSamplingRules reads the JSON rules, an empty string returns no rules
+func ParseSamplingRules(s string) (*SamplingRules, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var rules SamplingRules
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("invalid sampling rules: %v", err)
+	}
+
+	if r := rules.DefaultRatio; r != nil && (*r < 0 || *r > 1) {
+		return nil, fmt.Errorf("invalid default sampling ratio %g", *r)
+	}
+	for route, r := range rules.Routes {
+		if r < 0 || r > 1 {
+			return nil, fmt.Errorf("invalid sampling ratio %g for %s", r, route)
+		}
+	}
+	return &rules, nil
+}
+
+// Ratio returns the sampling ratio of the path, false when no rule applies
+func (r *SamplingRules) Ratio(path string) (float64, bool) {
+	if ratio, ok := r.Routes[path]; ok {
+		return ratio, true
+	}
+	if r.DefaultRatio != nil {
+		return *r.DefaultRatio, true
+	}
+	return 0, false
+}
+
+// keepFailedTraces is a ServerFinalizer sampling the segment of a failed
+// request when the rules ask for it. The SDK records the segments of the
+// requests it did not sample and only drops them when they are emitted, the
+// calls made downstream were told the trace is not sampled though.
+func keepFailedTraces(store *ConfigStore) httptransport.ServerFinalizerFunc {
+	return func(ctx context.Context, code int, _ *http.Request) {
+		if code < http.StatusBadRequest {
+			return
+		}
+		if rules := store.Load().SamplingRules; rules == nil || !rules.SampleOnError {
+			return
+		}
+
+		seg := xray.GetSegment(ctx)
+		if seg == nil {
+			return
+		}
+		root := seg.ParentSegment
+		root.Lock()
+		root.Sampled = true
+		root.Unlock()
+	}
+}
@@ -0,0 +1,67 @@
+package payforadoption
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const maxSeedCount = 10000
+
+// SeedOptions customizes TriggerSeeding. The zero value loads the seed file,
+// a positive Count generates a synthetic catalog instead. A non zero Seed makes
+// the generated catalog the same on every run, for reproducible load tests.
+type SeedOptions struct {
+	Count    int      `json:"count,omitempty"`
+	PetTypes []string `json:"petTypes,omitempty"`
+	Seed     int64    `json:"seed,omitempty"`
+}
+
+// petProfile describes what the synthetic pets of a type look like. Image
+// counts match the pictures shipped with the PetSite.
+type petProfile struct {
+	images   int
+	colors   []string
+	minPrice int
+	maxPrice int
+}
+
+var petProfiles = map[string]petProfile{
+	"bunny":  {images: 4, colors: []string{"brown", "white"}, minPrice: 45, maxPrice: 95},
+	"kitten": {images: 7, colors: []string{"black", "brown", "grey", "white"}, minPrice: 65, maxPrice: 99},
+	"puppy":  {images: 15, colors: []string{"black", "brown", "white"}, minPrice: 59, maxPrice: 100},
+}
+
+// generatePets builds a procedural catalog of opts.Count available pets,
+// spread evenly across the requested pet types
+func generatePets(opts SeedOptions) []Pet {
+	petTypes := opts.PetTypes
+	if len(petTypes) == 0 {
+		petTypes = []string{"bunny", "kitten", "puppy"}
+	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	pets := make([]Pet, 0, opts.Count)
+
+	for i := 0; i < opts.Count; i++ {
+		petType := petTypes[i%len(petTypes)]
+		p := petProfiles[petType]
+
+		pets = append(pets, Pet{
+			Availability: "yes",
+			CutenessRate: strconv.Itoa(3 + rnd.Intn(3)),
+			PetColor:     p.colors[rnd.Intn(len(p.colors))],
+			PetID:        fmt.Sprintf("%03d", i+1),
+			PetType:      petType,
+			Image:        fmt.Sprintf("%c%d", petType[0], 1+rnd.Intn(p.images)),
+			Price:        strconv.Itoa(p.minPrice + rnd.Intn(p.maxPrice-p.minPrice+1)),
+		})
+	}
+
+	return pets
+}
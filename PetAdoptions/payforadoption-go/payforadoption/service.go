@@ -2,43 +2,73 @@ package payforadoption
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"runtime"
 	"time"
 
+	"petadoptions/events"
+	"petadoptions/flags"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
 	"github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type Adoption struct {
 	TransactionID string `json:"transactionid,omitempty"`
 	PetID         string `json:"petid,omitempty"`
 	PetType       string `json:"pettype,omitempty"`
+	UserID        string `json:"userid,omitempty"`
+	ReceiptKey    string `json:"receiptkey,omitempty"`
 	AdoptionDate  time.Time
 }
 
 // links endpoints to transport
 type Service interface {
 	HealthCheck(ctx context.Context) error
-	CompleteAdoption(ctx context.Context, petId, petType string) (Adoption, error)
-	CleanupAdoptions(ctx context.Context) error
-	TriggerSeeding(ctx context.Context) error
+	CompleteAdoption(ctx context.Context, petId, petType, userId string) (Adoption, error)
+	CleanupAdoptions(ctx context.Context) (CleanupResult, error)
+	TriggerSeeding(ctx context.Context, opts SeedOptions) error
+	AdoptionHistory(ctx context.Context, q HistoryQuery) ([]Adoption, error)
 }
 
 // object that handles the logic and complies with interface
 type service struct {
 	logger               log.Logger
 	repository           Repository
+	config               *ConfigStore
+	publisher            events.Publisher
+	notifier             events.Notifier
+	flags                *flags.Client
+	scenarios            metrics.Counter
+	sendFailures         metric.Int64Counter
 	updateAdoptionURL    string
 	ddbSeedingLambdaName string
 }
 
 //inject dependencies into core logic
-func NewService(logger log.Logger, rep Repository) Service {
+func NewService(logger log.Logger, rep Repository, config *ConfigStore, pub events.Publisher, n events.Notifier, f *flags.Client) Service {
 	return &service{
 		logger:     logger,
 		repository: rep,
+		config:     config,
+		publisher:  pub,
+		notifier:   n,
+		flags:      f,
+		scenarios:  newDegradationCounter(),
+		// the adoption messages themselves are queued to SQS by PetSite, the
+		// messages this service sends are the EventBridge events and SNS
+		// notifications
+		sendFailures: metric.Must(otel.Meter("payforadoption")).NewInt64Counter(
+			"payforadoption.send_failures_total",
+			metric.WithDescription("Number of events and notifications that could not be sent"),
+		),
 	}
 }
 
@@ -48,7 +78,7 @@ func (s service) HealthCheck(ctx context.Context) error {
 }
 
 // /api/completeadoption logic
-func (s service) CompleteAdoption(ctx context.Context, petId, petType string) (Adoption, error) {
+func (s service) CompleteAdoption(ctx context.Context, petId, petType, userId string) (Adoption, error) {
 	logger := log.With(s.logger, "method", "CompleteAdoption")
 
 	uuid, _ := uuid.NewV4()
@@ -56,12 +86,15 @@ func (s service) CompleteAdoption(ctx context.Context, petId, petType string) (A
 		TransactionID: uuid.String(),
 		PetID:         petId,
 		PetType:       petType,
+		UserID:        userId,
 		AdoptionDate:  time.Now(),
 	}
 
+	f := s.flags.Flags()
+
 	// Introduce memory leaks for pettype bunnies. Sorry bunnies :)
 	if petType == "bunny" {
-		if s.repository.ErrorModeOn(ctx) {
+		if f.Get(flags.ErrorMode).Enabled() {
 			level.Error(logger).Log("errorMode", "On")
 			memoryLeak()
 			return a, errors.New("Illegal memory allocation")
@@ -70,42 +103,167 @@ func (s service) CompleteAdoption(ctx context.Context, petId, petType string) (A
 		}
 	}
 
-	if err := s.repository.CreateTransaction(ctx, a); err != nil {
+	if scenario := degradationScenario(ctx, f, s.config.Load().AllowScenarioHeader); scenario != "" {
+		level.Info(logger).Log("degradationScenario", scenario)
+		s.scenarios.With("scenario", scenario).Add(1)
+		if err := s.degrade(ctx, scenario, f.Get(flags.ChaosScenario)); err != nil {
+			return Adoption{}, err
+		}
+	}
+
+	if slow := f.Get(flags.SlowMode); slow.Enabled() {
+		slowDown(ctx, time.Duration(slow.Int("delayMs", 1000))*time.Millisecond)
+	}
+
+	// the adoption writes are one unit of work: when the pet cannot be marked
+	// adopted, the transaction row is rolled back with the rest
+	var unavailable error
+	err := s.repository.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repository.CreateTransaction(ctx, a); err != nil {
+			return err
+		}
+
+		if err := s.repository.UpdateAvailability(ctx, a); err != nil {
+			unavailable = err
+			return err
+		}
+
+		// a missing receipt should not fail an adoption that already went through
+		key, err := s.repository.StoreReceipt(ctx, a)
+		if err != nil {
+			level.Error(logger).Log("err", err)
+			return nil
+		}
+		a.ReceiptKey = key
+		return nil
+	})
+	if err != nil {
 		level.Error(logger).Log("err", err)
+		// the DynamoDB backend has no rollback, compensate removes what it wrote
+		if unavailable != nil {
+			s.compensate(ctx, logger, a, unavailable)
+		}
 		return Adoption{}, err
 	}
 
-	err := s.repository.UpdateAvailability(ctx, a)
+	s.publish(ctx, logger, events.AdoptionCompleted, a)
+	s.notify(ctx, logger, a)
 
-	return a, err
+	return a, nil
 }
 
-func (s service) CleanupAdoptions(ctx context.Context) error {
+func (s service) CleanupAdoptions(ctx context.Context) (CleanupResult, error) {
 	logger := log.With(s.logger, "method", "CleanupAdoptions")
 
-	if err := s.TriggerSeeding(ctx); err != nil {
+	if err := s.TriggerSeeding(ctx, SeedOptions{}); err != nil {
 		level.Error(logger).Log("err", err)
 	}
 
-	if err := s.repository.DropTransactions(ctx); err != nil {
+	res, err := s.repository.DropTransactions(ctx)
+	if err != nil {
 		level.Error(logger).Log("err", err)
-		return err
+		return res, err
 	}
 
-	return nil
+	s.publish(ctx, logger, events.AdoptionCleanup, res)
+
+	return res, nil
 }
 
-func (s service) TriggerSeeding(ctx context.Context) error {
+func (s service) TriggerSeeding(ctx context.Context, opts SeedOptions) error {
+	logger := log.With(s.logger, "method", "TriggerSeeding")
 
-	if err := s.repository.TriggerSeeding(ctx); err != nil {
-		logger := log.With(s.logger, "method", "TriggerSeeding")
+	if err := s.repository.TriggerSeeding(ctx, opts); err != nil {
 		level.Error(logger).Log("err", err)
 		return err
 	}
 
+	s.publish(ctx, logger, events.SeedingTriggered, opts)
+
 	return nil
 }
 
+func (s service) AdoptionHistory(ctx context.Context, q HistoryQuery) ([]Adoption, error) {
+
+	res, err := s.repository.GetTransactionHistory(ctx, q)
+	if err != nil {
+		logger := log.With(s.logger, "method", "AdoptionHistory")
+		level.Error(logger).Log("err", err)
+	}
+
+	return res, err
+}
+
+// compensationTimeout bounds the compensation and the AdoptionFailed event,
+// which no longer depend on the request context
+const compensationTimeout = 5 * time.Second
+
+// compensate undoes the transaction of an adoption that could not complete, so
+// no orphaned row is left behind while the pet stays available. It runs
+// detached from ctx: the failure is often the request running out of time,
+// which must not cancel the rollback too.
+func (s service) compensate(ctx context.Context, logger log.Logger, a Adoption, cause error) {
+	ctx, cancel := detach(ctx, compensationTimeout)
+	defer cancel()
+
+	err := xray.Capture(ctx, "CompensateAdoption", func(ctx context.Context) error {
+		xray.AddAnnotation(ctx, "transactionId", a.TransactionID)
+		return s.repository.DeleteTransaction(ctx, a)
+	})
+	if err != nil {
+		level.Error(logger).Log("compensation", "failed", "transactionId", a.TransactionID, "err", err)
+	}
+
+	s.publish(ctx, logger, events.AdoptionFailed, adoptionFailure{
+		Adoption:    a,
+		Reason:      cause.Error(),
+		Compensated: err == nil,
+	})
+}
+
+// adoptionFailure is the detail of the AdoptionFailed event
+type adoptionFailure struct {
+	Adoption
+	Reason      string `json:"reason"`
+	Compensated bool   `json:"compensated"`
+}
+
+// events are best effort, a failed publish never fails the request
+func (s service) publish(ctx context.Context, logger log.Logger, detailType string, data interface{}) {
+	if err := s.publisher.Publish(ctx, detailType, data); err != nil {
+		s.sendFailures.Add(ctx, 1, attribute.String("destination", "eventbridge"), attribute.String("event", detailType))
+		level.Error(logger).Log("event", detailType, "err", err)
+	}
+}
+
+// notifications are best effort as well
+func (s service) notify(ctx context.Context, logger log.Logger, a Adoption) {
+	msg, err := json.Marshal(a)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return
+	}
+
+	attributes := baggageAttributes(ctx, map[string]string{
+		"pettype": a.PetType,
+		"userid":  a.UserID,
+	})
+
+	if err := s.notifier.Notify(ctx, "Pet adoption completed", string(msg), attributes); err != nil {
+		s.sendFailures.Add(ctx, 1, attribute.String("destination", "sns"), attribute.String("event", events.AdoptionCompleted))
+		level.Error(logger).Log("notification", "sns", "err", err)
+	}
+}
+
+// slowDown adds latency in its own subsegment so it stands out in the traces
+func slowDown(ctx context.Context, d time.Duration) {
+	_, seg := xray.BeginSubsegment(ctx, "slowMode")
+	seg.AddAnnotation("delayMs", int(d/time.Millisecond))
+	defer seg.Close(nil)
+
+	time.Sleep(d)
+}
+
 func memoryLeak() {
 
 	// loosing time
@@ -0,0 +1,32 @@
+package payforadoption
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/slo"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// observeSLO is a ServerFinalizer recording the request against the
+// objectives of its route, timed from the start set by the access log
+func observeSLO(t *slo.Tracker) httptransport.ServerFinalizerFunc {
+	return func(ctx context.Context, code int, r *http.Request) {
+		begin, ok := ctx.Value(requestStartKey{}).(time.Time)
+		if !ok {
+			return
+		}
+
+		endpoint := routeTemplate(r)
+
+		// the load balancer checks are not user traffic
+		if strings.HasPrefix(endpoint, "/health/") {
+			return
+		}
+
+		t.Observe(r.Method+" "+endpoint, time.Since(begin), code >= http.StatusInternalServerError)
+	}
+}
@@ -4,26 +4,69 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"expvar"
+	"io"
 	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/slo"
+	"petadoptions/canary"
+	"petadoptions/chaos"
+	"petadoptions/flags"
+	"petadoptions/logging"
 
 	"github.com/gorilla/mux"
 
+	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func MakeHTTPHandler(s Service, logger log.Logger) http.Handler {
+// MakeHTTPHandler serves the API, the chaos endpoints are authenticated with
+// chaosAuth and the others with auth. Successful requests are logged at
+// accessLogSampleRate. The traces of failed requests are kept when the
+// sampling rules of the store ask for it, and every request is recorded in slos
+// and in the RED metrics of requests.
+func MakeHTTPHandler(s Service, logger log.Logger, auth, chaosAuth endpoint.Middleware, f *flags.Client, c *chaos.Controller, store *ConfigStore, slos *slo.Tracker, requests *httpmetrics.Metrics, timeout time.Duration, accessLogSampleRate float64) http.Handler {
 	r := mux.NewRouter()
+	// the baggage carries the customer segment the request metrics are
+	// labelled with
+	r.Use(populateBaggage)
+	r.Use(requests.Middleware(routeTemplate))
+	r.Use(profileLabels("petType"))
 	e := MakeEndpoints(s)
+
+	e.CompleteAdoptionEndpoint = injectErrors(f, newInjectedErrorsCounter())(e.CompleteAdoptionEndpoint)
+
+	// health stays unauthenticated for the load balancer
+	e.CompleteAdoptionEndpoint = auth(e.CompleteAdoptionEndpoint)
+	e.CleanupAdoptionsEndpoint = auth(e.CleanupAdoptionsEndpoint)
+	e.TriggerSeedingEndpoint = auth(e.TriggerSeedingEndpoint)
+	e.AdoptionHistoryEndpoint = auth(e.AdoptionHistoryEndpoint)
+
+	// the budget covers authentication, which calls STS
+	exceeded := newDeadlineExceededCounter()
+	e.CompleteAdoptionEndpoint = withDeadline(timeout, "complete_adoptions", exceeded)(e.CompleteAdoptionEndpoint)
+	e.CleanupAdoptionsEndpoint = withDeadline(timeout, "cleanup_adoptions", exceeded)(e.CleanupAdoptionsEndpoint)
+	e.TriggerSeedingEndpoint = withDeadline(timeout, "trigger_seeding", exceeded)(e.TriggerSeedingEndpoint)
+	e.AdoptionHistoryEndpoint = withDeadline(timeout, "adoption_history", exceeded)(e.AdoptionHistoryEndpoint)
+
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 		httptransport.ServerErrorEncoder(encodeError),
-		httptransport.ServerFinalizer(loggingMiddleware),
+		httptransport.ServerBefore(httptransport.PopulateRequestContext, populateBodyHash, populateTimeoutHint, annotateRequestID),
+		httptransport.ServerFinalizer(keepFailedTraces(store)),
 	}
+	options = append(options, newAccessLog(logger, accessLogSampleRate).serverOptions()...)
+	options = append(options, httptransport.ServerFinalizer(observeSLO(slos)))
 
 	r.Methods("GET").Path("/health/status").Handler(httptransport.NewServer(
 		e.HealthCheckEndpoint,
@@ -36,36 +79,113 @@ func MakeHTTPHandler(s Service, logger log.Logger) http.Handler {
 	r.Methods("POST").Path("/api/home/completeadoption").Handler(
 		xray.Handler(
 			xray.NewFixedSegmentNamer("payforadoption"),
-			httptransport.NewServer(
+			injectLatency(f, httptransport.NewServer(
 				e.CompleteAdoptionEndpoint,
 				decodeCompleteAdoptionRequest,
 				encodeResponse,
-				options...,
-			),
+				append(options, httptransport.ServerBefore(populateScenario))...,
+			)),
 		),
 	)
 	// using xray as wrapper for http.Handler
 	r.Methods("POST").Path("/api/home/cleanupadoptions").Handler(
 		xray.Handler(
 			xray.NewFixedSegmentNamer("payforadoption"),
-			httptransport.NewServer(
+			injectLatency(f, httptransport.NewServer(
 				e.CleanupAdoptionsEndpoint,
 				decodeEmptyRequest,
-				encodeEmptyResponse,
+				encodeResponse,
 				options...,
-			),
+			)),
 		),
 	)
 
 	// Trigger DDB seeding
 	r.Methods("POST").Path("/api/home/triggerseeding").Handler(httptransport.NewServer(
 		e.TriggerSeedingEndpoint,
-		decodeEmptyRequest,
+		decodeTriggerSeedingRequest,
 		encodeEmptyResponse,
 		options...,
 	))
 
-	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
+	// using xray as wrapper for http.Handler
+	r.Methods("GET").Path("/api/adoptions/history").Handler(
+		xray.Handler(
+			xray.NewFixedSegmentNamer("payforadoption"),
+			injectLatency(f, httptransport.NewServer(
+				e.AdoptionHistoryEndpoint,
+				decodeAdoptionHistoryRequest,
+				encodeResponse,
+				options...,
+			)),
+		),
+	)
+
+	chaos.RegisterRoutes(r, c, chaosAuth, options...)
+
+	r.Methods("GET").Path("/openapi.json").HandlerFunc(openAPIHandler)
+
+	return logging.RequestIDHandler(r)
+}
+
+// routeTemplate is the path template of the matched route, the path when it
+// has none
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// ResponseTraceID reads the trace of a request from the header xray.Handler
+// answers with, the segment itself is gone once the request is served
+func ResponseTraceID(_ *http.Request, w http.ResponseWriter) string {
+	if h := w.Header().Get(xray.TraceIDHeaderKey); h != "" {
+		return header.FromString(h).TraceID
+	}
+	return ""
+}
+
+// annotateRequestID is a ServerBefore func stamping the request id and the
+// baggage on the segment, so a trace can be found from the id a user quotes
+// and filtered by customer segment, and marking the requests of the canary so
+// they can be filtered out
+func annotateRequestID(ctx context.Context, r *http.Request) context.Context {
+	if seg := xray.GetSegment(ctx); seg != nil {
+		seg.AddAnnotation("requestId", logging.RequestID(ctx))
+		if canary.IsSynthetic(r) {
+			seg.AddAnnotation("synthetic", true)
+		}
+		annotateBaggage(ctx)
+	}
+	return ctx
+}
+
+// MakeAdminHandler serves the metrics, profiling, diagnostics, SLO and log level endpoints, it
+// is bound to its own port so they are neither public nor behind the API middlewares
+func MakeAdminHandler(lv *logging.LevelVar, slos *slo.Tracker) http.Handler {
+	r := http.NewServeMux()
+
+	// exemplars are only exposed in the OpenMetrics format
+	r.Handle("/metrics", promhttp.HandlerFor(
+		stdprometheus.DefaultGatherer,
+		promhttp.HandlerOpts{EnableOpenMetrics: true},
+	))
+
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	r.Handle("/debug/vars", expvar.Handler())
+	r.HandleFunc("/debug/snapshot", snapshotHandler)
+
+	r.Handle("/slo", slos.Handler())
+
+	r.Handle("/admin/loglevel", logging.LevelHandler(lv))
 
 	return r
 }
@@ -77,11 +197,26 @@ type errorer interface {
 type completeAdoptionRequest struct {
 	PetId   string `json:"petid"`
 	PetType string `json:"pettype"`
+	UserId  string `json:"userid,omitempty"`
+}
+
+type adoptionHistoryResponse struct {
+	Transactions []Adoption `json:"transactions"`
+	Limit        int        `json:"limit"`
+	Offset       int        `json:"offset"`
 }
 
+const (
+	defaultHistoryLimit = 25
+	maxHistoryLimit     = 100
+)
+
 var (
-	ErrNotFound   = errors.New("not found")
-	ErrBadRequest = errors.New("Bad request parameters")
+	ErrNotFound     = errors.New("not found")
+	ErrBadRequest   = errors.New("Bad request parameters")
+	ErrNotSupported = errors.New("Not supported by the storage backend")
+	ErrUnauthorized = errors.New("Missing or invalid request signature")
+	ErrForbidden    = errors.New("Caller is not allowed")
 )
 
 func decodeEmptyRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -92,12 +227,66 @@ func decodeCompleteAdoptionRequest(_ context.Context, r *http.Request) (interfac
 
 	petId := r.URL.Query().Get("petId")
 	petType := r.URL.Query().Get("petType")
+	userId := r.URL.Query().Get("userId")
 
-	if petId == "" || petType == "" {
+	return completeAdoptionRequest{petId, petType, userId}, nil
+}
+
+// decodeTriggerSeedingRequest accepts an empty body for the default seed file
+func decodeTriggerSeedingRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var opts SeedOptions
+
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
 		return nil, ErrBadRequest
 	}
 
-	return completeAdoptionRequest{petId, petType}, nil
+	if opts.Count < 0 || opts.Count > maxSeedCount {
+		return nil, ErrBadRequest
+	}
+
+	for _, t := range opts.PetTypes {
+		if !allowedPetTypes[t] {
+			return nil, ErrBadRequest
+		}
+	}
+
+	return opts, nil
+}
+
+func decodeAdoptionHistoryRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := HistoryQuery{Limit: defaultHistoryLimit}
+	params := r.URL.Query()
+	var err error
+
+	if v := params.Get("from"); v != "" {
+		if q.From, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if v := params.Get("to"); v != "" {
+		if q.To, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return nil, ErrBadRequest
+	}
+
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxHistoryLimit {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if v := params.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return nil, ErrBadRequest
+		}
+	}
+
+	return q, nil
 }
 
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
@@ -117,28 +306,25 @@ func encodeEmptyResponse(ctx context.Context, w http.ResponseWriter, response in
 	return nil
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	if err == nil {
 		panic("encodeError with nil error")
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(codeFrom(err))
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
-}
 
-func codeFrom(err error) int {
-	switch err {
-	case ErrNotFound:
-		return http.StatusNotFound
-	case ErrBadRequest:
-		return http.StatusBadRequest
-	default:
-		return http.StatusInternalServerError
+	e := errorFrom(err)
+	res := errorResponse{
+		Code:      e.Code,
+		Message:   err.Error(),
+		Retryable: e.Retryable,
+		Details:   e.Details,
+		RequestID: logging.RequestID(ctx),
+	}
+
+	if segment := xray.GetSegment(ctx); segment != nil {
+		res.TraceID = segment.DownstreamHeader().TraceID
 	}
-}
 
-func loggingMiddleware(ctx context.Context, code int, r *http.Request) {
-	fmt.Println(r.Method, r.RequestURI, r.Proto, r.RemoteAddr, code)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(res)
 }
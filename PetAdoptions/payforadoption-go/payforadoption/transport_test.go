@@ -0,0 +1,93 @@
+package payforadoption
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecodeAdoptionHistoryRequest(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	tests := []struct {
+		query string
+		want  HistoryQuery
+	}{
+		{"", HistoryQuery{Limit: defaultHistoryLimit}},
+		{"from=2021-03-01", HistoryQuery{From: day("2021-03-01"), Limit: defaultHistoryLimit}},
+		{"to=2021-03-31", HistoryQuery{To: day("2021-03-31"), Limit: defaultHistoryLimit}},
+		{"from=2021-03-01&to=2021-03-01", HistoryQuery{From: day("2021-03-01"), To: day("2021-03-01"), Limit: defaultHistoryLimit}},
+		{"limit=10&offset=20", HistoryQuery{Limit: 10, Offset: 20}},
+		{"limit=100", HistoryQuery{Limit: maxHistoryLimit}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/adoptions/history?"+tt.query, nil)
+
+			got, err := decodeAdoptionHistoryRequest(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.(HistoryQuery) != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAdoptionHistoryRequestInvalid(t *testing.T) {
+	for _, query := range []string{
+		"from=03/01/2021",
+		"to=yesterday",
+		"from=2021-03-31&to=2021-03-01",
+		"limit=0",
+		"limit=101",
+		"limit=ten",
+		"offset=-1",
+	} {
+		t.Run(query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/adoptions/history?"+query, nil)
+
+			if _, err := decodeAdoptionHistoryRequest(context.Background(), r); !errors.Is(err, ErrBadRequest) {
+				t.Errorf("got %v, want %v", err, ErrBadRequest)
+			}
+		})
+	}
+}
+
+// historyService answers AdoptionHistory with the stored transactions
+type historyService struct {
+	Service
+	got          HistoryQuery
+	transactions []Adoption
+}
+
+func (s *historyService) AdoptionHistory(_ context.Context, q HistoryQuery) ([]Adoption, error) {
+	s.got = q
+	return s.transactions, nil
+}
+
+func TestAdoptionHistoryEndpointPagination(t *testing.T) {
+	s := &historyService{transactions: []Adoption{{TransactionID: "tx-1"}}}
+	q := HistoryQuery{Limit: 10, Offset: 30}
+
+	res, err := makeAdoptionHistoryEndpoint(s)(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.got != q {
+		t.Errorf("service got %+v, want %+v", s.got, q)
+	}
+
+	h := res.(adoptionHistoryResponse)
+	if h.Limit != 10 || h.Offset != 30 || len(h.Transactions) != 1 {
+		t.Errorf("unexpected response %+v", h)
+	}
+}
@@ -0,0 +1,48 @@
+package payforadoption
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+type txKey struct{}
+
+// txFrom returns the transaction of the unit of work ctx runs in, if any
+func txFrom(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(txKey{}).(*sql.Tx)
+	return tx
+}
+
+// WithinTransaction runs fn as a unit of work, every write the repository does
+// with the ctx passed to fn commits or rolls back together. BEGIN, COMMIT and
+// ROLLBACK get their own subsegments under UnitOfWork.
+func (r *repo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if txFrom(ctx) != nil {
+		return fn(ctx)
+	}
+
+	return xray.Capture(ctx, "UnitOfWork", func(ctx context.Context) error {
+		var tx *sql.Tx
+		err := xray.Capture(ctx, "BEGIN", func(ctx context.Context) error {
+			var err error
+			tx, err = r.db.BeginTx(ctx, nil)
+			return err
+		})
+		if err != nil {
+			return databaseError(err)
+		}
+
+		if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+			xray.Capture(ctx, "ROLLBACK", func(context.Context) error {
+				return tx.Rollback()
+			})
+			return err
+		}
+
+		return databaseError(xray.Capture(ctx, "COMMIT", func(context.Context) error {
+			return tx.Commit()
+		}))
+	})
+}
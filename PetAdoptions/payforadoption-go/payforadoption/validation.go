@@ -0,0 +1,93 @@
+package payforadoption
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	maxPetIDLength  = 16
+	maxUserIDLength = 64
+
+	// recorded for callers that do not send a user yet, PetSite only
+	// posts the pet id and type
+	anonymousUserID = "anonymous"
+)
+
+var (
+	petIDPattern = regexp.MustCompile(`^[0-9]+$`)
+
+	allowedPetTypes = map[string]bool{
+		"bunny":  true,
+		"kitten": true,
+		"puppy":  true,
+	}
+)
+
+// validationError reports every invalid field of a request at once
+func validationError(fields map[string]string) error {
+	return &Error{
+		Code:    CodeValidationFailed,
+		Status:  http.StatusBadRequest,
+		Err:     fmt.Errorf("%d invalid field(s)", len(fields)),
+		Details: fields,
+	}
+}
+
+// validateCompleteAdoption rejects malformed completeadoption requests
+// before they reach the service and the database
+func validateCompleteAdoption(failures metrics.Counter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(completeAdoptionRequest)
+			fields := map[string]string{}
+
+			switch {
+			case req.PetId == "":
+				fields["petId"] = "required"
+			case len(req.PetId) > maxPetIDLength:
+				fields["petId"] = "too_long"
+			case !petIDPattern.MatchString(req.PetId):
+				fields["petId"] = "invalid_format"
+			}
+
+			switch {
+			case req.PetType == "":
+				fields["petType"] = "required"
+			case !allowedPetTypes[req.PetType]:
+				fields["petType"] = "not_allowed"
+			}
+
+			switch {
+			case req.UserId == "":
+				req.UserId = anonymousUserID
+			case len(req.UserId) > maxUserIDLength:
+				fields["userId"] = "too_long"
+			}
+
+			if len(fields) > 0 {
+				for field, reason := range fields {
+					failures.With("field", field, "reason", reason).Add(1)
+				}
+				return nil, validationError(fields)
+			}
+
+			return next(ctx, req)
+		}
+	}
+}
+
+func newValidationFailuresCounter() metrics.Counter {
+	return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "payforadoption",
+		Name:      "validation_failures_total",
+		Help:      "Number of rejected request fields by reason",
+	}, []string{"field", "reason"})
+}
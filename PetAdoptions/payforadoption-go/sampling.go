@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"petadoptions/flags"
+	"petadoptions/payforadoption"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+)
+
+// Samplers selected with OTEL_TRACES_SAMPLER, the ratio comes from
+// OTEL_TRACES_SAMPLER_ARG. The X-Ray SDK always follows the sampling decision
+// of an incoming trace header, so the parentbased variants behave like their
+// root sampler.
+const (
+	samplerAlwaysOn                = "always_on"
+	samplerAlwaysOff               = "always_off"
+	samplerTraceIDRatio            = "traceidratio"
+	samplerParentBasedAlwaysOn     = "parentbased_always_on"
+	samplerParentBasedAlwaysOff    = "parentbased_always_off"
+	samplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+const (
+	errorModeRule     = "errorMode"
+	samplingRulesRule = "samplingRules"
+)
+
+// samplingStrategy returns the strategy for the configured sampler, nil keeps
+// the SDK default which polls the X-Ray sampling rules
+func samplingStrategy(name, arg string) (sampling.Strategy, error) {
+	var rate float64
+
+	switch name {
+	case "":
+		return nil, nil
+	case samplerAlwaysOn, samplerParentBasedAlwaysOn:
+		rate = 1
+	case samplerAlwaysOff, samplerParentBasedAlwaysOff:
+		rate = 0
+	case samplerTraceIDRatio, samplerParentBasedTraceIDRatio:
+		rate = 1
+		if arg != "" {
+			var err error
+			if rate, err = strconv.ParseFloat(strings.TrimSpace(arg), 64); err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sampler ratio %q", arg)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown sampler %q", name)
+	}
+
+	// without a fixed target the reservoir would still sample one request per second
+	rules := fmt.Sprintf(`{"version": 2, "rules": [], "default": {"fixed_target": 0, "rate": %g}}`, rate)
+	return sampling.NewLocalizedStrategyFromJSONBytes([]byte(rules))
+}
+
+// errorModeSampler samples every adoption while error mode is on, so the
+// failures it causes always show up in the traces whatever the sampling rate
+type errorModeSampler struct {
+	flags *flags.Client
+	next  sampling.Strategy
+}
+
+func newErrorModeSampler(f *flags.Client, next sampling.Strategy) (*errorModeSampler, error) {
+	if next == nil {
+		var err error
+		if next, err = sampling.NewCentralizedStrategy(); err != nil {
+			return nil, err
+		}
+	}
+	return &errorModeSampler{flags: f, next: next}, nil
+}
+
+func (s *errorModeSampler) ShouldTrace(r *sampling.Request) *sampling.Decision {
+	if s.inErrorMode(r) {
+		rule := errorModeRule
+		return &sampling.Decision{Sample: true, Rule: &rule}
+	}
+	return s.next.ShouldTrace(r)
+}
+
+func (s *errorModeSampler) inErrorMode(r *sampling.Request) bool {
+	return strings.HasSuffix(r.URL, "/completeadoption") && s.flags.Flags().Get(flags.ErrorMode).Enabled()
+}
+
+// rulesSampler applies the sampling rules of the config, which the watcher
+// refreshes from parameter store. Without rules it behaves like the error mode
+// sampler, and the routes without a ratio keep the sampler of the environment.
+type rulesSampler struct {
+	store     *payforadoption.ConfigStore
+	errorMode *errorModeSampler
+}
+
+func newRulesSampler(store *payforadoption.ConfigStore, f *flags.Client, next sampling.Strategy) (sampling.Strategy, error) {
+	errorMode, err := newErrorModeSampler(f, next)
+	if err != nil {
+		return nil, err
+	}
+	return &rulesSampler{store: store, errorMode: errorMode}, nil
+}
+
+func (s *rulesSampler) ShouldTrace(r *sampling.Request) *sampling.Decision {
+	rules := s.store.Load().SamplingRules
+	if rules == nil {
+		return s.errorMode.ShouldTrace(r)
+	}
+
+	if rules.SampleAllInErrorMode && s.errorMode.inErrorMode(r) {
+		rule := errorModeRule
+		return &sampling.Decision{Sample: true, Rule: &rule}
+	}
+
+	ratio, ok := rules.Ratio(r.URL)
+	if !ok {
+		return s.errorMode.next.ShouldTrace(r)
+	}
+	rule := samplingRulesRule
+	return &sampling.Decision{Sample: rand.Float64() < ratio, Rule: &rule}
+}
@@ -0,0 +1,126 @@
+// Package servertls configures the optional HTTPS listener of a service, so
+// the traffic stays encrypted past the load balancer. The certificate comes
+// from PEM files, e.g. ACM exported material mounted from a secret, or from
+// PEM values injected in the environment. Certificates read from files are
+// reloaded when the files change, new connections then use the rotated one.
+package servertls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// reloadInterval bounds how often the certificate files are checked
+const reloadInterval = time.Minute
+
+// Options locate the certificate, files take precedence over PEM values
+type Options struct {
+	CertFile string
+	KeyFile  string
+	CertPEM  string
+	KeyPEM   string
+	// ClientCAFile enables mutual TLS, clients must present a certificate
+	// signed by one of these CAs
+	ClientCAFile string
+}
+
+// Enabled reports whether a certificate is configured
+func (o Options) Enabled() bool {
+	return (o.CertFile != "" && o.KeyFile != "") || (o.CertPEM != "" && o.KeyPEM != "")
+}
+
+// Config returns the TLS configuration of the listener. HTTP/2 is negotiated
+// by http.Server when it serves TLS.
+func Config(o Options, logger log.Logger) (*tls.Config, error) {
+	if !o.Enabled() {
+		return nil, errors.New("servertls: no certificate configured")
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if o.CertFile != "" {
+		r := &reloader{certFile: o.CertFile, keyFile: o.KeyFile, logger: log.With(logger, "component", "servertls")}
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = r.getCertificate
+	} else {
+		cert, err := tls.X509KeyPair([]byte(o.CertPEM), []byte(o.KeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("servertls: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("servertls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("servertls: no certificate in %s", o.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// reloader serves the key pair of the files, reloaded once they are modified
+type reloader struct {
+	certFile, keyFile string
+	logger            log.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (r *reloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("servertls: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("servertls: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	return nil
+}
+
+func (r *reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < reloadInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+
+	if info, err := os.Stat(r.certFile); err != nil || !info.ModTime().After(r.modTime) {
+		return r.cert, nil
+	}
+
+	// a half written pair keeps the previous certificate until the next check
+	if err := r.load(); err != nil {
+		level.Warn(r.logger).Log("msg", "certificate reload failed", "err", err)
+		return r.cert, nil
+	}
+	level.Info(r.logger).Log("msg", "certificate reloaded", "file", r.certFile)
+	return r.cert, nil
+}
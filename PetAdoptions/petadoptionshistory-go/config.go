@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/spf13/viper"
+)
+
+// config is injected as environment variable
+type Config struct {
+	QueueURL     string
+	HistoryTable string
+	AWSRegion    string
+}
+
+func fetchConfig() (Config, error) {
+
+	// fetch from env
+	viper.SetEnvPrefix("app")
+	viper.AutomaticEnv() // Bind automatically all env vars that have the same prefix
+
+	cfg := Config{
+		QueueURL:     viper.GetString("QUEUE_URL"),
+		HistoryTable: viper.GetString("HISTORY_TABLE_NAME"),
+		AWSRegion:    os.Getenv("AWS_REGION"),
+	}
+
+	if cfg.QueueURL == "" || cfg.HistoryTable == "" {
+		return fetchConfigFromParameterStore(cfg)
+	}
+
+	return cfg, nil
+}
+
+func fetchConfigFromParameterStore(cfg Config) (Config, error) {
+	svc := ssm.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+
+	res, err := svc.GetParametersWithContext(context.Background(), &ssm.GetParametersInput{
+		Names: []*string{
+			aws.String("/petstore/queueurl"),
+			aws.String("/petstore/historytablename"),
+		},
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, p := range res.Parameters {
+		switch aws.StringValue(p.Name) {
+		case "/petstore/queueurl":
+			cfg.QueueURL = aws.StringValue(p.Value)
+		case "/petstore/historytablename":
+			cfg.HistoryTable = aws.StringValue(p.Value)
+		}
+	}
+
+	return cfg, nil
+}
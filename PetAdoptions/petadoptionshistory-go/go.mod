@@ -0,0 +1,28 @@
+module petadoptions
+
+go 1.17
+
+require (
+	github.com/aws-samples/one-observability-demo/PetAdoptions/observability v0.0.0
+	github.com/aws/aws-sdk-go v1.37.16
+	github.com/go-kit/kit v0.10.0
+	github.com/prometheus/client_golang v1.14.0
+	github.com/pyroscope-io/client v0.2.3 // indirect
+	github.com/spf13/viper v1.7.1
+	go.opentelemetry.io/contrib/detectors/aws/ec2 v0.17.0
+	go.opentelemetry.io/contrib/detectors/aws/ecs v0.17.0
+	go.opentelemetry.io/contrib/detectors/aws/eks v0.17.0
+	go.opentelemetry.io/contrib/propagators/aws v0.17.0
+	go.opentelemetry.io/otel v0.17.0
+	go.opentelemetry.io/otel/exporters/otlp v0.17.0
+	go.opentelemetry.io/otel/exporters/stdout v0.17.0
+	go.opentelemetry.io/otel/metric v0.17.0
+	go.opentelemetry.io/otel/sdk v0.17.0
+	go.opentelemetry.io/otel/sdk/metric v0.17.0
+	go.opentelemetry.io/otel/trace v0.17.0
+	google.golang.org/grpc v1.35.0
+)
+
+// the shared module is used from the repository, the images are built with
+// the PetAdoptions directory as context
+replace github.com/aws-samples/one-observability-demo/PetAdoptions/observability => ../observability
@@ -0,0 +1,122 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxMessages = 10
+	// long polling, the maximum SQS allows
+	waitTimeSeconds = 20
+	// pause after a failed receive so a missing permission does not spin
+	receiveBackoff = 5 * time.Second
+)
+
+// Handler processes one adoption message
+type Handler func(ctx context.Context, m *sqs.Message) error
+
+// Consumer long-polls the adoptions queue. A message is deleted once handled,
+// failed ones become visible again after the queue visibility timeout.
+type Consumer struct {
+	// unix time of the last successful receive, read by the health check
+	lastPoll int64
+	svc      *sqs.SQS
+	queueURL string
+	handle   Handler
+	logger   log.Logger
+}
+
+func NewConsumer(svc *sqs.SQS, queueURL string, handle Handler, logger log.Logger) *Consumer {
+	return &Consumer{
+		// healthy while the first long poll is pending
+		lastPoll: time.Now().Unix(),
+		svc:      svc,
+		queueURL: queueURL,
+		handle:   handle,
+		logger:   log.With(logger, "queue", queueURL),
+	}
+}
+
+// Run receives messages until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		res, err := c.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(c.queueURL),
+			MaxNumberOfMessages:   aws.Int64(maxMessages),
+			WaitTimeSeconds:       aws.Int64(waitTimeSeconds),
+			AttributeNames:        []*string{aws.String(awsTraceHeader), aws.String(sentTimestamp)},
+			MessageAttributeNames: []*string{aws.String(traceHeaderAttribute), aws.String(userIDAttribute)},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			level.Error(c.logger).Log("method", "ReceiveMessage", "err", err)
+			time.Sleep(receiveBackoff)
+			continue
+		}
+		atomic.StoreInt64(&c.lastPoll, time.Now().Unix())
+
+		for _, m := range res.Messages {
+			c.process(ctx, m)
+		}
+	}
+}
+
+func (c *Consumer) process(ctx context.Context, m *sqs.Message) {
+	logger := log.With(c.logger, "messageId", aws.StringValue(m.MessageId))
+
+	opts := []trace.SpanOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("AmazonSQS"),
+			semconv.MessagingDestinationKey.String(c.queueURL),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingMessageIDKey.String(aws.StringValue(m.MessageId)),
+		),
+	}
+	if producer := producerSpanContext(ctx, m); producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{
+			SpanContext: producer,
+			Attributes:  []label.KeyValue{label.String("link", "producer")},
+		}))
+	}
+
+	ctx, span := otel.Tracer("petadoptionshistory").Start(ctx, "adoptions process", opts...)
+	defer span.End()
+
+	if err := c.handle(ctx, m); err != nil {
+		span.RecordError(err)
+		level.Error(logger).Log("method", "process", "err", err)
+		if !errors.Is(err, ErrInvalidMessage) {
+			return
+		}
+	}
+
+	_, err := c.svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueURL),
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	if err != nil {
+		span.RecordError(err)
+		level.Error(logger).Log("method", "DeleteMessage", "err", err)
+	}
+}
+
+// Healthy reports whether the queue was polled recently
+func (c *Consumer) Healthy() bool {
+	last := atomic.LoadInt64(&c.lastPoll)
+	return time.Since(time.Unix(last, 0)) < 3*waitTimeSeconds*time.Second
+}
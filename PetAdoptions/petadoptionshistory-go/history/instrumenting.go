@@ -0,0 +1,67 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewInstrumenting counts the processed messages by result and observes
+// the processing time, with the trace id as exemplar
+func NewInstrumenting(next Handler) Handler {
+	messages := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "petadoptionshistory",
+		Name:      "messages_total",
+		Help:      "Number of adoption messages processed",
+	}, []string{"result"})
+	// native client histogram, go-kit does not support exemplars
+	latency := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+		Namespace: "petadoptionshistory",
+		Name:      "processing_latency_seconds",
+		Help:      "Message processing durations in seconds",
+	}, []string{"result"})
+	stdprometheus.MustRegister(latency)
+
+	return func(ctx context.Context, m *sqs.Message) (err error) {
+		defer func(begin time.Time) {
+			result := resultOf(err)
+			messages.With("result", result).Add(1)
+			observe(ctx, latency.WithLabelValues(result), time.Since(begin).Seconds())
+		}(time.Now())
+
+		return next(ctx, m)
+	}
+}
+
+func resultOf(err error) string {
+	switch {
+	case err == nil:
+		return "processed"
+	case errors.Is(err, ErrInvalidMessage):
+		return "invalid"
+	}
+	return "failed"
+}
+
+func observe(ctx context.Context, obs stdprometheus.Observer, took float64) {
+	eo, ok := obs.(stdprometheus.ExemplarObserver)
+	if spanCtx := trace.SpanContextFromContext(ctx); ok && spanCtx.IsValid() {
+		eo.ObserveWithExemplar(took, stdprometheus.Labels{
+			"traceID": xrayTraceID(spanCtx.TraceID),
+		})
+		return
+	}
+	obs.Observe(took)
+}
+
+// xrayTraceID renders an OTel trace id in the X-Ray 1-{epoch}-{random} format
+func xrayTraceID(id trace.TraceID) string {
+	h := id.String()
+	return fmt.Sprintf("1-%s-%s", h[:8], h[8:])
+}
@@ -0,0 +1,47 @@
+package history
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// traceHeaderAttribute is the message attribute petsite sets with the
+	// X-Ray header of the adoption request
+	traceHeaderAttribute = "X-Amzn-Trace-Id"
+	// awsTraceHeader is the system attribute SQS fills in for X-Ray
+	// instrumented senders
+	awsTraceHeader = "AWSTraceHeader"
+)
+
+// messageCarrier exposes the trace header of a message to the propagators
+type messageCarrier struct {
+	m *sqs.Message
+}
+
+func (c messageCarrier) Get(key string) string {
+	if key != traceHeaderAttribute {
+		return ""
+	}
+	if v, ok := c.m.MessageAttributes[traceHeaderAttribute]; ok {
+		return aws.StringValue(v.StringValue)
+	}
+	return aws.StringValue(c.m.Attributes[awsTraceHeader])
+}
+
+func (c messageCarrier) Set(key, value string) {}
+
+func (c messageCarrier) Keys() []string {
+	return []string{traceHeaderAttribute}
+}
+
+// producerSpanContext returns the span that sent the message. The producer
+// trace is not continued, each message gets its own trace linked to it.
+func producerSpanContext(ctx context.Context, m *sqs.Message) trace.SpanContext {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, messageCarrier{m})
+	return trace.RemoteSpanContextFromContext(ctx)
+}
@@ -0,0 +1,74 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ErrInvalidMessage marks messages that will never be processed, they are
+// dropped instead of being retried
+var ErrInvalidMessage = errors.New("invalid adoption message")
+
+const (
+	sentTimestamp = "SentTimestamp"
+	// userIDAttribute is the message attribute petsite sets with the user
+	// completing the adoption, when it knows one
+	userIDAttribute = "userId"
+	// adoptionsFeed is the partition of every record in the time index of
+	// the table
+	adoptionsFeed = "adoptions"
+)
+
+// Record is one adoption in the history table. Feed, AdoptedNs and UserID are
+// the keys of the indexes petlistadoptions reads, records without a user are
+// left out of the user index.
+type Record struct {
+	PetID     string    `dynamodbav:"petid"`
+	AdoptedAt time.Time `dynamodbav:"adoptedat"`
+	PetType   string    `dynamodbav:"pettype"`
+	MessageID string    `dynamodbav:"messageid"`
+	Feed      string    `dynamodbav:"feed"`
+	AdoptedNs int64     `dynamodbav:"adoptedns"`
+	UserID    string    `dynamodbav:"userid,omitempty"`
+}
+
+// recordFrom parses the petId-petType body sent by petsite, the adoption time
+// is the time the message was sent
+func recordFrom(m *sqs.Message) (Record, error) {
+	var body string
+	if err := json.Unmarshal([]byte(aws.StringValue(m.Body)), &body); err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	parts := strings.SplitN(body, "-", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Record{}, fmt.Errorf("%w: %q", ErrInvalidMessage, body)
+	}
+
+	adoptedAt := time.Now().UTC()
+	if ms, err := strconv.ParseInt(aws.StringValue(m.Attributes[sentTimestamp]), 10, 64); err == nil {
+		adoptedAt = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	}
+
+	var userID string
+	if v, ok := m.MessageAttributes[userIDAttribute]; ok {
+		userID = aws.StringValue(v.StringValue)
+	}
+
+	return Record{
+		PetID:     parts[0],
+		PetType:   parts[1],
+		AdoptedAt: adoptedAt,
+		MessageID: aws.StringValue(m.MessageId),
+		Feed:      adoptionsFeed,
+		AdoptedNs: adoptedAt.UnixNano(),
+		UserID:    userID,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package history
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Store persists the adoption history
+type Store interface {
+	Put(ctx context.Context, r Record) error
+}
+
+type dynamoDBStore struct {
+	svc   *dynamodb.DynamoDB
+	table string
+}
+
+func NewDynamoDBStore(svc *dynamodb.DynamoDB, table string) Store {
+	return &dynamoDBStore{svc: svc, table: table}
+}
+
+func (s *dynamoDBStore) Put(ctx context.Context, r Record) error {
+	ctx, span := otel.Tracer("petadoptionshistory").Start(ctx, "DynamoDB.PutItem",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemKey.String("dynamodb"),
+			semconv.DBOperationKey.String("PutItem"),
+			label.String("aws.dynamodb.table_names", s.table),
+		),
+	)
+	defer span.End()
+
+	item, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	// redelivered messages overwrite their own record
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// StoreHandler writes the adoption carried by each message to the store
+func StoreHandler(s Store) Handler {
+	return func(ctx context.Context, m *sqs.Message) error {
+		r, err := recordFrom(m)
+		if err != nil {
+			return err
+		}
+		return s.Put(ctx, r)
+	}
+}
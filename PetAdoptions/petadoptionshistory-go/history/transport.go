@@ -0,0 +1,51 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MakeHTTPHandler serves the health check, unhealthy when the queue could not
+// be polled for a while. The checks are recorded in the RED metrics of requests
+// like the requests of the other services.
+func MakeHTTPHandler(c *Consumer, requests *httpmetrics.Metrics) http.Handler {
+	r := http.NewServeMux()
+
+	r.Handle("/health/status", requests.Handler("/health/status", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		status := "alive"
+		if !c.Healthy() {
+			status = "unavailable"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": status})
+	})))
+
+	return r
+}
+
+// MakeAdminHandler serves the metrics and profiling endpoints, it is bound to
+// its own port so they are neither public nor behind the API middlewares
+func MakeAdminHandler() http.Handler {
+	r := http.NewServeMux()
+
+	// exemplars are only exposed in the OpenMetrics format
+	r.Handle("/metrics", promhttp.HandlerFor(
+		stdprometheus.DefaultGatherer,
+		promhttp.HandlerOpts{EnableOpenMetrics: true},
+	))
+
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return r
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"petadoptions/canary"
+	"petadoptions/history"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+func main() {
+	var (
+		httpAddr       = flag.String("http.addr", ":80", "HTTP Port binding")
+		adminAddr      = flag.String("admin.addr", ":9090", "Metrics and pprof port binding")
+		canaryInterval = flag.Duration("canary.interval", 0, "Interval of the synthetic requests to the service itself, 0 to disable")
+		canaryPaths    = flag.String("canary.paths", "/health/status", "Comma separated paths requested by the canary")
+	)
+
+	flag.Parse()
+
+	// GC, heap and scheduler metrics on /metrics
+	observability.RegisterRuntimeMetrics()
+
+	var logger log.Logger
+	{
+		logger = log.NewJSONLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	{
+		shutdown, err := observability.InitTracerProvider(context.Background(), observability.Config{
+			ServiceName: "petadoptionshistory",
+		})
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		defer shutdown(context.Background())
+	}
+
+	var cfg Config
+	{
+		var err error
+		cfg, err = fetchConfig()
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var consumer *history.Consumer
+	{
+		sess := session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)})
+
+		var h history.Handler
+		h = history.StoreHandler(history.NewDynamoDBStore(dynamodb.New(sess), cfg.HistoryTable))
+		h = history.NewInstrumenting(h)
+
+		consumer = history.NewConsumer(sqs.New(sess), cfg.QueueURL, h, logger)
+	}
+
+	errs := make(chan error)
+	go func() {
+		c := make(chan os.Signal)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		errs <- fmt.Errorf("%s", <-c)
+	}()
+
+	go func() {
+		logger.Log("consumer", "SQS", "queue", cfg.QueueURL)
+		consumer.Run(ctx)
+	}()
+
+	go func() {
+		logger.Log("transport", "HTTP", "addr", *httpAddr)
+		requests := httpmetrics.New("petadoptionshistory", httpmetrics.Options{})
+		errs <- http.ListenAndServe(*httpAddr, history.MakeHTTPHandler(consumer, requests))
+	}()
+
+	go canary.Run(ctx, "petadoptionshistory", canary.Options{
+		Addr:     *httpAddr,
+		Paths:    canary.ParsePaths(*canaryPaths),
+		Interval: *canaryInterval,
+	}, logger)
+
+	go func() {
+		logger.Log("transport", "admin", "addr", *adminAddr)
+		errs <- http.ListenAndServe(*adminAddr, history.MakeAdminHandler())
+	}()
+
+	logger.Log("exit", <-errs)
+}
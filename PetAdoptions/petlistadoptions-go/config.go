@@ -6,12 +6,27 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/paramcache"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/slo"
+	"petadoptions/dbsecret"
+	"petadoptions/httpclient"
+	"petadoptions/logging"
+	"petadoptions/petlistadoptions"
+	"petadoptions/servertls"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/spf13/viper"
 )
 
@@ -22,8 +37,87 @@ type dbConfig struct {
 
 // config is injected as environment variable
 type Config struct {
-	PetSearchURL string
-	RDSSecretArn string
+	PetSearchURL         string
+	RDSSecretArn         string
+	RDSReaderEndpoint    string
+	DBAuthMode           string
+	DBIAMUser            string
+	DBSSLMode            string
+	DBSSLRootCert        string
+	DBConnectTimeout     int
+	DBProxyEndpoint      string
+	LogLevel             string
+	PetCache             string
+	PetCacheSize         int
+	PetCacheTTL          time.Duration
+	PetCacheRedisAddr    string
+	PetCacheRedisTLS     bool
+	PetSearchConcurrency int
+	PetSearchTimeout     time.Duration
+	RequestTimeout       time.Duration
+	PetSearchRetries     int
+	BreakerFailures      int
+	BreakerTimeout       time.Duration
+	LatencyBuckets       []float64
+	NativeHistograms     bool
+	FeedPollInterval     time.Duration
+	// adoption events invalidating the pet search cache, disabled when empty
+	PetCacheInvalidationQueueURL string
+	// rds or dynamodb, the adoption history table without Aurora
+	AdoptionsBackend string
+	HistoryTableName string
+	// share of the successful requests written to the access log
+	AccessLogSampleRate float64
+	// pooling and timeouts of the client calling pet search
+	HTTPClient httpclient.Options
+	// nil keeps the sampler of the environment
+	SamplingRules *observability.SamplingRules
+}
+
+// Adoption list backends selected with ADOPTIONS_BACKEND
+const (
+	backendRDS      = "rds"
+	backendDynamoDB = "dynamodb"
+)
+
+// adoptionsBackend is read before the configuration, the tracer resource
+// records it
+func adoptionsBackend() string {
+	if b := os.Getenv("APP_ADOPTIONS_BACKEND"); b != "" {
+		return b
+	}
+	return backendRDS
+}
+
+// UsesDynamoDB reports whether the adoptions are read from the history table
+func (c Config) UsesDynamoDB() bool {
+	return c.AdoptionsBackend == backendDynamoDB
+}
+
+// UsesRDSProxy reports whether connections go through an RDS Proxy endpoint
+func (c Config) UsesRDSProxy() bool {
+	return c.DBProxyEndpoint != ""
+}
+
+// UsesIAMAuth reports whether RDS connections authenticate with IAM tokens
+// instead of the secret password
+func (c Config) UsesIAMAuth() bool {
+	return c.DBAuthMode == "iam"
+}
+
+// configCache shares the parameters between the tasks, nil
+// unless APP_CONFIG_CACHE_REDIS_ADDR is set
+var configCache *paramcache.Cache
+
+func newConfigCache(logger log.Logger) *paramcache.Cache {
+	viper.SetEnvPrefix("app")
+	viper.AutomaticEnv()
+
+	return paramcache.New("petlistadoptions", paramcache.Options{
+		Addr: viper.GetString("CONFIG_CACHE_REDIS_ADDR"),
+		TLS:  viper.GetBool("CONFIG_CACHE_REDIS_TLS"),
+		TTL:  viper.GetDuration("CONFIG_CACHE_TTL"),
+	}, logger)
 }
 
 func fetchConfig() (Config, error) {
@@ -33,12 +127,143 @@ func fetchConfig() (Config, error) {
 	viper.AutomaticEnv() // Bind automatically all env vars that have the same prefix
 
 	cfg := Config{
-		PetSearchURL: viper.GetString("PET_SEARCH_URL"),
-		RDSSecretArn: viper.GetString("RDS_SECRET_ARN"),
+		PetSearchURL:         viper.GetString("PET_SEARCH_URL"),
+		RDSSecretArn:         viper.GetString("RDS_SECRET_ARN"),
+		RDSReaderEndpoint:    viper.GetString("RDS_READER_ENDPOINT"),
+		DBAuthMode:           viper.GetString("DB_AUTH_MODE"),
+		DBIAMUser:            viper.GetString("DB_IAM_USER"),
+		DBSSLMode:            viper.GetString("DB_SSLMODE"),
+		DBSSLRootCert:        viper.GetString("DB_SSLROOTCERT"),
+		DBConnectTimeout:     viper.GetInt("DB_CONNECT_TIMEOUT"),
+		DBProxyEndpoint:      viper.GetString("DB_PROXY_ENDPOINT"),
+		LogLevel:             os.Getenv("LOG_LEVEL"),
+		PetCache:             viper.GetString("PET_CACHE"),
+		PetCacheSize:         viper.GetInt("PET_CACHE_SIZE"),
+		PetCacheTTL:          viper.GetDuration("PET_CACHE_TTL"),
+		PetCacheRedisAddr:    viper.GetString("PET_CACHE_REDIS_ADDR"),
+		PetCacheRedisTLS:     viper.GetBool("PET_CACHE_REDIS_TLS"),
+		PetSearchConcurrency: viper.GetInt("PET_SEARCH_CONCURRENCY"),
+		PetSearchTimeout:     viper.GetDuration("PET_SEARCH_TIMEOUT"),
+		RequestTimeout:       viper.GetDuration("REQUEST_TIMEOUT"),
+		PetSearchRetries:     2,
+		BreakerFailures:      viper.GetInt("PET_SEARCH_BREAKER_FAILURES"),
+		BreakerTimeout:       viper.GetDuration("PET_SEARCH_BREAKER_TIMEOUT"),
+		NativeHistograms:     viper.GetBool("NATIVE_HISTOGRAMS"),
+		FeedPollInterval:     viper.GetDuration("FEED_POLL_INTERVAL"),
+
+		PetCacheInvalidationQueueURL: viper.GetString("PET_CACHE_INVALIDATION_QUEUE_URL"),
+		AdoptionsBackend:             adoptionsBackend(),
+		HistoryTableName:             viper.GetString("HISTORY_TABLE_NAME"),
+		AccessLogSampleRate:          1,
+		HTTPClient: httpclient.Options{
+			Timeout:             viper.GetDuration("HTTP_CLIENT_TIMEOUT"),
+			MaxIdleConnsPerHost: viper.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST"),
+			MaxConnsPerHost:     viper.GetInt("HTTP_MAX_CONNS_PER_HOST"),
+		},
 	}
 
-	if cfg.PetSearchURL == "" || cfg.RDSSecretArn == "" {
-		return fetchConfigFromParameterStore(os.Getenv("AWS_REGION"))
+	if viper.IsSet("ACCESS_LOG_SAMPLE_RATE") {
+		cfg.AccessLogSampleRate = viper.GetFloat64("ACCESS_LOG_SAMPLE_RATE")
+		if cfg.AccessLogSampleRate < 0 || cfg.AccessLogSampleRate > 1 {
+			return cfg, fmt.Errorf("APP_ACCESS_LOG_SAMPLE_RATE must be between 0 and 1, got %v", cfg.AccessLogSampleRate)
+		}
+	}
+
+	if cfg.AdoptionsBackend != backendRDS && cfg.AdoptionsBackend != backendDynamoDB {
+		return cfg, fmt.Errorf("unknown adoptions backend %q", cfg.AdoptionsBackend)
+	}
+
+	buckets, err := httpmetrics.ParseBuckets(viper.GetString("LATENCY_BUCKETS"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LatencyBuckets = buckets
+
+	if cfg.SamplingRules, err = observability.ParseSamplingRules(viper.GetString("SAMPLING_RULES")); err != nil {
+		return cfg, err
+	}
+
+	if viper.IsSet("PET_SEARCH_RETRIES") {
+		cfg.PetSearchRetries = viper.GetInt("PET_SEARCH_RETRIES")
+	}
+	if cfg.BreakerFailures <= 0 {
+		cfg.BreakerFailures = 5
+	}
+	if cfg.BreakerTimeout <= 0 {
+		cfg.BreakerTimeout = 30 * time.Second
+	}
+
+	if cfg.PetCache == "" {
+		cfg.PetCache = "memory"
+	}
+	if cfg.PetCacheSize <= 0 {
+		cfg.PetCacheSize = 1000
+	}
+	if cfg.PetCacheTTL <= 0 {
+		cfg.PetCacheTTL = 5 * time.Minute
+	}
+	if cfg.PetSearchConcurrency <= 0 {
+		cfg.PetSearchConcurrency = 8
+	}
+	if cfg.PetSearchTimeout <= 0 {
+		cfg.PetSearchTimeout = 2 * time.Second
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = 10 * time.Second
+	}
+	if cfg.FeedPollInterval <= 0 {
+		cfg.FeedPollInterval = 2 * time.Second
+	}
+
+	incomplete := cfg.PetSearchURL == "" || cfg.RDSSecretArn == ""
+	if cfg.UsesDynamoDB() {
+		incomplete = cfg.PetSearchURL == "" || cfg.HistoryTableName == ""
+	}
+
+	if incomplete {
+		ssmCfg, err := fetchConfigFromParameterStore(os.Getenv("AWS_REGION"))
+		ssmCfg.DBAuthMode = cfg.DBAuthMode
+		ssmCfg.DBIAMUser = cfg.DBIAMUser
+		ssmCfg.DBSSLMode = cfg.DBSSLMode
+		ssmCfg.DBSSLRootCert = cfg.DBSSLRootCert
+		ssmCfg.DBConnectTimeout = cfg.DBConnectTimeout
+		ssmCfg.DBProxyEndpoint = cfg.DBProxyEndpoint
+		ssmCfg.PetCache = cfg.PetCache
+		ssmCfg.PetCacheSize = cfg.PetCacheSize
+		ssmCfg.PetCacheTTL = cfg.PetCacheTTL
+		ssmCfg.PetCacheRedisAddr = cfg.PetCacheRedisAddr
+		ssmCfg.PetCacheRedisTLS = cfg.PetCacheRedisTLS
+		ssmCfg.PetSearchConcurrency = cfg.PetSearchConcurrency
+		ssmCfg.PetSearchTimeout = cfg.PetSearchTimeout
+		ssmCfg.RequestTimeout = cfg.RequestTimeout
+		ssmCfg.PetSearchRetries = cfg.PetSearchRetries
+		ssmCfg.BreakerFailures = cfg.BreakerFailures
+		ssmCfg.BreakerTimeout = cfg.BreakerTimeout
+		ssmCfg.NativeHistograms = cfg.NativeHistograms
+		ssmCfg.FeedPollInterval = cfg.FeedPollInterval
+		ssmCfg.AdoptionsBackend = cfg.AdoptionsBackend
+		ssmCfg.AccessLogSampleRate = cfg.AccessLogSampleRate
+		ssmCfg.HTTPClient = cfg.HTTPClient
+		if cfg.HistoryTableName != "" {
+			ssmCfg.HistoryTableName = cfg.HistoryTableName
+		}
+		// a queue set on the task wins over the shared parameter
+		if cfg.PetCacheInvalidationQueueURL != "" {
+			ssmCfg.PetCacheInvalidationQueueURL = cfg.PetCacheInvalidationQueueURL
+		}
+		// buckets set on the task win over the shared parameter
+		if len(cfg.LatencyBuckets) > 0 {
+			ssmCfg.LatencyBuckets = cfg.LatencyBuckets
+		}
+		// and so do sampling rules
+		if cfg.SamplingRules != nil {
+			ssmCfg.SamplingRules = cfg.SamplingRules
+		}
+		// a level set on the task wins over the shared parameter
+		if cfg.LogLevel != "" {
+			ssmCfg.LogLevel = cfg.LogLevel
+		}
+		return ssmCfg, err
 	}
 
 	return cfg, nil
@@ -50,11 +275,15 @@ func fetchConfigFromParameterStore(region string) (Config, error) {
 	ctx, seg := xray.BeginSegment(context.Background(), "petlistadoptions")
 	defer seg.Close(nil)
 
-	res, err := svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{
-		Names: []*string{
-			aws.String("/petstore/rdssecretarn"),
-			aws.String("/petstore/searchapiurl"),
-		},
+	params, err := configCache.GetParameters(ctx, svc, []string{
+		"/petstore/rdssecretarn",
+		"/petstore/searchapiurl",
+		"/petstore/rdsreaderendpoint",
+		"/petstore/loglevel",
+		"/petstore/latencybuckets",
+		"/petstore/petcacheinvalidationqueueurl",
+		"/petstore/historytablename",
+		"/petstore/samplingrules",
 	})
 
 	cfg := Config{}
@@ -63,23 +292,80 @@ func fetchConfigFromParameterStore(region string) (Config, error) {
 		return cfg, err
 	}
 
-	for _, p := range res.Parameters {
-		if aws.StringValue(p.Name) == "/petstore/rdssecretarn" {
-			cfg.RDSSecretArn = aws.StringValue(p.Value)
-		} else if aws.StringValue(p.Name) == "/petstore/searchapiurl" {
-			cfg.PetSearchURL = aws.StringValue(p.Value)
+	for name, value := range params {
+		if name == "/petstore/rdssecretarn" {
+			cfg.RDSSecretArn = value
+		} else if name == "/petstore/searchapiurl" {
+			cfg.PetSearchURL = value
+		} else if name == "/petstore/rdsreaderendpoint" {
+			cfg.RDSReaderEndpoint = value
+		} else if name == "/petstore/loglevel" {
+			cfg.LogLevel = value
+		} else if name == "/petstore/latencybuckets" {
+			if cfg.LatencyBuckets, err = httpmetrics.ParseBuckets(value); err != nil {
+				return cfg, err
+			}
+		} else if name == "/petstore/petcacheinvalidationqueueurl" {
+			cfg.PetCacheInvalidationQueueURL = value
+		} else if name == "/petstore/historytablename" {
+			cfg.HistoryTableName = value
+		} else if name == "/petstore/samplingrules" {
+			if cfg.SamplingRules, err = observability.ParseSamplingRules(value); err != nil {
+				return cfg, err
+			}
 		}
 	}
 
 	return cfg, err
 }
 
+// watchSamplingRules polls the /petstore/samplingrules parameter every
+// interval, so the facilitators of a workshop can change the sampling live.
+// It reads SSM directly like the flags poller, a change would otherwise wait
+// for the TTL of the config cache. Invalid rules are reported and the previous
+// ones kept.
+func watchSamplingRules(ctx context.Context, sampler *observability.RuleSampler, interval time.Duration, logger log.Logger) {
+	logger = log.With(logger, "component", "samplingrules")
+
+	svc := ssm.New(session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))}))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		// a missing parameter restores the sampler of the environment
+		res, err := svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{
+			Names: []*string{aws.String("/petstore/samplingrules")},
+		})
+		if err != nil {
+			level.Error(logger).Log("err", err)
+			continue
+		}
+
+		var value string
+		for _, p := range res.Parameters {
+			value = aws.StringValue(p.Value)
+		}
+
+		rules, err := observability.ParseSamplingRules(value)
+		if err != nil {
+			level.Error(logger).Log("err", err)
+			continue
+		}
+		sampler.Update(rules)
+	}
+}
+
 func getSecretValue(secretID, region string) (string, error) {
 
 	svc := secretsmanager.New(session.New(&aws.Config{Region: aws.String(region)}))
 	xray.AWS(svc.Client)
 	ctx, seg := xray.BeginSegment(context.Background(), "petlistadoptions")
 
+	// the secret is not shared through the cache, Redis would hold it in clear
 	res, err := svc.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
 		SecretId: aws.String(secretID),
 	})
@@ -92,9 +378,12 @@ func getSecretValue(secretID, region string) (string, error) {
 	return aws.StringValue(res.SecretString), nil
 }
 
-// Call aws secrets manager and return parsed sql server query str
-func getRDSConnectionString(secretid string, withPassword bool) (string, error) {
-	jsonstr, err := getSecretValue(secretid, os.Getenv("AWS_REGION"))
+// Call aws secrets manager and return parsed sql server query str. With IAM
+// authentication the secret only locates the database, the password is a token.
+// A non empty host replaces the one from the secret, e.g. the reader endpoint.
+func getRDSConnectionString(cfg Config, host string, withPassword bool) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	jsonstr, err := getSecretValue(cfg.RDSSecretArn, region)
 	if err != nil {
 		return "", err
 	}
@@ -105,6 +394,14 @@ func getRDSConnectionString(secretid string, withPassword bool) (string, error)
 		return "", err
 	}
 
+	// the proxy accepts the same credentials as the cluster it fronts
+	if cfg.UsesRDSProxy() {
+		c.Host = cfg.DBProxyEndpoint
+	}
+	if host != "" {
+		c.Host = host
+	}
+
 	query := url.Values{}
 	// database should be in config
 	query.Set("database", "adoptions")
@@ -127,5 +424,92 @@ func getRDSConnectionString(secretid string, withPassword bool) (string, error)
 		}
 	}
 
+	u.RawQuery = connectionParams(cfg).Encode()
+
+	if withPassword && cfg.UsesIAMAuth() {
+		user := c.Username
+		if cfg.DBIAMUser != "" {
+			user = cfg.DBIAMUser
+		}
+
+		token, err := dbsecret.IAMAuthToken(c.Host, c.Port, user, region)
+		if err != nil {
+			return "", err
+		}
+
+		u.User = url.UserPassword(user, token)
+	}
+
 	return u.String(), nil
 }
+
+// connectionParams sets the TLS and timeout options, lib/pq defaults to
+// sslmode=require when none is configured
+func connectionParams(cfg Config) url.Values {
+	params := url.Values{}
+
+	sslmode := cfg.DBSSLMode
+	// IAM authentication is only accepted over TLS
+	if cfg.UsesIAMAuth() && (sslmode == "" || sslmode == "disable" || sslmode == "allow" || sslmode == "prefer") {
+		sslmode = "require"
+	}
+	if sslmode != "" {
+		params.Set("sslmode", sslmode)
+	}
+	if cfg.DBSSLRootCert != "" {
+		params.Set("sslrootcert", cfg.DBSSLRootCert)
+	}
+	if cfg.DBConnectTimeout > 0 {
+		params.Set("connect_timeout", strconv.Itoa(cfg.DBConnectTimeout))
+	}
+	// send parameters inline instead of preparing statements, which would pin
+	// the proxy connection to the session
+	if cfg.UsesRDSProxy() {
+		params.Set("binary_parameters", "yes")
+	}
+
+	return params
+}
+
+// newSLOTracker tracks the endpoints against the objectives of
+// APP_SLO_OBJECTIVES over APP_SLO_PERIOD, the defaults of the slo package
+// otherwise
+func newSLOTracker() (*slo.Tracker, error) {
+	viper.SetEnvPrefix("app")
+	viper.AutomaticEnv()
+
+	objectives, err := slo.ParseObjectives(viper.GetString("SLO_OBJECTIVES"))
+	if err != nil {
+		return nil, err
+	}
+	return slo.New("petlistadoptions", objectives, viper.GetDuration("SLO_PERIOD"))
+}
+
+// serverTLS reads the certificate of the HTTPS listener from APP_TLS_CERT_FILE
+// and APP_TLS_KEY_FILE, or from the PEM values of APP_TLS_CERT and APP_TLS_KEY,
+// and the CAs of the clients from APP_TLS_CLIENT_CA_FILE
+func serverTLS() servertls.Options {
+	viper.SetEnvPrefix("app")
+	viper.AutomaticEnv()
+
+	return servertls.Options{
+		CertFile:     viper.GetString("TLS_CERT_FILE"),
+		KeyFile:      viper.GetString("TLS_KEY_FILE"),
+		CertPEM:      viper.GetString("TLS_CERT"),
+		KeyPEM:       viper.GetString("TLS_KEY"),
+		ClientCAFile: viper.GetString("TLS_CLIENT_CA_FILE"),
+	}
+}
+
+// logRedaction reads the personal data fields to mask from LOG_REDACT_FIELDS,
+// LOG_COMPLIANCE_MODE=true drops them from the records instead
+func logRedaction() logging.Redaction {
+	r := logging.Redaction{
+		Fields:         logging.DefaultRedactedFields,
+		ComplianceMode: os.Getenv("LOG_COMPLIANCE_MODE") == "true",
+	}
+	if v := os.Getenv("LOG_REDACT_FIELDS"); v != "" {
+		r.Fields = strings.Split(v, ",")
+	}
+	return r
+}
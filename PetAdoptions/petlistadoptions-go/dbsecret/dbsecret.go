@@ -0,0 +1,97 @@
+// Package dbsecret opens Postgres connections with the credentials stored in
+// Secrets Manager or with RDS IAM authentication tokens. The connection string
+// is cached, and refetched when it gets too old or when the server rejects
+// the password because the secret has been rotated.
+package dbsecret
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/lib/pq"
+)
+
+// invalidPassword is the Postgres error code for failed password authentication
+const invalidPassword = "28P01"
+
+// minRefresh keeps a wrong secret from turning every connection attempt into
+// a Secrets Manager call
+const minRefresh = 30 * time.Second
+
+// Fetcher returns a connection string built from the current secret value
+type Fetcher func() (string, error)
+
+type rotatingDriver struct {
+	fetch  Fetcher
+	maxAge time.Duration
+	logger log.Logger
+
+	mu      sync.Mutex
+	dsn     string
+	fetched time.Time
+}
+
+// Register makes a postgres driver available under name. The name passed to
+// sql.Open is ignored, connections always use the cached connection string,
+// refetched after maxAge when it is not 0.
+// Pooled connections are not affected by a rotation, only new ones are.
+func Register(name string, fetch Fetcher, maxAge time.Duration, logger log.Logger) {
+	sql.Register(name, &rotatingDriver{
+		fetch:  fetch,
+		maxAge: maxAge,
+		logger: log.With(logger, "component", "dbsecret"),
+	})
+}
+
+func (d *rotatingDriver) Open(_ string) (driver.Conn, error) {
+	dsn, err := d.connectionString(false)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pq.Open(dsn)
+	if !isAuthFailure(err) {
+		return conn, err
+	}
+
+	level.Info(d.logger).Log("msg", "database authentication failed, refreshing secret")
+	if dsn, err = d.connectionString(true); err != nil {
+		return nil, err
+	}
+
+	return pq.Open(dsn)
+}
+
+func (d *rotatingDriver) connectionString(refresh bool) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	age := time.Since(d.fetched)
+	expired := d.maxAge > 0 && age > d.maxAge
+	if d.dsn != "" && !expired && (!refresh || age < minRefresh) {
+		return d.dsn, nil
+	}
+
+	dsn, err := d.fetch()
+	if err != nil {
+		return "", err
+	}
+
+	if d.dsn != "" {
+		level.Info(d.logger).Log("msg", "connection string refreshed", "age", age, "expired", expired)
+	}
+
+	d.dsn = dsn
+	d.fetched = time.Now()
+	return dsn, nil
+}
+
+func isAuthFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == invalidPassword
+}
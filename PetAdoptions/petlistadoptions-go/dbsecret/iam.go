@@ -0,0 +1,23 @@
+package dbsecret
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds/rdsutils"
+)
+
+// IAMTokenMaxAge refreshes the tokens ahead of their 15 minutes validity
+const IAMTokenMaxAge = 10 * time.Minute
+
+// IAMAuthToken signs an RDS IAM authentication token for user with the task
+// credentials, it is used as the password of the connection
+func IAMAuthToken(host string, port int, user, region string) (string, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	return rdsutils.BuildAuthToken(fmt.Sprintf("%s:%d", host, port), region, user, sess.Config.Credentials)
+}
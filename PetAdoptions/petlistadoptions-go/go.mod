@@ -1,45 +1,64 @@
 module petadoptions
 
-go 1.15
+go 1.17
 
 require (
 	github.com/DataDog/sketches-go v0.0.1 // indirect
+	github.com/XSAM/otelsql v0.1.0
+	github.com/aws-samples/one-observability-demo/PetAdoptions/observability v0.0.0
 	github.com/aws/aws-sdk-go v1.37.16
 	github.com/aws/aws-xray-sdk-go v1.3.0
 	github.com/denisenkom/go-mssqldb v0.9.0
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/go-kit/kit v0.10.0
+	github.com/go-redis/redis/v8 v8.6.0
 	github.com/gogo/protobuf v1.3.2 // indirect
-	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/golang/protobuf v1.4.3
 	github.com/google/gofuzz v1.1.0 // indirect
+	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/graph-gophers/graphql-go v1.0.0
 	github.com/lib/pq v1.10.0
 	github.com/magiconair/properties v1.8.4 // indirect
 	github.com/mitchellh/mapstructure v1.4.1 // indirect
 	github.com/pelletier/go-toml v1.8.1 // indirect
-	github.com/prometheus/client_golang v1.3.0
+	github.com/prometheus/client_golang v1.14.0
+	github.com/pyroscope-io/client v0.2.3
+	github.com/sony/gobreaker v0.4.1
 	github.com/spf13/afero v1.5.1 // indirect
 	github.com/spf13/cast v1.3.1 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.7.1
+	go.opentelemetry.io/contrib/detectors/aws/ec2 v0.17.0
 	go.opentelemetry.io/contrib/detectors/aws/ecs v0.17.0
+	go.opentelemetry.io/contrib/detectors/aws/eks v0.17.0
 	go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux v0.17.0
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.17.0
 	go.opentelemetry.io/contrib/instrumentation/net/http v0.11.0 // indirect
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.17.0
 	go.opentelemetry.io/contrib/propagators/aws v0.17.0
 	go.opentelemetry.io/otel v0.17.0
 	go.opentelemetry.io/otel/exporters/otlp v0.17.0
+	go.opentelemetry.io/otel/exporters/stdout v0.17.0
+	go.opentelemetry.io/otel/metric v0.17.0
 	go.opentelemetry.io/otel/sdk v0.17.0
+	go.opentelemetry.io/otel/sdk/metric v0.17.0
 	go.opentelemetry.io/otel/trace v0.17.0
 	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83 // indirect
 	golang.org/x/net v0.0.0-20210222171744-9060382bd457 // indirect
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 	golang.org/x/sys v0.0.0-20210223095934-7937bea0104d // indirect
 	golang.org/x/text v0.3.5 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/genproto v0.0.0-20210223151946-22b48be4551b // indirect
-	google.golang.org/grpc v1.35.0 // indirect
+	google.golang.org/grpc v1.35.0
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/ini.v1 v1.62.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+// the shared module is used from the repository, the images are built with
+// the PetAdoptions directory as context
+replace github.com/aws-samples/one-observability-demo/PetAdoptions/observability => ../observability
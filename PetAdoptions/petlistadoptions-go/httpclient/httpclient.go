@@ -0,0 +1,168 @@
+// Package httpclient builds the HTTP client shared by the outbound calls of a
+// service, over one pooled transport so connections are reused between
+// requests, and exports how the connections were obtained.
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Options tune the pooled transport, zero values keep the defaults
+type Options struct {
+	// Timeout bounds a whole request, including reading the body
+	Timeout     time.Duration
+	DialTimeout time.Duration
+	// MaxIdleConnsPerHost is kept above the concurrency of the fan-outs,
+	// the default transport keeps only 2 and closes the others
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost caps the connections to a host, 0 for no limit
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 2 * time.Second
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = 32
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = 90 * time.Second
+	}
+	return o
+}
+
+// New returns a client over a pooled transport, its connection metrics are
+// exported under namespace. Tracing wraps the transport of the client.
+func New(namespace string, opts Options) *http.Client {
+	opts = opts.withDefaults()
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: &instrumentedTransport{next: transport, metrics: metricsFor(namespace)},
+	}
+}
+
+type connMetrics struct {
+	connections *stdprometheus.CounterVec
+	dns         *stdprometheus.HistogramVec
+	dial        *stdprometheus.HistogramVec
+	tls         *stdprometheus.HistogramVec
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   = map[string]*connMetrics{}
+)
+
+// metricsFor registers the metrics of namespace once, however many clients
+// share them
+func metricsFor(namespace string) *connMetrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if m, ok := metrics[namespace]; ok {
+		return m
+	}
+
+	buckets := stdprometheus.ExponentialBuckets(0.001, 2, 12)
+	m := &connMetrics{
+		connections: stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "connections_total",
+			Help:      "Number of connections used by the outbound requests, reused from the pool or new",
+		}, []string{"host", "reused"}),
+		dns: stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "dns_duration_seconds",
+			Help:      "Duration of the DNS lookups of the new connections",
+			Buckets:   buckets,
+		}, []string{"host"}),
+		dial: stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "dial_duration_seconds",
+			Help:      "Duration of the TCP connects of the new connections",
+			Buckets:   buckets,
+		}, []string{"host"}),
+		tls: stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "tls_handshake_duration_seconds",
+			Help:      "Duration of the TLS handshakes of the new connections",
+			Buckets:   buckets,
+		}, []string{"host"}),
+	}
+	stdprometheus.MustRegister(m.connections, m.dns, m.dial, m.tls)
+
+	metrics[namespace] = m
+	return m
+}
+
+// instrumentedTransport follows each request with an httptrace.ClientTrace,
+// which adds to the traces already set on the request context
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	metrics *connMetrics
+}
+
+func (t *instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.URL.Host
+	var dnsStart, dialStart, tlsStart time.Time
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.metrics.dns.WithLabelValues(host).Observe(time.Since(dnsStart).Seconds())
+		},
+		ConnectStart: func(string, string) { dialStart = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.metrics.dial.WithLabelValues(host).Observe(time.Since(dialStart).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				t.metrics.tls.WithLabelValues(host).Observe(time.Since(tlsStart).Seconds())
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused := "false"
+			if info.Reused {
+				reused = "true"
+			}
+			t.metrics.connections.WithLabelValues(host, reused).Inc()
+		},
+	}
+
+	return t.next.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+}
@@ -0,0 +1,99 @@
+// Package logging builds the service logger: JSON records on top of go-kit
+// log, filtered by a level that can be changed while running, and helpers to
+// correlate the records with the traces.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Level is the minimum severity logged
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// LevelVar holds a Level safe for concurrent updates, the zero value is debug
+type LevelVar struct {
+	v int32
+}
+
+func (lv *LevelVar) Level() Level {
+	return Level(atomic.LoadInt32(&lv.v))
+}
+
+func (lv *LevelVar) Set(l Level) {
+	atomic.StoreInt32(&lv.v, int32(l))
+}
+
+// New returns the JSON logger writing to w, records under lv are dropped and
+// personal data is redacted
+func New(w io.Writer, lv *LevelVar, r Redaction) log.Logger {
+	logger := log.NewJSONLogger(w)
+	logger = NewRedactor(logger, r)
+	logger = NewFilter(logger, lv)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	return logger
+}
+
+// NewFilter drops the records under the current level of lv. It must be the
+// innermost logger so it sees the level key added by log.With and level.Info.
+// Records without a level are kept as info.
+func NewFilter(next log.Logger, lv *LevelVar) log.Logger {
+	return &filter{next: next, lv: lv}
+}
+
+type filter struct {
+	next log.Logger
+	lv   *LevelVar
+}
+
+func (f *filter) Log(keyvals ...interface{}) error {
+	if levelOf(keyvals) < f.lv.Level() {
+		return nil
+	}
+	return f.next.Log(keyvals...)
+}
+
+func levelOf(keyvals []interface{}) Level {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] != level.Key() {
+			continue
+		}
+		if v, ok := keyvals[i+1].(level.Value); ok {
+			if l, err := ParseLevel(v.String()); err == nil {
+				return l
+			}
+		}
+	}
+	return LevelInfo
+}
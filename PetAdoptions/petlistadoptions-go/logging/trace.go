@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTrace adds the request id and the trace and span ids of ctx to the
+// records, the trace id in the X-Ray format so CloudWatch Logs Insights and
+// X-Ray can jump from one to the other
+func WithTrace(ctx context.Context, logger log.Logger) log.Logger {
+	if id := RequestID(ctx); id != "" {
+		logger = log.With(logger, "request_id", id)
+	}
+
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return logger
+	}
+	return log.With(logger, "trace_id", XRayTraceID(spanCtx.TraceID), "span_id", spanCtx.SpanID.String())
+}
+
+// XRayTraceID renders an OTel trace id in the X-Ray 1-{epoch}-{random} format
+func XRayTraceID(id trace.TraceID) string {
+	h := id.String()
+	return fmt.Sprintf("1-%s-%s", h[:8], h[8:])
+}
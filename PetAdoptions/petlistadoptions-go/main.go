@@ -2,156 +2,430 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"petadoptions/canary"
+	"petadoptions/dbsecret"
+	"petadoptions/httpclient"
+	"petadoptions/logging"
+	"petadoptions/pb"
 	"petadoptions/petlistadoptions"
+	"petadoptions/servertls"
 
+	"github.com/XSAM/otelsql"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
-	"go.opentelemetry.io/contrib/detectors/aws/ecs"
-	otelxray "go.opentelemetry.io/contrib/propagators/aws/xray"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp"
-	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
-	"go.opentelemetry.io/otel/sdk/resource"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/label"
+	"google.golang.org/grpc"
 )
 
-func init() {
-	// Create new OTLP Exporter struct
-	ctx := context.Background()
-
-	exporter, _ := otlp.NewExporter(
-		ctx,
-		otlphttp.NewDriver(
-			otlphttp.WithInsecure(),
-			otlphttp.WithEndpoint("0.0.0.0:55681"),
-		),
-	)
-
-	// AlwaysSample() returns a Sampler that samples every trace.
-	// Be careful about using this sampler in a production application with
-	// significant traffic: a new trace will be started and exported for every request.
-	cfg := sdktrace.Config{
-		DefaultSampler: sdktrace.AlwaysSample(),
-	}
-
-	// A custom ID Generator to generate traceIDs that conform to
-	// AWS X-Ray traceID format
-	idg := otelxray.NewIDGenerator()
-
-	// ECS plugin on XRay service map
-	ecsResourceDetector := ecs.NewResourceDetector()
-	ecsResource, err := ecsResourceDetector.Detect(ctx)
-
-	if err != nil {
-		fmt.Println("ECS Resource detection error:", err)
-	}
-
-	tracesNameResource, _ := resource.New(ctx,
-		resource.WithAttributes(
-			// the service name used to display traces in backends
-			semconv.ServiceNameKey.String("petlistadoptions"),
-		),
-	)
-
-	// merge custom reources together
-	ecsNamedResource := resource.Merge(ecsResource, tracesNameResource)
-
-	// Create a new TraceProvider struct passing in the config, the exporter
-	// and the ID Generator we want to use for our tracing
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithConfig(cfg),
-		sdktrace.WithSyncer(exporter),
-		sdktrace.WithIDGenerator(idg),
-		sdktrace.WithResource(ecsNamedResource),
-	)
-	// Set the traceprovider and the propagator we want to use
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(otelxray.Propagator{})
-}
-
 func main() {
 	var (
-		httpAddr = flag.String("http.addr", ":80", "HTTP Port binding")
+		httpAddr        = flag.String("http.addr", ":80", "HTTP Port binding")
+		httpsAddr       = flag.String("https.addr", ":443", "HTTPS Port binding, used when a certificate is configured")
+		adminAddr       = flag.String("admin.addr", ":9090", "Metrics and pprof port binding")
+		grpcAddr        = flag.String("grpc.addr", ":50051", "gRPC Port binding")
+		samplingRefresh = flag.Duration("sampling.refresh", time.Minute, "Sampling rules polling interval, 0 to disable")
+		canaryInterval  = flag.Duration("canary.interval", 0, "Interval of the synthetic requests to the service itself, 0 to disable")
+		canaryPaths     = flag.String("canary.paths", "/health/status,/api/adoptionlist/", "Comma separated paths requested by the canary")
 	)
 
 	flag.Parse()
 
+	// GC, heap and scheduler metrics on /metrics
+	observability.RegisterRuntimeMetrics()
+
+	logLevel := new(logging.LevelVar)
+	logLevel.Set(logging.LevelInfo)
+
 	var logger log.Logger
 	{
-		logger = log.NewJSONLogger(os.Stderr)
-		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = logging.New(os.Stderr, logLevel, logRedaction())
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
-	var cfg Config
+	// the sampling rules come with the configuration, read later
+	sampler := observability.NewRuleSampler()
+
+	var shutdownTracing observability.Shutdown
 	{
 		var err error
-		cfg, err = fetchConfig()
+		shutdownTracing, err = observability.InitTracerProvider(context.Background(), observability.Config{
+			ServiceName: "petlistadoptions",
+			// traces of the two deployment modes can be told apart
+			ResourceAttributes: []label.KeyValue{label.String("petlistadoptions.backend", adoptionsBackend())},
+			Sampler:            sampler,
+		})
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
 	}
 
-	var db *sql.DB
+	// PROFILER=pyroscope pushes the profiles, the admin port serves them anyway
+	shutdownProfiler, err := observability.StartProfiler(observability.Config{ServiceName: "petlistadoptions"}, logger)
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(-1)
+	}
+
+	configCache = newConfigCache(logger)
+
+	var cfg Config
 	{
 		var err error
-		var connStr string
-
-		withPassword := true
-		connStr, err = getRDSConnectionString(cfg.RDSSecretArn, withPassword)
+		cfg, err = fetchConfig()
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
+	}
 
-		// OTEL does not instrument yet database/sql, falling back to the native
-		// go sql interface
-		// https://github.com/open-telemetry/opentelemetry-go-contrib/issues/5
-		db, err = sql.Open("postgres", connStr)
+	// LOG_LEVEL or the /petstore/loglevel parameter, the admin endpoint
+	// overrides it at runtime
+	if cfg.LogLevel != "" {
+		l, err := logging.ParseLevel(cfg.LogLevel)
+		if err != nil {
+			level.Error(logger).Log("loglevel", cfg.LogLevel, "err", err)
+		} else {
+			logLevel.Set(l)
+		}
+	}
+
+	// without Aurora the adoptions are read from the history table
+	var db, reader *sql.DB
+	if !cfg.UsesDynamoDB() {
+		var err error
+
+		db, err = openDB("postgres-secret", cfg, "", logger)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
-
 		defer db.Close()
+		petlistadoptions.RegisterDBStats("primary", db)
+
+		// reads go to the Aurora replicas when a reader endpoint is configured,
+		// the Serverless v1 cluster of the stack has none
+		reader = db
+		if cfg.RDSReaderEndpoint != "" {
+			reader, err = openDB("postgres-secret-reader", cfg, cfg.RDSReaderEndpoint, logger)
+			if err != nil {
+				level.Error(logger).Log("exit", err)
+				os.Exit(-1)
+			}
+			defer reader.Close()
+			petlistadoptions.RegisterDBStats("reader", reader)
+		}
+	}
+
+	// the background workers stop with workersCtx, before the pools close
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workersCtx)
+		}()
+	}
+
+	runWorker(func(ctx context.Context) {
+		canary.Run(ctx, "petlistadoptions", canary.Options{
+			Addr:     *httpAddr,
+			Paths:    canary.ParsePaths(*canaryPaths),
+			Interval: *canaryInterval,
+		}, logger)
+	})
+
+	sampler.Update(cfg.SamplingRules)
+	// rules set on the task are not polled
+	if *samplingRefresh > 0 && os.Getenv("APP_SAMPLING_RULES") == "" {
+		runWorker(func(ctx context.Context) {
+			watchSamplingRules(ctx, sampler, *samplingRefresh, logger)
+		})
 	}
 
 	var s petlistadoptions.Service
+	var feed *petlistadoptions.AdoptionFeed
+	var graphQL http.Handler
 	{
+		cache, err := newPetCache(cfg)
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		if cfg.PetCacheInvalidationQueueURL != "" {
+			sess := session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+			invalidator := petlistadoptions.NewCacheInvalidator(sqs.New(sess), cfg.PetCacheInvalidationQueueURL, cache, logger)
+			runWorker(invalidator.Run)
+		}
+		// one pool for the pet search lookups and the health check
+		client := httpclient.New("petlistadoptions", cfg.HTTPClient)
+		client.Transport = otelhttp.NewTransport(client.Transport)
+
+		search := petlistadoptions.SearchOptions{
+			Concurrency:     cfg.PetSearchConcurrency,
+			Timeout:         cfg.PetSearchTimeout,
+			Retries:         cfg.PetSearchRetries,
+			BreakerFailures: uint32(cfg.BreakerFailures),
+			BreakerTimeout:  cfg.BreakerTimeout,
+			Client:          client,
+		}
+		var repo petlistadoptions.Repository
+		var checks []petlistadoptions.Check
+		if cfg.UsesDynamoDB() {
+			svc := dynamodb.New(session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))}))
+			repo = petlistadoptions.NewDynamoDBRepository(svc, cfg.HistoryTableName, logger, cache, search)
+			checks = []petlistadoptions.Check{
+				petlistadoptions.DynamoDBCheck("dynamodb", svc, cfg.HistoryTableName),
+			}
+		} else {
+			safeConnStr, _ := getRDSConnectionString(cfg, cfg.RDSReaderEndpoint, false)
+			repo = petlistadoptions.NewRepository(db, reader, logger, safeConnStr, cfg.UsesRDSProxy(), cache, search)
+			checks = []petlistadoptions.Check{
+				petlistadoptions.PingCheck("database", reader),
+				{
+					// new connections need the secret, pooled ones keep working.
+					// Secrets Manager is not asked on every health check.
+					Name:   "secret",
+					MaxAge: secretCheckMaxAge,
+					Probe: func(context.Context) error {
+						_, err := getSecretValue(cfg.RDSSecretArn, os.Getenv("AWS_REGION"))
+						return err
+					},
+				},
+			}
+		}
+		checks = append(checks, petlistadoptions.HTTPCheck("petsearch", cfg.PetSearchURL, client))
+		s = petlistadoptions.NewService(logger, repo, cfg.PetSearchURL, checks)
 
-		safeConnStr, _ := getRDSConnectionString(cfg.RDSSecretArn, false)
-		repo := petlistadoptions.NewRepository(db, logger, safeConnStr)
-		s = petlistadoptions.NewService(logger, repo, cfg.PetSearchURL)
+		feed = petlistadoptions.NewAdoptionFeed(repo, cfg.PetSearchURL, cfg.FeedPollInterval, logger)
+		runWorker(feed.Run)
+		graphQL = petlistadoptions.MakeGraphQLHandler(repo, cfg.PetSearchURL, cfg.RequestTimeout)
 		s = petlistadoptions.NewInstrumenting(logger, s)
 	}
 
+	slos, err := newSLOTracker()
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(-1)
+	}
+
 	var h http.Handler
 	{
-		h = petlistadoptions.MakeHTTPHandler(s, logger)
+		requests := httpmetrics.New("petlistadoptions", httpmetrics.Options{
+			Buckets: cfg.LatencyBuckets,
+			Native:  cfg.NativeHistograms,
+			TraceID: petlistadoptions.RequestTraceID,
+			Segment: petlistadoptions.CustomerSegment,
+		})
+		h = petlistadoptions.MakeHTTPHandler(s, logger, slos, requests, cfg.RequestTimeout, feed, graphQL, cfg.AccessLogSampleRate)
 	}
 
-	errs := make(chan error)
+	httpServer := &http.Server{Addr: *httpAddr, Handler: h}
+	// the feed clients would hold the shutdown until its timeout
+	httpServer.RegisterOnShutdown(feed.Close)
+
+	// the plain listener stays up for the load balancer health checks
+	var httpsServer *http.Server
+	tlsOpts := serverTLS()
+	if tlsOpts.Enabled() {
+		tlsConfig, err := servertls.Config(tlsOpts, logger)
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		httpsServer = &http.Server{Addr: *httpsAddr, Handler: h, TLSConfig: tlsConfig}
+		httpsServer.RegisterOnShutdown(feed.Close)
+	}
+
+	// the interceptors continue the trace of the caller, e.g. PetSite
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	)
+	pb.RegisterPetListAdoptionsServer(grpcServer, petlistadoptions.MakeGRPCServer(s, logger, cfg.RequestTimeout))
+
+	adminServer := &http.Server{Addr: *adminAddr, Handler: petlistadoptions.MakeAdminHandler(logLevel, slos)}
+
+	// buffered so the servers still exiting during the shutdown never block
+	errs := make(chan error, 5)
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
 
 	go func() {
 		logger.Log("transport", "HTTP", "addr", *httpAddr)
-		errs <- http.ListenAndServe(*httpAddr, h)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	if httpsServer != nil {
+		go func() {
+			logger.Log("transport", "HTTPS", "addr", *httpsAddr, "mtls", tlsOpts.ClientCAFile != "")
+			errs <- httpsServer.ListenAndServeTLS("", "")
+		}()
+	}
+
+	go func() {
+		logger.Log("transport", "gRPC", "addr", *grpcAddr)
+		ln, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- grpcServer.Serve(ln)
+	}()
+
+	go func() {
+		logger.Log("transport", "admin", "addr", *adminAddr)
+		errs <- adminServer.ListenAndServe()
 	}()
 
 	logger.Log("exit", <-errs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// stop accepting requests and let the in-flight ones, and the pet search
+	// lookups they fan out, complete
+	var servers sync.WaitGroup
+	for transport, srv := range map[string]*http.Server{"HTTP": httpServer, "HTTPS": httpsServer} {
+		if srv == nil {
+			continue
+		}
+		servers.Add(1)
+		go func(transport string, srv *http.Server) {
+			defer servers.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				level.Error(logger).Log("shutdown", transport, "err", err)
+			}
+		}(transport, srv)
+	}
+	gracefulStop(ctx, grpcServer)
+	servers.Wait()
+
+	stopWorkers()
+	workers.Wait()
+
+	// spans still batched are lost once the task stops
+	if err := shutdownTracing(ctx); err != nil {
+		level.Error(logger).Log("shutdown", "tracing", "err", err)
+	}
+	if err := shutdownProfiler(ctx); err != nil {
+		level.Error(logger).Log("shutdown", "profiler", "err", err)
+	}
+
+	// the metrics stay scrapeable until the end
+	adminServer.Shutdown(ctx)
+
+	// the pools are closed by the deferred calls
+}
+
+// shutdownTimeout stays under the 30s ECS waits between SIGTERM and SIGKILL
+const shutdownTimeout = 25 * time.Second
+
+// secretCheckMaxAge is how long the secret health check result is reused
+const secretCheckMaxAge = 5 * time.Minute
+
+// gracefulStop waits for the in-flight RPCs until ctx is done, and then
+// cancels them
+func gracefulStop(ctx context.Context, g *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		g.Stop()
+	}
+}
+
+// newPetCache builds the pet search cache selected by PET_CACHE, memory by
+// default, redis to share it between tasks or none to disable it
+func newPetCache(cfg Config) (petlistadoptions.PetCache, error) {
+	switch cfg.PetCache {
+	case "none":
+		return petlistadoptions.NewNopCache(), nil
+	case "memory":
+		return petlistadoptions.NewInstrumentedCache("memory", petlistadoptions.NewLRUCache(cfg.PetCacheSize, cfg.PetCacheTTL)), nil
+	case "redis":
+		if cfg.PetCacheRedisAddr == "" {
+			return nil, fmt.Errorf("PET_CACHE_REDIS_ADDR is required with PET_CACHE=redis")
+		}
+		opts := &redis.Options{Addr: cfg.PetCacheRedisAddr}
+		// ElastiCache in-transit encryption
+		if cfg.PetCacheRedisTLS {
+			opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		return petlistadoptions.NewInstrumentedCache("redis", petlistadoptions.NewRedisCache(redis.NewClient(opts), cfg.PetCacheTTL)), nil
+	default:
+		return nil, fmt.Errorf("unknown pet cache %q", cfg.PetCache)
+	}
+}
+
+// openDB registers a driver under name that keeps the credentials fresh, and
+// opens a pool with it against host, or the secret host when empty
+func openDB(name string, cfg Config, host string, logger log.Logger) (*sql.DB, error) {
+	withPassword := true
+	connStr, err := getRDSConnectionString(cfg, host, withPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxAge time.Duration
+	if cfg.UsesIAMAuth() {
+		maxAge = dbsecret.IAMTokenMaxAge
+	}
+
+	// new connections pick up the rotated credentials
+	dbsecret.Register(name, func() (string, error) {
+		return getRDSConnectionString(cfg, host, withPassword)
+	}, maxAge, logger)
+
+	// every statement gets a span, parented by the request span as long as
+	// the queries are given the request context
+	driverName, err := otelsql.Register(name, "postgresql")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driverName, connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the proxy does the pooling, idle client connections only hold proxy slots
+	if cfg.UsesRDSProxy() {
+		db.SetMaxIdleConns(2)
+		db.SetConnMaxIdleTime(30 * time.Second)
+		db.SetConnMaxLifetime(5 * time.Minute)
+	}
+
+	return db, nil
 }
@@ -0,0 +1,137 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PetListAdoptionsClient is the client API for PetListAdoptions service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PetListAdoptionsClient interface {
+	HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error)
+	ListAdoptions(ctx context.Context, in *ListAdoptionsRequest, opts ...grpc.CallOption) (*ListAdoptionsResponse, error)
+}
+
+type petListAdoptionsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPetListAdoptionsClient(cc grpc.ClientConnInterface) PetListAdoptionsClient {
+	return &petListAdoptionsClient{cc}
+}
+
+func (c *petListAdoptionsClient) HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error) {
+	out := new(HealthCheckResponse)
+	err := c.cc.Invoke(ctx, "/petlistadoptions.PetListAdoptions/HealthCheck", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *petListAdoptionsClient) ListAdoptions(ctx context.Context, in *ListAdoptionsRequest, opts ...grpc.CallOption) (*ListAdoptionsResponse, error) {
+	out := new(ListAdoptionsResponse)
+	err := c.cc.Invoke(ctx, "/petlistadoptions.PetListAdoptions/ListAdoptions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PetListAdoptionsServer is the server API for PetListAdoptions service.
+// All implementations must embed UnimplementedPetListAdoptionsServer
+// for forward compatibility
+type PetListAdoptionsServer interface {
+	HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
+	ListAdoptions(context.Context, *ListAdoptionsRequest) (*ListAdoptionsResponse, error)
+	mustEmbedUnimplementedPetListAdoptionsServer()
+}
+
+// UnimplementedPetListAdoptionsServer must be embedded to have forward compatible implementations.
+type UnimplementedPetListAdoptionsServer struct {
+}
+
+func (UnimplementedPetListAdoptionsServer) HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HealthCheck not implemented")
+}
+func (UnimplementedPetListAdoptionsServer) ListAdoptions(context.Context, *ListAdoptionsRequest) (*ListAdoptionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAdoptions not implemented")
+}
+func (UnimplementedPetListAdoptionsServer) mustEmbedUnimplementedPetListAdoptionsServer() {}
+
+// UnsafePetListAdoptionsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PetListAdoptionsServer will
+// result in compilation errors.
+type UnsafePetListAdoptionsServer interface {
+	mustEmbedUnimplementedPetListAdoptionsServer()
+}
+
+func RegisterPetListAdoptionsServer(s grpc.ServiceRegistrar, srv PetListAdoptionsServer) {
+	s.RegisterService(&PetListAdoptions_ServiceDesc, srv)
+}
+
+func _PetListAdoptions_HealthCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PetListAdoptionsServer).HealthCheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/petlistadoptions.PetListAdoptions/HealthCheck",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PetListAdoptionsServer).HealthCheck(ctx, req.(*HealthCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PetListAdoptions_ListAdoptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAdoptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PetListAdoptionsServer).ListAdoptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/petlistadoptions.PetListAdoptions/ListAdoptions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PetListAdoptionsServer).ListAdoptions(ctx, req.(*ListAdoptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PetListAdoptions_ServiceDesc is the grpc.ServiceDesc for PetListAdoptions service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PetListAdoptions_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "petlistadoptions.PetListAdoptions",
+	HandlerType: (*PetListAdoptionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "HealthCheck",
+			Handler:    _PetListAdoptions_HealthCheck_Handler,
+		},
+		{
+			MethodName: "ListAdoptions",
+			Handler:    _PetListAdoptions_ListAdoptions_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "petlistadoptions.proto",
+}
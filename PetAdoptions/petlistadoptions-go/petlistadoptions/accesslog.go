@@ -0,0 +1,74 @@
+package petlistadoptions
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"petadoptions/logging"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// accessLog writes a record per request. Successful requests are only logged
+// at the sample rate so load tests do not flood CloudWatch Logs, the others
+// always are.
+type accessLog struct {
+	logger     log.Logger
+	sampleRate float64
+}
+
+func newAccessLog(logger log.Logger, sampleRate float64) *accessLog {
+	return &accessLog{
+		logger:     log.With(logger, "log", "access"),
+		sampleRate: sampleRate,
+	}
+}
+
+type requestStartKey struct{}
+
+// serverOptions time the request and log it once the response is written
+func (l *accessLog) serverOptions() []httptransport.ServerOption {
+	return []httptransport.ServerOption{
+		httptransport.ServerBefore(func(ctx context.Context, _ *http.Request) context.Context {
+			return context.WithValue(ctx, requestStartKey{}, time.Now())
+		}),
+		httptransport.ServerFinalizer(l.finalize),
+	}
+}
+
+func (l *accessLog) finalize(ctx context.Context, code int, r *http.Request) {
+	size, _ := ctx.Value(httptransport.ContextKeyResponseSize).(int64)
+
+	var took time.Duration
+	if begin, ok := ctx.Value(requestStartKey{}).(time.Time); ok {
+		took = time.Since(begin)
+	}
+
+	l.log(ctx, code, r, size, took)
+}
+
+// log writes the record of r, the streams call it directly when they end
+func (l *accessLog) log(ctx context.Context, code int, r *http.Request, size int64, took time.Duration) {
+	if code >= 200 && code < 300 && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	logger := level.Info(l.logger)
+	if code >= http.StatusInternalServerError {
+		logger = level.Error(l.logger)
+	}
+
+	logging.WithTrace(ctx, logger).Log(
+		"method", r.Method,
+		"path", r.URL.Path,
+		"proto", r.Proto,
+		"remote_addr", r.RemoteAddr,
+		"status", code,
+		"latency_ms", float64(took)/float64(time.Millisecond),
+		"bytes", size,
+	)
+}
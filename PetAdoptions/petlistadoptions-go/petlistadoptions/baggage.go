@@ -0,0 +1,111 @@
+package petlistadoptions
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+
+	"petadoptions/logging"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Baggage entries set by payforadoption, the customer segment is assigned
+// here too for the requests which reach the service first
+const (
+	baggageUserID          = label.Key("userId")
+	baggageSessionID       = label.Key("sessionId")
+	baggageCustomerSegment = label.Key("customerSegment")
+	baggageCustomerRegion  = label.Key("customerRegion")
+)
+
+// Headers setting the customer segment of a request, as in payforadoption
+const (
+	customerSegmentHeader = "X-Customer-Segment"
+	customerRegionHeader  = "X-Customer-Region"
+)
+
+// The synthetic segments, the same closed set as payforadoption so both
+// services label their metrics alike. One user in premiumPercent is premium.
+var (
+	customerSegments = []string{"free", "premium"}
+	customerRegions  = []string{"ap-southeast", "eu-west", "us-east", "us-west"}
+)
+
+const premiumPercent = 20
+
+// populateCustomerSegment is a router middleware adding the customer segment
+// and region to the baggage extracted by otelmux, and to the request span. The
+// segment follows the user, then the session, and the request id stands in for
+// anonymous requests.
+func populateCustomerSegment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		key := mux.Vars(r)["userId"]
+		if key == "" {
+			key = baggage.Value(ctx, baggageUserID).AsString()
+		}
+		if key == "" {
+			key = baggage.Value(ctx, baggageSessionID).AsString()
+		}
+		if key == "" {
+			key = logging.RequestID(ctx)
+		}
+
+		segment, region := customerSegment(ctx, r, key)
+		values := []label.KeyValue{
+			baggageCustomerSegment.String(segment),
+			baggageCustomerRegion.String(region),
+		}
+		trace.SpanFromContext(ctx).SetAttributes(values...)
+
+		next.ServeHTTP(w, r.WithContext(baggage.ContextWithValues(ctx, values...)))
+	})
+}
+
+// customerSegment takes the segment and region of the headers, then of the
+// baggage sent by the caller, and otherwise derives them from a hash of key so
+// a user stays in the same segment. Values outside the known ones are ignored.
+func customerSegment(ctx context.Context, r *http.Request, key string) (segment, region string) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+
+	segment = "free"
+	if sum%100 < premiumPercent {
+		segment = "premium"
+	}
+	region = customerRegions[(sum/100)%uint32(len(customerRegions))]
+
+	for _, v := range []string{baggage.Value(ctx, baggageCustomerSegment).AsString(), r.Header.Get(customerSegmentHeader)} {
+		if oneOf(v, customerSegments) {
+			segment = v
+		}
+	}
+	for _, v := range []string{baggage.Value(ctx, baggageCustomerRegion).AsString(), r.Header.Get(customerRegionHeader)} {
+		if oneOf(v, customerRegions) {
+			region = v
+		}
+	}
+	return segment, region
+}
+
+// CustomerSegment returns the segment and region of a request, once
+// populateCustomerSegment has run
+func CustomerSegment(r *http.Request) (segment, region string) {
+	ctx := r.Context()
+	return baggage.Value(ctx, baggageCustomerSegment).AsString(), baggage.Value(ctx, baggageCustomerRegion).AsString()
+}
+
+func oneOf(v string, values []string) bool {
+	for _, s := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package petlistadoptions
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/go-redis/redis/v8"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PetCache keeps the pet search results by pet id, so repeated views of the
+// same adoptions skip the pet search API. A miss is reported with ok false,
+// errors are only returned when the cache itself could not be reached.
+type PetCache interface {
+	Get(ctx context.Context, petID string) (pets []pet, ok bool, err error)
+	Set(ctx context.Context, petID string, pets []pet) error
+	// Delete drops the pet, deleting a missing pet is not an error
+	Delete(ctx context.Context, petID string) error
+}
+
+type nopCache struct{}
+
+// NewNopCache disables caching, every lookup goes to the pet search API
+func NewNopCache() PetCache { return nopCache{} }
+
+func (nopCache) Get(context.Context, string) ([]pet, bool, error) { return nil, false, nil }
+
+func (nopCache) Set(context.Context, string, []pet) error { return nil }
+
+func (nopCache) Delete(context.Context, string) error { return nil }
+
+type lruEntry struct {
+	petID   string
+	pets    []pet
+	expires time.Time
+}
+
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewLRUCache keeps up to size pets in process for ttl, evicting the least
+// recently used one when full. Each task has its own copy.
+func NewLRUCache(size int, ttl time.Duration) PetCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+func (c *lruCache) Get(_ context.Context, petID string) ([]pet, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[petID]
+	if !ok {
+		return nil, false, nil
+	}
+
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, petID)
+		return nil, false, nil
+	}
+
+	c.order.MoveToFront(el)
+	return e.pets, true, nil
+}
+
+func (c *lruCache) Set(_ context.Context, petID string, pets []pet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[petID]; ok {
+		el.Value = &lruEntry{petID: petID, pets: pets, expires: time.Now().Add(c.ttl)}
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[petID] = c.order.PushFront(&lruEntry{petID: petID, pets: pets, expires: time.Now().Add(c.ttl)})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).petID)
+	}
+
+	return nil
+}
+
+func (c *lruCache) Delete(_ context.Context, petID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[petID]; ok {
+		c.order.Remove(el)
+		delete(c.entries, petID)
+	}
+	return nil
+}
+
+type redisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisCache shares the cache between tasks through Redis, e.g. an
+// ElastiCache cluster. Entries are stored as JSON and expire after ttl.
+func NewRedisCache(client *redis.Client, ttl time.Duration) PetCache {
+	return &redisCache{client: client, ttl: ttl}
+}
+
+func redisKey(petID string) string {
+	return "petlistadoptions:pet:" + petID
+}
+
+func (c *redisCache) Get(ctx context.Context, petID string) ([]pet, bool, error) {
+	b, err := c.client.Get(ctx, redisKey(petID)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var pets []pet
+	if err := json.Unmarshal(b, &pets); err != nil {
+		return nil, false, err
+	}
+	return pets, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, petID string, pets []pet) error {
+	b, err := json.Marshal(pets)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, redisKey(petID), b, c.ttl).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, petID string) error {
+	return c.client.Del(ctx, redisKey(petID)).Err()
+}
+
+type instrumentedCache struct {
+	name     string
+	requests metrics.Counter
+	PetCache
+}
+
+// NewInstrumentedCache counts the hits and misses of c, and records every
+// lookup as a span so cache hits can be told apart in the traces
+func NewInstrumentedCache(name string, c PetCache) PetCache {
+	return &instrumentedCache{
+		name:     name,
+		PetCache: c,
+		requests: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "petsearch_cache",
+			Name:      "requests_total",
+			Help:      "Number of pet search cache lookups by result",
+		}, []string{"cache", "result"}),
+	}
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, petID string) (pets []pet, ok bool, err error) {
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+	ctx, span := tracer.Start(ctx, "PetSearch Cache", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	pets, ok, err = c.PetCache.Get(ctx, petID)
+
+	result := "miss"
+	switch {
+	case err != nil:
+		result = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case ok:
+		result = "hit"
+	}
+
+	span.SetAttributes(
+		label.String("cache.name", c.name),
+		label.String("cache.result", result),
+		label.Bool("cache.hit", ok),
+		label.String("petid", petID),
+	)
+	c.requests.With("cache", c.name, "result", result).Add(1)
+
+	return pets, ok, err
+}
+
+func (c *instrumentedCache) Delete(ctx context.Context, petID string) error {
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+	ctx, span := tracer.Start(ctx, "PetSearch Cache Delete", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	span.SetAttributes(
+		label.String("cache.name", c.name),
+		label.String("petid", petID),
+	)
+
+	err := c.PetCache.Delete(ctx, petID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package petlistadoptions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// MakeClientEndpoints returns the Endpoints of a remote instance, e.g.
+// http://petlistadoptions. They implement Service, for code in this module
+// that drives an instance. Every Go service module is named petadoptions, so
+// other modules cannot import this package: they go by /openapi.json instead.
+func MakeClientEndpoints(instance string, options ...httptransport.ClientOption) (Endpoints, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	tgt, err := url.Parse(instance)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	tgt.Path = ""
+
+	return Endpoints{
+		HealthCheckEndpoint: httptransport.NewClient("GET", withPath(tgt, "/health/status"),
+			encodeEmptyClientRequest, decodeClientResponse(func() interface{} { return new(Health) }), options...).Endpoint(),
+		ListAdoptionsEndpoint: httptransport.NewClient("GET", withPath(tgt, "/api/adoptionlist/"),
+			encodeListAdoptionsClientRequest, decodeClientResponse(func() interface{} { return new([]Adoption) }), options...).Endpoint(),
+		ListUserAdoptionsEndpoint: httptransport.NewClient("GET", withPath(tgt, "/api/adoptionlist/user/"),
+			encodeListUserAdoptionsClientRequest, decodeClientResponse(func() interface{} { return new([]Adoption) }), options...).Endpoint(),
+		StreamAdoptionsEndpoint: makeStreamAdoptionsClientEndpoint(withPath(tgt, streamPath), options...),
+	}, nil
+}
+
+// HealthCheck returns an *Error when the instance is down
+func (e Endpoints) HealthCheck(ctx context.Context) (Health, error) {
+	res, err := e.HealthCheckEndpoint(ctx, nil)
+	if err != nil {
+		return Health{}, err
+	}
+	return *res.(*Health), nil
+}
+
+func (e Endpoints) ListAdoptions(ctx context.Context, q ListQuery) ([]Adoption, error) {
+	res, err := e.ListAdoptionsEndpoint(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return *res.(*[]Adoption), nil
+}
+
+func (e Endpoints) ListUserAdoptions(ctx context.Context, userID string, q ListQuery) ([]Adoption, error) {
+	res, err := e.ListUserAdoptionsEndpoint(ctx, listUserAdoptionsRequest{UserID: userID, Query: q})
+	if err != nil {
+		return nil, err
+	}
+	return *res.(*[]Adoption), nil
+}
+
+func (e Endpoints) StreamAdoptions(ctx context.Context, q ListQuery, emit func(Adoption) error) error {
+	_, err := e.StreamAdoptionsEndpoint(ctx, streamAdoptionsRequest{Query: q, Emit: emit})
+	return err
+}
+
+var _ Service = Endpoints{}
+
+func withPath(base *url.URL, path string) *url.URL {
+	u := *base
+	u.Path = path
+	return &u
+}
+
+func encodeEmptyClientRequest(context.Context, *http.Request, interface{}) error {
+	return nil
+}
+
+func encodeListAdoptionsClientRequest(_ context.Context, r *http.Request, request interface{}) error {
+	q := request.(ListQuery)
+	params := url.Values{}
+	if q.Limit != 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		params.Set("offset", strconv.Itoa(q.Offset))
+	}
+	if q.Cursor != 0 {
+		params.Set("cursor", strconv.FormatInt(q.Cursor, 10))
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.From.IsZero() {
+		params.Set("from", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		params.Set("to", q.To.Format("2006-01-02"))
+	}
+	if q.PetType != "" {
+		params.Set("pettype", q.PetType)
+	}
+	if q.PetColor != "" {
+		params.Set("color", q.PetColor)
+	}
+	if q.Sort != "" {
+		params.Set("sort", q.Sort)
+	}
+	if q.Ascending {
+		params.Set("order", "asc")
+	}
+	r.URL.RawQuery = params.Encode()
+	return nil
+}
+
+func encodeListUserAdoptionsClientRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(listUserAdoptionsRequest)
+	// the user id is a single path segment, even with a slash in it
+	r.URL.RawPath = r.URL.Path + url.PathEscape(req.UserID)
+	r.URL.Path += req.UserID
+	return encodeListAdoptionsClientRequest(ctx, r, req.Query)
+}
+
+// makeStreamAdoptionsClientEndpoint reads the stream as it arrives, passing
+// every adoption to the Emit of the request
+func makeStreamAdoptionsClientEndpoint(tgt *url.URL, options ...httptransport.ClientOption) endpoint.Endpoint {
+	options = append(options, httptransport.BufferedStream(true))
+	stream := httptransport.NewClient("GET", tgt, encodeStreamAdoptionsClientRequest, decodeStreamClientResponse, options...).Endpoint()
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		res, err := stream(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		body := res.(io.ReadCloser)
+		defer body.Close()
+
+		return nil, readAdoptionStream(body, request.(streamAdoptionsRequest).Emit)
+	}
+}
+
+func encodeStreamAdoptionsClientRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	return encodeListAdoptionsClientRequest(ctx, r, request.(streamAdoptionsRequest).Query)
+}
+
+// decodeStreamClientResponse leaves the body open for readAdoptionStream
+func decodeStreamClientResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode >= http.StatusBadRequest {
+		defer r.Body.Close()
+		return nil, decodeClientError(r)
+	}
+	return r.Body, nil
+}
+
+// decodeClientResponse decodes the body into a value made by newResponse, or
+// the error body into an *Error
+func decodeClientResponse(newResponse func() interface{}) httptransport.DecodeResponseFunc {
+	return func(_ context.Context, r *http.Response) (interface{}, error) {
+		if r.StatusCode >= http.StatusBadRequest {
+			return nil, decodeClientError(r)
+		}
+		res := newResponse()
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func decodeClientError(r *http.Response) error {
+	var res errorResponse
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil || res.Message == "" {
+		res.Message = http.StatusText(r.StatusCode)
+	}
+	return &Error{
+		Code:      res.Code,
+		Status:    r.StatusCode,
+		Retryable: res.Retryable,
+		Err:       errors.New(res.Message),
+	}
+}
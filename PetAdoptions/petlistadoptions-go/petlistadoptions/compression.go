@@ -0,0 +1,74 @@
+package petlistadoptions
+
+import (
+	"net/http"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// responseSizeBuckets go from 256B to 4MB, a full page of adoptions with the
+// pet URLs is in the tens of KB
+var responseSizeBuckets = stdprometheus.ExponentialBuckets(256, 4, 8)
+
+// compressionMiddlewares negotiate gzip or deflate with the client, and record
+// the size of the responses before and after compression so the bandwidth
+// saved can be graphed
+func compressionMiddlewares() []mux.MiddlewareFunc {
+	wire := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+		Namespace: "petlistadoptions",
+		Name:      "response_size_bytes",
+		Help:      "Size of the response bodies as sent, by content encoding",
+		Buckets:   responseSizeBuckets,
+	}, []string{"encoding"})
+	uncompressed := stdprometheus.NewHistogram(stdprometheus.HistogramOpts{
+		Namespace: "petlistadoptions",
+		Name:      "response_uncompressed_size_bytes",
+		Help:      "Size of the response bodies before compression",
+		Buckets:   responseSizeBuckets,
+	})
+	stdprometheus.MustRegister(wire, uncompressed)
+
+	return []mux.MiddlewareFunc{
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cw := &countingWriter{ResponseWriter: w}
+				next.ServeHTTP(cw, r)
+
+				encoding := w.Header().Get("Content-Encoding")
+				if encoding == "" {
+					encoding = "identity"
+				}
+				wire.WithLabelValues(encoding).Observe(float64(cw.n))
+			})
+		},
+		handlers.CompressHandler,
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cw := &countingWriter{ResponseWriter: w}
+				next.ServeHTTP(cw, r)
+				uncompressed.Observe(float64(cw.n))
+			})
+		},
+	}
+}
+
+// countingWriter counts the bytes of the body written through it
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += n
+	return n, err
+}
+
+// Flush sends the buffered body on, the streams flush every event
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
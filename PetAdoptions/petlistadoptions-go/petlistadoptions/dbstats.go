@@ -0,0 +1,47 @@
+package petlistadoptions
+
+import (
+	"database/sql"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterDBStats exposes the connection pool statistics of db, labelled with
+// the pool name so the primary and reader pools can be compared
+func RegisterDBStats(pool string, db *sql.DB) {
+	gauge := func(name, help string, value func(sql.DBStats) float64) {
+		stdprometheus.MustRegister(stdprometheus.NewGaugeFunc(stdprometheus.GaugeOpts{
+			Namespace:   "petlistadoptions",
+			Subsystem:   "db",
+			Name:        name,
+			Help:        help,
+			ConstLabels: stdprometheus.Labels{"pool": pool},
+		}, func() float64 { return value(db.Stats()) }))
+	}
+	// the wait statistics are totals since the pool was opened
+	counter := func(name, help string, value func(sql.DBStats) float64) {
+		stdprometheus.MustRegister(stdprometheus.NewCounterFunc(stdprometheus.CounterOpts{
+			Namespace:   "petlistadoptions",
+			Subsystem:   "db",
+			Name:        name,
+			Help:        help,
+			ConstLabels: stdprometheus.Labels{"pool": pool},
+		}, func() float64 { return value(db.Stats()) }))
+	}
+
+	gauge("open_connections", "Number of established connections", func(s sql.DBStats) float64 {
+		return float64(s.OpenConnections)
+	})
+	gauge("in_use_connections", "Number of connections currently in use", func(s sql.DBStats) float64 {
+		return float64(s.InUse)
+	})
+	gauge("idle_connections", "Number of idle connections", func(s sql.DBStats) float64 {
+		return float64(s.Idle)
+	})
+	counter("wait_count_total", "Total number of connections waited for", func(s sql.DBStats) float64 {
+		return float64(s.WaitCount)
+	})
+	counter("wait_duration_seconds_total", "Total time blocked waiting for a connection", func(s sql.DBStats) float64 {
+		return s.WaitDuration.Seconds()
+	})
+}
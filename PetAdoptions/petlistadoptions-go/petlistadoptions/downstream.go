@@ -0,0 +1,73 @@
+package petlistadoptions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"petadoptions/logging"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Dependencies of the downstream metrics, the request latency of the service
+// breaks down into the time spent in each of them
+const (
+	dependencyPetSearch = "petsearch"
+	dependencyRDS       = "rds"
+	dependencyDynamoDB  = "dynamodb"
+)
+
+type downstream struct {
+	requests metrics.Counter
+	latency  *stdprometheus.HistogramVec
+}
+
+var (
+	downstreamOnce sync.Once
+	downstreamCall *downstream
+)
+
+// downstreamMetrics is shared by the repository and the pet search client
+func downstreamMetrics() *downstream {
+	downstreamOnce.Do(func() {
+		downstreamCall = &downstream{
+			requests: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "petlistadoptions",
+				Name:      "downstream_requests_total",
+				Help:      "Number of calls to a dependency",
+			}, []string{"dependency", "error"}),
+			latency: stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+				Namespace: "petlistadoptions",
+				Name:      "downstream_latency_seconds",
+				Help:      "Duration of the calls to a dependency in seconds",
+				Buckets:   httpmetrics.DefaultBuckets,
+			}, []string{"dependency"}),
+		}
+		stdprometheus.MustRegister(downstreamCall.latency)
+	})
+	return downstreamCall
+}
+
+// observe records a call to dependency, with the trace id as exemplar so a
+// slow dependency can be followed to a trace
+func (d *downstream) observe(ctx context.Context, dependency string, err error, begin time.Time) {
+	took := time.Since(begin).Seconds()
+
+	d.requests.With("dependency", dependency, "error", fmt.Sprint(err != nil)).Add(1)
+
+	obs := d.latency.WithLabelValues(dependency)
+	eo, ok := obs.(stdprometheus.ExemplarObserver)
+	if spanCtx := trace.SpanContextFromContext(ctx); ok && spanCtx.IsValid() {
+		eo.ObserveWithExemplar(took, stdprometheus.Labels{
+			"traceID": logging.XRayTraceID(spanCtx.TraceID),
+		})
+	} else {
+		obs.Observe(took)
+	}
+}
@@ -0,0 +1,264 @@
+package petlistadoptions
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// historyRecord is an adoption of the history table written by
+// petadoptionshistory
+type historyRecord struct {
+	PetID     string    `dynamodbav:"petid"`
+	AdoptedAt time.Time `dynamodbav:"adoptedat"`
+	AdoptedNs int64     `dynamodbav:"adoptedns"`
+	MessageID string    `dynamodbav:"messageid"`
+}
+
+// Indexes of the history table created by the CDK stack, both sorted by the
+// adoption time in nanoseconds. The time index holds every record in the
+// adoptionsFeed partition, the user index the records written with a user.
+const (
+	timeIndex     = "adoptions-by-time"
+	userIndex     = "adoptions-by-user"
+	adoptionsFeed = "adoptions"
+)
+
+// dynamoRepo reads the adoptions from the DynamoDB history table, for
+// environments deployed without Aurora. The records have no sequence number,
+// the adoption time in nanoseconds stands in for it in the cursors and the
+// message id is returned as the transaction id. Every read queries one of the
+// indexes of the table, records written before they existed are not listed.
+type dynamoRepo struct {
+	// pet search lookups, the SQL pools are not set
+	*repo
+	svc   *dynamodb.DynamoDB
+	table string
+}
+
+func NewDynamoDBRepository(svc *dynamodb.DynamoDB, table string, logger log.Logger, cache PetCache, search SearchOptions) Repository {
+	return &dynamoRepo{
+		repo:  newRepo(log.With(logger, "repo", "dynamodb"), cache, search),
+		svc:   svc,
+		table: table,
+	}
+}
+
+func (r *dynamoRepo) GetLatestAdoptions(ctx context.Context, petSearchURL string, q ListQuery) ([]Adoption, error) {
+	return collectAdoptions(q, func(emit func(Adoption) error) error {
+		return r.StreamLatestAdoptions(ctx, petSearchURL, q, emit)
+	})
+}
+
+// GetUserAdoptions is the adoption history of userID, adoptions sent without
+// a user are never returned
+func (r *dynamoRepo) GetUserAdoptions(ctx context.Context, petSearchURL, userID string, q ListQuery) ([]Adoption, error) {
+	logger := log.With(r.logger, "method", "GetUserAdoptions")
+	trace.SpanFromContext(ctx).SetAttributes(label.String("userid", userID))
+
+	txs, err := r.queryTransactions(ctx, logger, userIndex, "userid", userID, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectAdoptions(q, func(emit func(Adoption) error) error {
+		return r.lookupAdoptions(ctx, logger, petSearchURL, q, txs, emit)
+	})
+}
+
+func (r *dynamoRepo) StreamLatestAdoptions(ctx context.Context, petSearchURL string, q ListQuery, emit func(Adoption) error) error {
+	logger := log.With(r.logger, "method", "StreamLatestAdoptions")
+
+	txs, err := r.latestTransactions(ctx, logger, q)
+	if err != nil {
+		return err
+	}
+
+	return r.lookupAdoptions(ctx, logger, petSearchURL, q, txs, emit)
+}
+
+func (r *dynamoRepo) GetNewAdoptions(ctx context.Context, petSearchURL string, after int64, limit int) ([]Adoption, error) {
+	logger := log.With(r.logger, "method", "GetNewAdoptions")
+
+	// the adoptions after the cursor, in the order they were made
+	q := ListQuery{Since: time.Unix(0, after), Limit: limit, Ascending: true}
+	txs, err := r.latestTransactions(ctx, logger, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectAdoptions(q, func(emit func(Adoption) error) error {
+		return r.lookupAdoptions(ctx, logger, petSearchURL, q, txs, emit)
+	})
+}
+
+func (r *dynamoRepo) GetLatestTransactions(ctx context.Context, q ListQuery) ([]Adoption, error) {
+	txs, err := r.latestTransactions(ctx, log.With(r.logger, "method", "GetLatestTransactions"), q)
+	if err != nil {
+		return nil, err
+	}
+	return transactionAdoptions(txs), nil
+}
+
+func (r *dynamoRepo) LatestCursor(ctx context.Context) (int64, error) {
+	txs, err := r.latestTransactions(ctx, log.With(r.logger, "method", "LatestCursor"), ListQuery{Limit: 1})
+	if err != nil || len(txs) == 0 {
+		return 0, err
+	}
+	return txs[0].ID, nil
+}
+
+func (r *dynamoRepo) latestTransactions(ctx context.Context, logger log.Logger, q ListQuery) ([]transaction, error) {
+	return r.queryTransactions(ctx, logger, timeIndex, "feed", adoptionsFeed, q)
+}
+
+// adoptionRange is the range of adoption times in nanoseconds selected by the
+// bounds of q, like the statements of the SQL repository. ok is false when the
+// range is empty.
+func (q ListQuery) adoptionRange() (lo, hi int64, ok bool) {
+	lo, hi = 0, math.MaxInt64
+	if q.Cursor != 0 {
+		hi = q.Cursor - 1
+	}
+	if !q.Since.IsZero() {
+		lo = q.Since.UnixNano() + 1
+	}
+	if !q.From.IsZero() && q.From.UnixNano() > lo {
+		lo = q.From.UnixNano()
+	}
+	if !q.To.IsZero() && q.To.AddDate(0, 0, 1).UnixNano()-1 < hi {
+		hi = q.To.AddDate(0, 0, 1).UnixNano() - 1
+	}
+	return lo, hi, lo <= hi
+}
+
+// queryTransactions reads the page of q from the partition of index whose
+// partition key is value. The pages of the query are read until the offset
+// and the limit of q are covered.
+func (r *dynamoRepo) queryTransactions(ctx context.Context, logger log.Logger, index, key, value string, q ListQuery) (txs []transaction, err error) {
+	defer func(begin time.Time) {
+		downstreamMetrics().observe(ctx, dependencyDynamoDB, err, begin)
+	}(time.Now())
+
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+	ctx, span := tracer.Start(ctx, "DynamoDB.Query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemKey.String("dynamodb"),
+			semconv.DBOperationKey.String("Query"),
+			label.String("aws.dynamodb.table_names", r.table),
+			label.String("aws.dynamodb.index_name", index),
+		),
+	)
+	defer span.End()
+
+	txs = []transaction{}
+	lo, hi, ok := q.adoptionRange()
+	if !ok {
+		return txs, nil
+	}
+
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key(key).Equal(expression.Value(value)).
+			And(expression.Key("adoptedns").Between(expression.Value(lo), expression.Value(hi)))).
+		WithProjection(expression.NamesList(
+			expression.Name("petid"),
+			expression.Name("adoptedat"),
+			expression.Name("adoptedns"),
+			expression.Name("messageid"),
+		)).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	// price and cuteness pages hold the latest transactions, see orderBy
+	ascending := q.Ascending && q.Sort != SortPrice && q.Sort != SortCuteness
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.table),
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(ascending),
+	}
+
+	want := q.Offset + q.Limit
+	var unmarshalErr error
+	for {
+		if q.Limit > 0 {
+			input.Limit = aws.Int64(int64(want - len(txs)))
+		}
+
+		var res *dynamodb.QueryOutput
+		res, err = r.svc.QueryWithContext(ctx, input)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logger.Log("error", err)
+			return nil, databaseError(err)
+		}
+
+		for _, item := range res.Items {
+			var rec historyRecord
+			if err := dynamodbattribute.UnmarshalMap(item, &rec); err != nil {
+				unmarshalErr = err
+				continue
+			}
+			txs = append(txs, transaction{
+				ID:            rec.AdoptedNs,
+				TransactionID: rec.MessageID,
+				PetID:         rec.PetID,
+				AdoptionDate:  rec.AdoptedAt,
+			})
+		}
+
+		if len(res.LastEvaluatedKey) == 0 || (q.Limit > 0 && len(txs) >= want) {
+			break
+		}
+		input.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+	if unmarshalErr != nil {
+		level.Error(logger).Log("err", unmarshalErr)
+	}
+
+	span.SetAttributes(label.Int("aws.dynamodb.count", len(txs)))
+
+	if q.Offset >= len(txs) {
+		return []transaction{}, nil
+	}
+	return txs[q.Offset:], nil
+}
+
+// DynamoDBCheck describes the history table, the adoption list is empty
+// without it
+func DynamoDBCheck(name string, svc *dynamodb.DynamoDB, table string) Check {
+	return Check{
+		Name:     name,
+		Critical: true,
+		Probe: func(ctx context.Context) error {
+			res, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+			if err != nil {
+				return err
+			}
+			if s := aws.StringValue(res.Table.TableStatus); s != dynamodb.TableStatusActive && s != dynamodb.TableStatusUpdating {
+				return fmt.Errorf("table %s is %s", table, s)
+			}
+			return nil
+		},
+	}
+}
@@ -9,12 +9,18 @@ import (
 type Endpoints struct {
 	HealthCheckEndpoint   endpoint.Endpoint
 	ListAdoptionsEndpoint endpoint.Endpoint
+
+	ListUserAdoptionsEndpoint endpoint.Endpoint
+	StreamAdoptionsEndpoint   endpoint.Endpoint
 }
 
 func MakeEndpoints(s Service) Endpoints {
 	return Endpoints{
 		HealthCheckEndpoint:   makeHealthCheckEndpoint(s),
 		ListAdoptionsEndpoint: makeListAdoptionsEndpoint(s),
+
+		ListUserAdoptionsEndpoint: makeListUserAdoptionsEndpoint(s),
+		StreamAdoptionsEndpoint:   makeStreamAdoptionsEndpoint(s),
 	}
 }
 
@@ -26,6 +32,32 @@ func makeHealthCheckEndpoint(s Service) endpoint.Endpoint {
 
 func makeListAdoptionsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		return s.ListAdoptions(ctx)
+		return s.ListAdoptions(ctx, request.(ListQuery))
+	}
+}
+
+type listUserAdoptionsRequest struct {
+	UserID string
+	Query  ListQuery
+}
+
+func makeListUserAdoptionsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listUserAdoptionsRequest)
+		return s.ListUserAdoptions(ctx, req.UserID, req.Query)
+	}
+}
+
+// streamAdoptionsRequest carries the callback writing the adoptions, the
+// endpoint has no response
+type streamAdoptionsRequest struct {
+	Query ListQuery
+	Emit  func(Adoption) error
+}
+
+func makeStreamAdoptionsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(streamAdoptionsRequest)
+		return nil, s.StreamAdoptions(ctx, req.Query, req.Emit)
 	}
 }
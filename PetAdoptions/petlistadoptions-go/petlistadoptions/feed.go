@@ -0,0 +1,258 @@
+package petlistadoptions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"petadoptions/logging"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	feedPath = "/api/adoptionlist/feed"
+
+	// feedBatchSize bounds the adoptions pushed by a single poll
+	feedBatchSize = 50
+
+	// feedBuffer adoptions wait for a slow client before new ones are dropped
+	feedBuffer = 64
+
+	// feedHeartbeat keeps idle connections open, the ALB closes them after 60s
+	feedHeartbeat = 15 * time.Second
+)
+
+// AdoptionFeed polls the new transactions while clients are connected and
+// pushes their adoptions to every client, so the database gets one query per
+// interval however many clients there are
+type AdoptionFeed struct {
+	repo         Repository
+	petSearchURL string
+	interval     time.Duration
+	logger       log.Logger
+
+	mu          sync.Mutex
+	subscribers map[chan Adoption]struct{}
+	// closed by Close, ends the client connections
+	done      chan struct{}
+	closeOnce sync.Once
+	// only used by the polling goroutine, 0 until the first poll with clients
+	cursor int64
+
+	connections metrics.Gauge
+	events      metrics.Counter
+	duration    metrics.Histogram
+}
+
+func NewAdoptionFeed(repo Repository, petSearchURL string, interval time.Duration, logger log.Logger) *AdoptionFeed {
+	return &AdoptionFeed{
+		repo:         repo,
+		petSearchURL: petSearchURL,
+		interval:     interval,
+		logger:       log.With(logger, "component", "feed"),
+		subscribers:  map[chan Adoption]struct{}{},
+		done:         make(chan struct{}),
+		connections: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "feed",
+			Name:      "connections",
+			Help:      "Number of clients connected to the adoption feed",
+		}, []string{}),
+		events: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "feed",
+			Name:      "events_total",
+			Help:      "Number of adoptions pushed to the feed clients, dropped for clients too slow to keep up",
+		}, []string{"result"}),
+		duration: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "feed",
+			Name:      "connection_duration_seconds",
+			Help:      "How long the clients stayed connected to the adoption feed",
+			Buckets:   stdprometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{}),
+	}
+}
+
+// Run polls every interval until ctx is done
+func (f *AdoptionFeed) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.poll(ctx)
+		}
+	}
+}
+
+// Close ends the client connections, so a server shutdown does not wait for
+// clients that never leave. The clients reconnect to another task.
+func (f *AdoptionFeed) Close() {
+	f.closeOnce.Do(func() { close(f.done) })
+}
+
+func (f *AdoptionFeed) poll(ctx context.Context) {
+	// without clients the feed restarts from the newest transaction
+	if f.subscriberCount() == 0 {
+		f.cursor = 0
+		return
+	}
+
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+	ctx, span := tracer.Start(ctx, "AdoptionFeed Poll")
+	defer span.End()
+
+	var err error
+	if f.cursor == 0 {
+		f.cursor, err = f.repo.LatestCursor(ctx)
+	} else {
+		var ax []Adoption
+		ax, err = f.repo.GetNewAdoptions(ctx, f.petSearchURL, f.cursor, feedBatchSize)
+		for _, a := range ax {
+			if a.id > f.cursor {
+				f.cursor = a.id
+			}
+			f.broadcast(a)
+		}
+		span.SetAttributes(label.Int("feed.adoptions", len(ax)))
+	}
+
+	span.SetAttributes(label.Int64("feed.cursor", f.cursor))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		level.Error(f.logger).Log("err", err)
+	}
+}
+
+func (f *AdoptionFeed) subscriberCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers)
+}
+
+// subscribe registers a client, the returned func unregisters it
+func (f *AdoptionFeed) subscribe() (<-chan Adoption, func()) {
+	ch := make(chan Adoption, feedBuffer)
+
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.connections.Set(float64(len(f.subscribers)))
+	f.mu.Unlock()
+
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subscribers, ch)
+		f.connections.Set(float64(len(f.subscribers)))
+		f.mu.Unlock()
+	}
+}
+
+// broadcast never waits for a client, a full buffer drops the adoption
+func (f *AdoptionFeed) broadcast(a Adoption) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- a:
+			f.events.With("result", "sent").Add(1)
+		default:
+			f.events.With("result", "dropped").Add(1)
+		}
+	}
+}
+
+// makeFeedHandler pushes the new adoptions as Server-Sent Events. The request
+// span lasts as long as the connection, with an event per adoption pushed.
+func makeFeedHandler(f *AdoptionFeed, al *accessLog) http.Handler {
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, feedPath,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("petlistadoptions", feedPath, r)...),
+			trace.WithAttributes(requestIDKey.String(logging.RequestID(ctx))),
+		)
+		defer span.End()
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			encodeError(ctx, fmt.Errorf("streaming is not supported by the connection"), w)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		w = cw
+
+		events, unsubscribe := f.subscribe()
+		defer unsubscribe()
+
+		begin := time.Now()
+		sent := 0
+		defer func() {
+			f.duration.Observe(time.Since(begin).Seconds())
+			span.SetAttributes(
+				label.Int("feed.sent", sent),
+				label.Float64("feed.connected_seconds", time.Since(begin).Seconds()),
+			)
+			al.log(ctx, http.StatusOK, r, int64(cw.n), time.Since(begin))
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(feedHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-f.done:
+				return
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case a := <-events:
+				b, err := json.Marshal(a)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %s\nevent: adoption\ndata: %s\n\n", a.Cursor, b); err != nil {
+					return
+				}
+				sent++
+				span.AddEvent("adoption pushed", trace.WithAttributes(label.String("transactionid", a.TransactionID)))
+			}
+			flusher.Flush()
+		}
+	})
+}
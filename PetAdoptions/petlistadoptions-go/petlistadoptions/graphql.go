@@ -0,0 +1,276 @@
+package petlistadoptions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// graphQLSchema exposes the adoptions with their pet details as a nested
+// object, so clients only pay for the fields they select
+const graphQLSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	# adoptions takes the parameters of GET /api/adoptionlist/, dates are
+	# YYYY-MM-DD except since which is RFC 3339
+	adoptions(
+		limit: Int
+		offset: Int
+		cursor: String
+		since: String
+		from: String
+		to: String
+		petType: String
+		petColor: String
+	): [Adoption!]!
+}
+
+type Adoption {
+	transactionId: String!
+	adoptionDate: String!
+	# cursor is passed back as the cursor argument to get the next page
+	cursor: String!
+	# degraded is set when the pet details could not be looked up, selecting
+	# it looks the pet up like selecting pet does
+	degraded: Boolean!
+	pet: Pet
+}
+
+type Pet {
+	id: String!
+	type: String!
+	color: String!
+	availability: String!
+	cutenessRate: String!
+	url: String!
+	price: String!
+}
+`
+
+// maxGraphQLDepth rejects queries nested deeper than the schema allows
+const maxGraphQLDepth = 4
+
+// graphQLParallelism bounds the resolvers running at once, the pet lookups of
+// a page among them
+const graphQLParallelism = 10
+
+// MakeGraphQLHandler serves the schema at POST /graphql. The adoptions are
+// read from the repository and their pets only looked up when the query
+// selects them. The query is bounded by timeout, or the caller's budget when
+// shorter.
+func MakeGraphQLHandler(repo Repository, petSearchURL string, timeout time.Duration) http.Handler {
+	schema := graphql.MustParseSchema(graphQLSchema, &queryResolver{repo: repo, petSearchURL: petSearchURL},
+		graphql.MaxDepth(maxGraphQLDepth),
+		graphql.MaxParallelism(graphQLParallelism),
+	)
+	h := &relay.Handler{Schema: schema}
+	deadline := withDeadline(timeout, "graphql", deadlineExceededCounter())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the budget covers the whole query, the pet resolvers run after the
+		// adoptions resolver has returned
+		serve := deadline(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return nil, nil
+		})
+		serve(populateTimeoutHint(r.Context(), r), nil)
+	})
+}
+
+// startResolverSpan records a resolver as a child of the request span, so
+// the traces show where the time of a query went field by field
+func startResolverSpan(ctx context.Context, typeName, fieldName string) (context.Context, trace.Span) {
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+	return tracer.Start(ctx, "GraphQL "+typeName+"."+fieldName,
+		trace.WithAttributes(
+			label.String("graphql.type", typeName),
+			label.String("graphql.field", fieldName),
+		),
+	)
+}
+
+type queryResolver struct {
+	repo         Repository
+	petSearchURL string
+}
+
+type adoptionsArgs struct {
+	Limit    *int32
+	Offset   *int32
+	Cursor   *string
+	Since    *string
+	From     *string
+	To       *string
+	PetType  *string
+	PetColor *string
+}
+
+// Adoptions only reads the transactions, unless the query filters on the
+// pet type or color which are only known once the pets are looked up
+func (r *queryResolver) Adoptions(ctx context.Context, args adoptionsArgs) ([]*adoptionResolver, error) {
+	ctx, span := startResolverSpan(ctx, "Query", "adoptions")
+	defer span.End()
+
+	q, err := args.listQuery()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	loaded := q.PetType != "" || q.PetColor != ""
+	var ax []Adoption
+	if loaded {
+		ax, err = r.repo.GetLatestAdoptions(ctx, r.petSearchURL, q)
+	} else {
+		ax, err = r.repo.GetLatestTransactions(ctx, q)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(
+		label.Int("adoptions.returned", len(ax)),
+		label.Bool("adoptions.pets_loaded", loaded),
+	)
+
+	resolvers := make([]*adoptionResolver, 0, len(ax))
+	for i := range ax {
+		resolvers = append(resolvers, &adoptionResolver{query: r, a: ax[i], loaded: loaded})
+	}
+	return resolvers, nil
+}
+
+func (args adoptionsArgs) listQuery() (ListQuery, error) {
+	var q ListQuery
+	var err error
+
+	if args.Limit != nil {
+		q.Limit = int(*args.Limit)
+		if q.Limit == 0 {
+			return q, ErrBadRequest
+		}
+	}
+	if args.Offset != nil {
+		q.Offset = int(*args.Offset)
+	}
+	if args.Cursor != nil {
+		if q.Cursor, err = strconv.ParseInt(*args.Cursor, 10, 64); err != nil || q.Cursor < 1 {
+			return q, ErrBadRequest
+		}
+	}
+	if args.Since != nil {
+		if q.Since, err = time.Parse(time.RFC3339, *args.Since); err != nil {
+			return q, ErrBadRequest
+		}
+	}
+	if args.From != nil {
+		if q.From, err = time.Parse("2006-01-02", *args.From); err != nil {
+			return q, ErrBadRequest
+		}
+	}
+	if args.To != nil {
+		if q.To, err = time.Parse("2006-01-02", *args.To); err != nil {
+			return q, ErrBadRequest
+		}
+	}
+	if args.PetType != nil {
+		q.PetType = *args.PetType
+	}
+	if args.PetColor != nil {
+		q.PetColor = *args.PetColor
+	}
+
+	return checkListQuery(q)
+}
+
+type adoptionResolver struct {
+	query *queryResolver
+	a     Adoption
+	// loaded is set when a already holds the pet details
+	loaded bool
+
+	once  sync.Once
+	pet   Adoption
+	found bool
+}
+
+func (r *adoptionResolver) TransactionID() string { return r.a.TransactionID }
+
+func (r *adoptionResolver) AdoptionDate() string { return r.a.AdoptionDate.Format(time.RFC3339) }
+
+func (r *adoptionResolver) Cursor() string { return r.a.Cursor }
+
+// Degraded needs the pet lookup, selecting it costs as much as selecting pet
+func (r *adoptionResolver) Degraded(ctx context.Context) bool {
+	r.lookup(ctx)
+	return r.pet.Degraded
+}
+
+// Pet is null for degraded adoptions, the pet search lookup failed, and for
+// pets pet search does not know
+func (r *adoptionResolver) Pet(ctx context.Context) *petResolver {
+	r.lookup(ctx)
+	if !r.found || r.pet.Degraded {
+		return nil
+	}
+	return &petResolver{a: r.pet}
+}
+
+// lookup calls pet search once per adoption, the first of the degraded and
+// pet resolvers to run does
+func (r *adoptionResolver) lookup(ctx context.Context) {
+	r.once.Do(func() {
+		if r.loaded {
+			r.pet, r.found = r.a, true
+			return
+		}
+
+		ctx, span := startResolverSpan(ctx, "Adoption", "pet")
+		defer span.End()
+		span.SetAttributes(
+			label.String("transactionid", r.a.TransactionID),
+			label.String("petid", r.a.PetID),
+		)
+
+		pet, err := r.query.repo.LookupPet(ctx, r.query.petSearchURL, r.a)
+		r.pet, r.found = pet, !errors.Is(err, ErrNotFound)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(label.Bool("degraded", r.pet.Degraded))
+	})
+}
+
+type petResolver struct {
+	a Adoption
+}
+
+func (r *petResolver) ID() string { return r.a.PetID }
+
+func (r *petResolver) Type() string { return r.a.PetType }
+
+func (r *petResolver) Color() string { return r.a.PetColor }
+
+func (r *petResolver) Availability() string { return r.a.Availability }
+
+func (r *petResolver) CutenessRate() string { return r.a.CutenessRate }
+
+func (r *petResolver) URL() string { return r.a.PetURL }
+
+func (r *petResolver) Price() string { return r.a.Price }
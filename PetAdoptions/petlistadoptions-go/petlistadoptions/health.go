@@ -0,0 +1,194 @@
+package petlistadoptions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Health states of /health/status. A degraded service still answers with
+// partial results. The load balancer checks /health/live instead, a failing
+// dependency is shared by every task and replacing them would not fix it.
+const (
+	HealthUp       = "up"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// healthCheckTimeout bounds every check, below the 5s of the ALB health check
+const healthCheckTimeout = 2 * time.Second
+
+// Check probes a dependency. A failing critical check takes the service down,
+// any other failing check only degrades it. A result younger than MaxAge is
+// reused instead of probing again, for probes calling a rate limited API.
+type Check struct {
+	Name     string
+	Critical bool
+	MaxAge   time.Duration
+	Probe    func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a Check
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Health is the state of the service and of each of its dependencies
+type Health struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// PingCheck pings db, an exhausted pool fails it as well since the ping has
+// to wait for a connection
+func PingCheck(name string, db *sql.DB) Check {
+	return Check{
+		Name:     name,
+		Critical: true,
+		Probe:    db.PingContext,
+	}
+}
+
+// HTTPCheck sends a HEAD request to url, server errors fail it
+func HTTPCheck(name, url string, client *http.Client) Check {
+	return Check{
+		Name: name,
+		Probe: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode >= http.StatusInternalServerError {
+				return fmt.Errorf("%s returned %d", name, resp.StatusCode)
+			}
+			return nil
+		},
+	}
+}
+
+type healthChecker struct {
+	checks  []Check
+	latency metrics.Gauge
+	up      metrics.Gauge
+
+	mu     sync.Mutex
+	cached map[string]cachedResult
+}
+
+type cachedResult struct {
+	res CheckResult
+	at  time.Time
+}
+
+func newHealthChecker(checks []Check) *healthChecker {
+	return &healthChecker{
+		checks: checks,
+		cached: map[string]cachedResult{},
+		latency: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "health",
+			Name:      "check_latency_seconds",
+			Help:      "Duration of the last health check of a dependency",
+		}, []string{"check"}),
+		up: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "health",
+			Name:      "check_up",
+			Help:      "Whether the last health check of a dependency passed",
+		}, []string{"check"}),
+	}
+}
+
+// run runs the checks at once, the slowest one bounds the health check
+func (h *healthChecker) run(ctx context.Context) Health {
+	res := Health{Status: HealthUp, Checks: make([]CheckResult, len(h.checks))}
+
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			res.Checks[i] = h.runCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, r := range res.Checks {
+		if r.Status == HealthUp {
+			continue
+		}
+		if h.checks[i].Critical {
+			res.Status = HealthDown
+		} else if res.Status == HealthUp {
+			res.Status = HealthDegraded
+		}
+	}
+
+	return res
+}
+
+func (h *healthChecker) runCheck(ctx context.Context, c Check) CheckResult {
+	if c.MaxAge <= 0 {
+		return h.probe(ctx, c)
+	}
+
+	h.mu.Lock()
+	cached, ok := h.cached[c.Name]
+	h.mu.Unlock()
+	if ok && time.Since(cached.at) < c.MaxAge {
+		return cached.res
+	}
+
+	res := h.probe(ctx, c)
+	h.mu.Lock()
+	h.cached[c.Name] = cachedResult{res: res, at: time.Now()}
+	h.mu.Unlock()
+	return res
+}
+
+func (h *healthChecker) probe(ctx context.Context, c Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	begin := time.Now()
+
+	// probes that ignore the context are abandoned at the timeout
+	done := make(chan error, 1)
+	go func() { done <- c.Probe(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	took := time.Since(begin)
+	h.latency.With("check", c.Name).Set(took.Seconds())
+
+	res := CheckResult{Name: c.Name, Status: HealthUp, LatencyMs: float64(took) / float64(time.Millisecond)}
+	if err != nil {
+		res.Status = HealthDown
+		res.Error = err.Error()
+		h.up.With("check", c.Name).Set(0)
+	} else {
+		h.up.With("check", c.Name).Set(1)
+	}
+	return res
+}
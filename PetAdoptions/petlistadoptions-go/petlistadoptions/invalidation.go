@@ -0,0 +1,156 @@
+package petlistadoptions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	invalidationMaxMessages = 10
+	// long polling, the maximum SQS allows
+	invalidationWaitSeconds = 20
+	// pause after a failed receive so a missing permission does not spin
+	invalidationBackoff = 5 * time.Second
+)
+
+// errInvalidAdoptionEvent marks messages that will never be processed, they
+// are dropped instead of being retried
+var errInvalidAdoptionEvent = errors.New("invalid adoption event")
+
+// adoptionEvent is the adoption published by payforadoption to the adoption
+// topic, delivered raw to the queue
+type adoptionEvent struct {
+	TransactionID string `json:"transactionid"`
+	PetID         string `json:"petid"`
+}
+
+// CacheInvalidator drops the cached pet search results of the adopted pets,
+// their availability changed so the cached copy is stale. The queue has a
+// single consumer per message: a shared cache is invalidated for every task
+// while an in-process cache only in the task that received the event, the
+// other tasks keep serving their copy until the TTL.
+type CacheInvalidator struct {
+	svc      *sqs.SQS
+	queueURL string
+	cache    PetCache
+	logger   log.Logger
+
+	invalidations metrics.Counter
+}
+
+func NewCacheInvalidator(svc *sqs.SQS, queueURL string, cache PetCache, logger log.Logger) *CacheInvalidator {
+	return &CacheInvalidator{
+		svc:      svc,
+		queueURL: queueURL,
+		cache:    cache,
+		logger:   log.With(logger, "component", "invalidation", "queue", queueURL),
+		invalidations: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "petsearch_cache",
+			Name:      "invalidations_total",
+			Help:      "Number of pet search cache entries invalidated by adoption events, by result",
+		}, []string{"result"}),
+	}
+}
+
+// Run receives adoption events until ctx is cancelled
+func (c *CacheInvalidator) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		res, err := c.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: aws.Int64(invalidationMaxMessages),
+			WaitTimeSeconds:     aws.Int64(invalidationWaitSeconds),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			level.Error(c.logger).Log("method", "ReceiveMessage", "err", err)
+			time.Sleep(invalidationBackoff)
+			continue
+		}
+
+		for _, m := range res.Messages {
+			c.process(ctx, m)
+		}
+	}
+}
+
+func (c *CacheInvalidator) process(ctx context.Context, m *sqs.Message) {
+	logger := log.With(c.logger, "messageId", aws.StringValue(m.MessageId))
+
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+	ctx, span := tracer.Start(ctx, "PetSearch Cache Invalidate",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("AmazonSQS"),
+			semconv.MessagingDestinationKey.String(c.queueURL),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingMessageIDKey.String(aws.StringValue(m.MessageId)),
+		),
+	)
+	defer span.End()
+
+	err := c.invalidate(ctx, m)
+
+	result := "invalidated"
+	switch {
+	case errors.Is(err, errInvalidAdoptionEvent):
+		result = "invalid"
+	case err != nil:
+		result = "error"
+	}
+	c.invalidations.With("result", result).Add(1)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		level.Error(logger).Log("method", "invalidate", "err", err)
+		// a cache failure is retried once the message is visible again
+		if !errors.Is(err, errInvalidAdoptionEvent) {
+			return
+		}
+	}
+
+	_, err = c.svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueURL),
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	if err != nil {
+		span.RecordError(err)
+		level.Error(logger).Log("method", "DeleteMessage", "err", err)
+	}
+}
+
+func (c *CacheInvalidator) invalidate(ctx context.Context, m *sqs.Message) error {
+	var e adoptionEvent
+	if err := json.Unmarshal([]byte(aws.StringValue(m.Body)), &e); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidAdoptionEvent, err)
+	}
+	if e.PetID == "" {
+		return fmt.Errorf("%w: no petid", errInvalidAdoptionEvent)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		label.String("petid", e.PetID),
+		label.String("transactionid", e.TransactionID),
+	)
+
+	return c.cache.Delete(ctx, e.PetID)
+}
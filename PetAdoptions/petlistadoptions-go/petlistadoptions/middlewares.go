@@ -2,9 +2,10 @@ package petlistadoptions
 
 import (
 	"context"
-	"fmt"
 	"time"
 
+	"petadoptions/logging"
+
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
@@ -14,66 +15,80 @@ import (
 )
 
 type middleware struct {
-	logger         log.Logger
-	requestCount   metrics.Counter
-	requestLatency metrics.Histogram
+	logger            log.Logger
+	adoptionsReturned metrics.Counter
 	Service
 }
 
+// NewInstrumenting counts the adoptions returned and logs the requests, the
+// request metrics are recorded by the HTTP handler, see httpmetrics
 func NewInstrumenting(logger log.Logger, s Service) Service {
-	labels := []string{"endpoint", "error"}
 	return &middleware{
 		logger:  logger,
 		Service: s,
-		requestCount: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
-			Namespace: "petlistadoptions",
-			Name:      "requests_total",
-			Help:      "Number of requests received",
-		}, labels),
-		requestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		adoptionsReturned: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 			Namespace: "petlistadoptions",
-			Name:      "requests_latency_seconds",
-			Help:      "Request durations in seconds",
-		}, labels),
+			Name:      "adoptions_returned_total",
+			Help:      "Number of adoptions returned, degraded ones miss the pet details",
+		}, []string{"endpoint", "degraded"}),
 	}
 }
 
-func (mw *middleware) ListAdoptions(ctx context.Context) (ax []Adoption, err error) {
+func (mw *middleware) ListAdoptions(ctx context.Context, q ListQuery) (ax []Adoption, err error) {
 	defer func(begin time.Time) {
+		mw.observeList(ctx, "adoptionlist", "ListAdoptions", q, ax, err, begin)
+	}(time.Now())
 
-		span := trace.SpanFromContext(ctx)
-		labelValues := []string{"endpoint", "adoptionlist", "error", fmt.Sprint(err != nil)}
-		mw.requestCount.With(labelValues...).Add(1)
-		mw.requestLatency.With(labelValues...).Observe(time.Since(begin).Seconds())
-
-		if span == nil {
-			return
-		}
-
-		span.SetAttributes(
-			label.Float64("timeTakenSeconds", time.Since(begin).Seconds()),
-			label.Int("resultCount", len(ax)),
-		)
-
-		spanCtx := span.SpanContext()
-
-		mw.logger.Log(
-			"method", "ListAdoptions",
-			"traceId", spanCtx.TraceID,
-			"SpanID", spanCtx.SpanID,
-			"resultCount", len(ax),
-			"took", time.Since(begin),
-			"err", err)
+	return mw.Service.ListAdoptions(ctx, q)
+}
+
+func (mw *middleware) ListUserAdoptions(ctx context.Context, userID string, q ListQuery) (ax []Adoption, err error) {
+	defer func(begin time.Time) {
+		mw.observeList(ctx, "useradoptions", "ListUserAdoptions", q, ax, err, begin)
 	}(time.Now())
 
-	return mw.Service.ListAdoptions(ctx)
+	return mw.Service.ListUserAdoptions(ctx, userID, q)
 }
 
-func (mw *middleware) HealthCheck(ctx context.Context) (res string, err error) {
+func (mw *middleware) StreamAdoptions(ctx context.Context, q ListQuery, emit func(Adoption) error) (err error) {
+	var ax []Adoption
 	defer func(begin time.Time) {
-		labelValues := []string{"endpoint", "health_check", "error", fmt.Sprint(err != nil)}
-		mw.requestCount.With(labelValues...).Add(1)
-		mw.requestLatency.With(labelValues...).Observe(time.Since(begin).Seconds())
+		mw.observeList(ctx, "adoptionstream", "StreamAdoptions", q, ax, err, begin)
 	}(time.Now())
-	return mw.Service.HealthCheck(ctx)
+
+	return mw.Service.StreamAdoptions(ctx, q, func(a Adoption) error {
+		ax = append(ax, a)
+		return emit(a)
+	})
+}
+
+// observeList records a request returning adoptions, with the number of
+// degraded ones
+func (mw *middleware) observeList(ctx context.Context, endpoint, method string, q ListQuery, ax []Adoption, err error, begin time.Time) {
+	span := trace.SpanFromContext(ctx)
+
+	degraded := countDegraded(ax)
+	mw.adoptionsReturned.With("endpoint", endpoint, "degraded", "true").Add(float64(degraded))
+	mw.adoptionsReturned.With("endpoint", endpoint, "degraded", "false").Add(float64(len(ax) - degraded))
+
+	if span == nil {
+		return
+	}
+
+	span.SetAttributes(
+		label.Float64("timeTakenSeconds", time.Since(begin).Seconds()),
+		label.Int("resultCount", len(ax)),
+		label.Int("degradedCount", degraded),
+		label.Int("limit", q.Limit),
+	)
+
+	logging.WithTrace(ctx, mw.logger).Log(
+		"method", method,
+		"resultCount", len(ax),
+		"degradedCount", degraded,
+		"limit", q.Limit,
+		"offset", q.Offset,
+		"cursor", q.Cursor,
+		"took", time.Since(begin),
+		"err", err)
 }
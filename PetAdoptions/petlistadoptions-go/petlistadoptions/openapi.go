@@ -0,0 +1,234 @@
+package petlistadoptions
+
+import "net/http"
+
+// openAPISpec documents the public API, keep it in sync with MakeHTTPHandler
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "petlistadoptions",
+    "description": "Lists the latest pet adoptions",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/health/live": {
+      "get": {
+        "operationId": "liveness",
+        "description": "Answers as long as the process serves requests, for the load balancer",
+        "responses": {
+          "200": {"description": "The process is alive"}
+        }
+      }
+    },
+    "/health/status": {
+      "get": {
+        "operationId": "healthCheck",
+        "responses": {
+          "200": {
+            "description": "The service is up or degraded",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Health"}}}
+          },
+          "503": {
+            "description": "A critical dependency is failing",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Health"}}}
+          }
+        }
+      }
+    },
+    "/api/adoptionlist/": {
+      "get": {
+        "operationId": "listAdoptions",
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 25}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}},
+          {
+            "name": "cursor",
+            "in": "query",
+            "description": "Cursor of the last adoption of the previous page, cannot be combined with offset",
+            "schema": {"type": "string"}
+          },
+          {
+            "name": "since",
+            "in": "query",
+            "description": "Only adoptions after this RFC 3339 time or date",
+            "schema": {"type": "string"}
+          },
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date"}},
+          {"name": "to", "in": "query", "description": "Inclusive", "schema": {"type": "string", "format": "date"}},
+          {
+            "name": "pettype",
+            "in": "query",
+            "description": "Applied after the page is read, a page can hold fewer than limit adoptions",
+            "schema": {"type": "string", "maxLength": 64}
+          },
+          {
+            "name": "color",
+            "in": "query",
+            "description": "Applied after the page is read, a page can hold fewer than limit adoptions",
+            "schema": {"type": "string", "maxLength": 64}
+          },
+          {
+            "name": "sort",
+            "in": "query",
+            "description": "Transaction order when not set. Price and cuteness sort the latest adoptions within the page, the cursor can only be used without sort",
+            "schema": {"type": "string", "enum": ["adoptiondate", "price", "cuteness"]}
+          },
+          {"name": "order", "in": "query", "schema": {"type": "string", "enum": ["asc", "desc"], "default": "desc"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The latest adoptions with the adopted pet details, newest first unless sorted",
+            "headers": {
+              "X-Degraded-Count": {
+                "description": "Number of adoptions returned without their pet details",
+                "schema": {"type": "integer"}
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/Adoption"}}
+              }
+            }
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/adoptionlist/stream": {
+      "get": {
+        "operationId": "streamAdoptions",
+        "description": "The adoptions of listAdoptions as newline-delimited JSON, each one sent as soon as its pet lookup completes. Takes the query parameters of listAdoptions except sort. A stream ended early by an error has a last line with an error object.",
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 25}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}},
+          {"name": "cursor", "in": "query", "schema": {"type": "string"}},
+          {"name": "pettype", "in": "query", "schema": {"type": "string", "maxLength": 64}},
+          {"name": "color", "in": "query", "schema": {"type": "string", "maxLength": 64}}
+        ],
+        "responses": {
+          "200": {
+            "description": "One adoption per line, in completion order",
+            "content": {"application/x-ndjson": {"schema": {"$ref": "#/components/schemas/Adoption"}}}
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/adoptionlist/feed": {
+      "get": {
+        "operationId": "adoptionFeed",
+        "description": "Server-Sent Events of the adoptions completed after the connection, an adoption event per adoption with the cursor as id. Comments are sent as keepalive.",
+        "responses": {
+          "200": {
+            "description": "Event stream, data is an Adoption",
+            "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Adoption"}}}
+          }
+        }
+      }
+    },
+    "/api/adoptionlist/user/{userId}": {
+      "get": {
+        "operationId": "listUserAdoptions",
+        "description": "Adoption history of a user, takes the query parameters of listAdoptions",
+        "parameters": [
+          {"name": "userId", "in": "path", "required": true, "schema": {"type": "string", "maxLength": 64}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 25}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}},
+          {"name": "cursor", "in": "query", "schema": {"type": "string"}},
+          {"name": "sort", "in": "query", "schema": {"type": "string", "enum": ["adoptiondate", "price", "cuteness"]}},
+          {"name": "order", "in": "query", "schema": {"type": "string", "enum": ["asc", "desc"], "default": "desc"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The adoptions of the user with the adopted pet details",
+            "headers": {
+              "X-Degraded-Count": {
+                "description": "Number of adoptions returned without their pet details",
+                "schema": {"type": "integer"}
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/Adoption"}}
+              }
+            }
+          },
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Health": {
+        "type": "object",
+        "properties": {
+          "status": {"type": "string", "enum": ["up", "degraded", "down"]},
+          "checks": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "name": {"type": "string"},
+                "status": {"type": "string", "enum": ["up", "down"]},
+                "latency_ms": {"type": "number"},
+                "error": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Adoption": {
+        "type": "object",
+        "properties": {
+          "transactionid": {"type": "string"},
+          "adoptiondate": {"type": "string", "format": "date-time"},
+          "availability": {"type": "string"},
+          "cuteness_rate": {"type": "string"},
+          "petcolor": {"type": "string"},
+          "petid": {"type": "string"},
+          "pettype": {"type": "string"},
+          "peturl": {"type": "string"},
+          "price": {"type": "string"},
+          "cursor": {"type": "string"},
+          "degraded": {
+            "type": "boolean",
+            "description": "The pet search lookup failed, only transactionid, adoptiondate, petid and cursor are set"
+          }
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message", "retryable"],
+        "properties": {
+          "code": {
+            "type": "string",
+            "enum": ["BAD_REQUEST", "NOT_FOUND", "TIMEOUT", "DATABASE_ERROR", "DEPENDENCY_FAILURE", "INTERNAL_ERROR"]
+          },
+          "message": {"type": "string"},
+          "retryable": {"type": "boolean"},
+          "traceId": {"type": "string"},
+          "requestId": {"type": "string", "description": "X-Request-Id of the request, set on every response"}
+        }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "The request failed",
+        "headers": {
+          "X-Request-Id": {
+            "description": "Id of the request, the one sent by the caller when valid",
+            "schema": {"type": "string"}
+          }
+        },
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  }
+}
+`
+
+func openAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(openAPISpec))
+}
@@ -0,0 +1,177 @@
+package petlistadoptions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"petadoptions/logging"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retryBackoff is the base delay before the first retry, it doubles with
+// every attempt and is jittered so concurrent lookups do not retry together
+const retryBackoff = 50 * time.Millisecond
+
+// statusError is a pet search response other than 200 OK
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("pet search returned %d %s", e.status, http.StatusText(e.status))
+}
+
+// petSearch calls the pet search API. Transient failures are retried with
+// backoff, and once the API keeps failing the breaker opens and lookups fail
+// fast, leaving the page to the cached pets.
+type petSearch struct {
+	client  *http.Client
+	opts    SearchOptions
+	breaker *gobreaker.CircuitBreaker
+	retries metrics.Counter
+	logger  log.Logger
+}
+
+func newPetSearch(opts SearchOptions, logger log.Logger) *petSearch {
+	s := &petSearch{
+		client: opts.Client,
+		opts:   opts,
+		logger: log.With(logger, "dependency", "petsearch"),
+		retries: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "petsearch",
+			Name:      "retries_total",
+			Help:      "Number of pet search calls retried after a transient failure",
+		}, []string{}),
+	}
+
+	s.breaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name: "petsearch",
+		// probe with a single call when half open
+		MaxRequests: 1,
+		Timeout:     opts.BreakerTimeout,
+		ReadyToTrip: func(c gobreaker.Counts) bool {
+			return c.ConsecutiveFailures >= opts.BreakerFailures
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			level.Warn(s.logger).Log("breaker", name, "from", from.String(), "to", to.String())
+		},
+	})
+
+	// 0 closed, 1 half open, 2 open
+	stdprometheus.MustRegister(stdprometheus.NewGaugeFunc(stdprometheus.GaugeOpts{
+		Namespace: "petlistadoptions",
+		Subsystem: "petsearch",
+		Name:      "circuit_state",
+		Help:      "State of the pet search circuit breaker, 0 closed, 1 half open, 2 open",
+	}, func() float64 { return float64(s.breaker.State()) }))
+
+	return s
+}
+
+// fetch returns the pets with petID. A request cancelled by its caller is not
+// counted against the pet search API.
+func (s *petSearch) fetch(ctx context.Context, petID, petSearchURL string) ([]pet, error) {
+	var cancelled error
+
+	res, err := s.breaker.Execute(func() (interface{}, error) {
+		pets, err := s.fetchWithRetries(ctx, petID, petSearchURL)
+		if err != nil && ctx.Err() != nil {
+			cancelled = err
+			return nil, nil
+		}
+		return pets, err
+	})
+
+	trace.SpanFromContext(ctx).SetAttributes(label.String("petsearch.circuit_state", s.breaker.State().String()))
+
+	switch {
+	case cancelled != nil:
+		return nil, cancelled
+	case err != nil:
+		return nil, err
+	}
+	return res.([]pet), nil
+}
+
+func (s *petSearch) fetchWithRetries(ctx context.Context, petID, petSearchURL string) ([]pet, error) {
+	for attempt := 0; ; attempt++ {
+		pets, err := s.fetchOnce(ctx, petID, petSearchURL)
+		if err == nil || !retryable(err) || attempt >= s.opts.Retries || ctx.Err() != nil {
+			return pets, err
+		}
+
+		s.retries.Add(1)
+		level.Debug(s.logger).Log("petid", petID, "attempt", attempt+1, "err", err)
+
+		delay := time.Duration(rand.Int63n(int64(retryBackoff << uint(attempt))))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+func (s *petSearch) fetchOnce(ctx context.Context, petID, petSearchURL string) (pets []pet, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	// every attempt is a call, retries show up as more calls
+	defer func(begin time.Time) {
+		downstreamMetrics().observe(ctx, dependencyPetSearch, err, begin)
+	}(time.Now())
+
+	url := fmt.Sprintf("%spetid=%s", petSearchURL, petID)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	logging.InjectRequestID(ctx, req.Header)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// drained so the connection goes back to the pool
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{status: resp.StatusCode}
+	}
+
+	pets = []pet{}
+	if err := json.NewDecoder(resp.Body).Decode(&pets); err != nil {
+		return nil, err
+	}
+
+	return pets, nil
+}
+
+// retryable reports whether another attempt of the GET can succeed, server
+// errors, throttling, timeouts and connection failures are worth retrying
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status >= http.StatusInternalServerError || se.status == http.StatusTooManyRequests
+	}
+
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne)
+}
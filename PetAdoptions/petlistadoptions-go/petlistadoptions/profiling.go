@@ -0,0 +1,32 @@
+package petlistadoptions
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+// maxProfileLabelLength bounds the pettype label, it is read from the query
+const maxProfileLabelLength = 32
+
+// profileLabels is a router middleware labelling the CPU samples taken while
+// the request is served with its route and the pet type of the petTypeParam
+// query parameter, in the profiles of the admin port and the pushed ones
+func profileLabels(petTypeParam string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			endpoint := routeTemplate(r)
+
+			petType := r.URL.Query().Get(petTypeParam)
+			if len(petType) > maxProfileLabelLength {
+				petType = petType[:maxProfileLabelLength]
+			}
+
+			pprof.Do(r.Context(), pprof.Labels("endpoint", endpoint, "pettype", petType), func(ctx context.Context) {
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+}
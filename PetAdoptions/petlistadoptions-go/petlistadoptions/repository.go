@@ -3,41 +3,221 @@ package petlistadoptions
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-	"go.opentelemetry.io/otel"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 // Repository as an interface to define data store interactions
 type Repository interface {
-	GetLatestAdoptions(ctx context.Context, petSearchURL string) ([]Adoption, error)
+	GetLatestAdoptions(ctx context.Context, petSearchURL string, q ListQuery) ([]Adoption, error)
+	GetUserAdoptions(ctx context.Context, petSearchURL, userID string, q ListQuery) ([]Adoption, error)
+	// StreamLatestAdoptions calls emit with every adoption of the page as soon
+	// as its pet has been looked up, in completion order
+	StreamLatestAdoptions(ctx context.Context, petSearchURL string, q ListQuery, emit func(Adoption) error) error
+	// GetNewAdoptions returns the adoptions of up to limit transactions newer
+	// than the cursor after, oldest first
+	GetNewAdoptions(ctx context.Context, petSearchURL string, after int64, limit int) ([]Adoption, error)
+	// LatestCursor is the cursor of the newest transaction, 0 when there is none
+	LatestCursor(ctx context.Context) (int64, error)
+	// GetLatestTransactions returns the page of ListAdoptions without looking
+	// up the pets, only the transaction fields are filled. The pet filters of
+	// q are ignored.
+	GetLatestTransactions(ctx context.Context, q ListQuery) ([]Adoption, error)
+	// LookupPet fills in the pet details of an adoption of
+	// GetLatestTransactions, ErrNotFound when pet search has no such pet
+	LookupPet(ctx context.Context, petSearchURL string, a Adoption) (Adoption, error)
 }
 
-//repo as an implementation of Repository with dependency injection
+// ListQuery pages through the transactions, newest first. Cursor is the
+// cursor of the last adoption of the previous page and is exclusive with
+// Offset. A zero Since, From or To leaves that bound open.
+//
+// PetType and PetColor are matched against the pet search results, after the
+// page has been read, so a filtered page can hold fewer than Limit adoptions.
+//
+// Sort picks the order of the adoptions, descending unless Ascending is set.
+// The price and cuteness rate are only known once the pets have been looked
+// up, sorting on them orders the latest transactions within the page.
+type ListQuery struct {
+	Limit     int
+	Offset    int
+	Cursor    int64
+	Since     time.Time
+	From      time.Time
+	To        time.Time
+	PetType   string
+	PetColor  string
+	Sort      string
+	Ascending bool
+}
+
+// Sort keys of the adoption list, the empty key keeps the transaction order
+const (
+	SortAdoptionDate = "adoptiondate"
+	SortPrice        = "price"
+	SortCuteness     = "cuteness"
+)
+
+// orderBy is the ORDER BY clause of the transactions read for a sort key,
+// only these clauses are ever added to the statement
+func (q ListQuery) orderBy() string {
+	dir := " DESC"
+	if q.Ascending {
+		dir = " ASC"
+	}
+
+	switch q.Sort {
+	case SortAdoptionDate:
+		return "adoption_date" + dir + ", id" + dir
+	case SortPrice, SortCuteness:
+		// the page holds the latest transactions, it is sorted in memory
+		return "id DESC"
+	default:
+		return "id" + dir
+	}
+}
+
+// before reports whether a comes before b on the page. Adoptions without a
+// value for the sort key, e.g. degraded ones, go last and ties keep the
+// transaction order.
+func (q ListQuery) before(a, b Adoption) bool {
+	ka, oka := q.sortKey(a)
+	kb, okb := q.sortKey(b)
+
+	switch {
+	case oka != okb:
+		return oka
+	case ka != kb:
+		return (ka < kb) == q.Ascending
+	}
+	return (a.id < b.id) == q.Ascending
+}
+
+func (q ListQuery) sortKey(a Adoption) (float64, bool) {
+	switch q.Sort {
+	case SortAdoptionDate:
+		return float64(a.AdoptionDate.Unix()), true
+	case SortPrice:
+		v, err := strconv.ParseFloat(a.Price, 64)
+		return v, err == nil
+	case SortCuteness:
+		v, err := strconv.ParseFloat(a.CutenessRate, 64)
+		return v, err == nil
+	default:
+		return 0, true
+	}
+}
+
+// matches applies the filters that can only be checked on the pet details
+func (q ListQuery) matches(a Adoption) bool {
+	if q.PetType != "" && !strings.EqualFold(q.PetType, a.PetType) {
+		return false
+	}
+	if q.PetColor != "" && !strings.EqualFold(q.PetColor, a.PetColor) {
+		return false
+	}
+	return true
+}
+
+// filterAttributes records the filters on the query span so slow or empty
+// results can be grouped by the filters used
+func (q ListQuery) filterAttributes() []label.KeyValue {
+	attrs := []label.KeyValue{}
+	if q.PetType != "" {
+		attrs = append(attrs, label.String("filter.pettype", q.PetType))
+	}
+	if q.PetColor != "" {
+		attrs = append(attrs, label.String("filter.petcolor", q.PetColor))
+	}
+	if !q.From.IsZero() {
+		attrs = append(attrs, label.String("filter.from", q.From.Format("2006-01-02")))
+	}
+	if !q.To.IsZero() {
+		attrs = append(attrs, label.String("filter.to", q.To.Format("2006-01-02")))
+	}
+	if !q.Since.IsZero() {
+		attrs = append(attrs, label.String("filter.since", q.Since.Format(time.RFC3339)))
+	}
+	if q.Sort != "" {
+		attrs = append(attrs, label.String("sort", q.Sort), label.Bool("sort.ascending", q.Ascending))
+	}
+	return attrs
+}
+
+//repo as an implementation of Repository with dependency injection.
+// Queries go to the reader pool, db is kept for writes.
 type repo struct {
 	db          *sql.DB
+	reader      *sql.DB
 	logger      log.Logger
 	safeConnStr string
+	proxy       bool
+	cache       PetCache
+	search      SearchOptions
+	petSearch   *petSearch
+
+	searchFailures metrics.Counter
+}
+
+// SearchOptions bounds the pet search lookups of a page, Concurrency lookups
+// run at once and each attempt is cancelled after Timeout. Failed attempts are
+// retried up to Retries times, and BreakerFailures consecutive failed lookups
+// stop the calls to the API for BreakerTimeout.
+type SearchOptions struct {
+	Concurrency     int
+	Timeout         time.Duration
+	Retries         int
+	BreakerFailures uint32
+	BreakerTimeout  time.Duration
+	// shared with the other outbound calls, see httpclient
+	Client *http.Client
 }
 
-func NewRepository(db *sql.DB, logger log.Logger, safeConnStr string) Repository {
+func NewRepository(db, reader *sql.DB, logger log.Logger, safeConnStr string, proxy bool, cache PetCache, search SearchOptions) Repository {
+	r := newRepo(log.With(logger, "repo", "sql"), cache, search)
+	r.db = db
+	r.reader = reader
+	r.safeConnStr = safeConnStr
+	r.proxy = proxy
+	return r
+}
+
+// newRepo sets up the pet search lookups, shared by the repositories
+func newRepo(logger log.Logger, cache PetCache, search SearchOptions) *repo {
 	return &repo{
-		db:          db,
-		logger:      log.With(logger, "repo", "sql"),
-		safeConnStr: safeConnStr,
+		cache:     cache,
+		search:    search,
+		petSearch: newPetSearch(search, logger),
+		logger:    logger,
+		searchFailures: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "petlistadoptions",
+			Subsystem: "petsearch",
+			Name:      "failures_total",
+			Help:      "Number of pet search lookups that failed, the adoption is returned degraded",
+		}, []string{"reason"}),
 	}
 }
 
 type transaction struct {
+	ID            int64
 	TransactionID string
 	PetID         string
 	AdoptionDate  time.Time
@@ -53,86 +233,344 @@ type pet struct {
 	Price        string `json:"price,omitempty"`
 }
 
-func (r *repo) GetLatestAdoptions(ctx context.Context, petSearchURL string) ([]Adoption, error) {
+// selectTransactions applies the bounds of a ListQuery, the statements add
+// their own conditions and the ORDER BY of the query
+const selectTransactions = `SELECT id, pet_id, transaction_id, adoption_date FROM transactions
+	WHERE ($1::bigint IS NULL OR id < $1::bigint)
+	AND ($2::timestamp IS NULL OR adoption_date > $2::timestamp)
+	AND ($5::date IS NULL OR adoption_date >= $5::date)
+	AND ($6::date IS NULL OR adoption_date < $6::date + 1)`
+
+const (
+	selectLatestTransactions = selectTransactions + `
+	ORDER BY %s LIMIT $3 OFFSET $4`
+
+	selectUserTransactions = selectTransactions + `
+	AND user_id = $7
+	ORDER BY %s LIMIT $3 OFFSET $4`
+)
+
+func (r *repo) GetLatestAdoptions(ctx context.Context, petSearchURL string, q ListQuery) ([]Adoption, error) {
 	logger := log.With(r.logger, "method", "GetTopTransactions")
 
-	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
-	_, span := tracer.Start(ctx, "PGSQL Query", trace.WithSpanKind(trace.SpanKindClient))
+	sql := fmt.Sprintf(selectLatestTransactions, q.orderBy())
+	return r.getAdoptions(ctx, logger, petSearchURL, q, sql, q.args()...)
+}
+
+// GetUserAdoptions is the adoption history of userID, transactions written
+// before user_id was recorded are never returned
+func (r *repo) GetUserAdoptions(ctx context.Context, petSearchURL, userID string, q ListQuery) ([]Adoption, error) {
+	logger := log.With(r.logger, "method", "GetUserAdoptions")
+	trace.SpanFromContext(ctx).SetAttributes(label.String("userid", userID))
+
+	sql := fmt.Sprintf(selectUserTransactions, q.orderBy())
+	return r.getAdoptions(ctx, logger, petSearchURL, q, sql, append(q.args(), userID)...)
+}
+
+func (r *repo) StreamLatestAdoptions(ctx context.Context, petSearchURL string, q ListQuery, emit func(Adoption) error) error {
+	logger := log.With(r.logger, "method", "StreamLatestAdoptions")
+
+	sql := fmt.Sprintf(selectLatestTransactions, q.orderBy())
+	return r.streamAdoptions(ctx, logger, petSearchURL, q, emit, sql, q.args()...)
+}
+
+func (r *repo) GetLatestTransactions(ctx context.Context, q ListQuery) ([]Adoption, error) {
+	logger := log.With(r.logger, "method", "GetLatestTransactions")
+
+	sql := fmt.Sprintf(selectLatestTransactions, q.orderBy())
+	txs, err := r.readTransactions(ctx, logger, sql, q.args()...)
+	if err != nil {
+		return nil, err
+	}
+	return transactionAdoptions(txs), nil
+}
+
+// LookupPet is shared with the DynamoDB repository, it only calls pet search
+func (r *repo) LookupPet(ctx context.Context, petSearchURL string, a Adoption) (Adoption, error) {
+	logger := log.With(r.logger, "method", "LookupPet")
+
+	t := transaction{ID: a.id, TransactionID: a.TransactionID, PetID: a.PetID, AdoptionDate: a.AdoptionDate}
+	res, err := r.searchForPet(ctx, t, petSearchURL)
+	if err != nil {
+		r.reportFailures(ctx, logger, []searchFailure{{petID: t.PetID, err: err}})
+		return degradedAdoption(t), err
+	}
+	if len(res) == 0 {
+		return a, ErrNotFound
+	}
+	return res[0], nil
+}
+
+const (
+	selectNewTransactions = `SELECT id, pet_id, transaction_id, adoption_date FROM transactions
+	WHERE id > $1 ORDER BY id LIMIT $2`
+
+	selectLatestCursor = `SELECT COALESCE(MAX(id), 0) FROM transactions`
+)
+
+func (r *repo) GetNewAdoptions(ctx context.Context, petSearchURL string, after int64, limit int) ([]Adoption, error) {
+	logger := log.With(r.logger, "method", "GetNewAdoptions")
+
+	q := ListQuery{Limit: limit, Ascending: true}
+	return r.getAdoptions(ctx, logger, petSearchURL, q, selectNewTransactions, after, limit)
+}
+
+func (r *repo) LatestCursor(ctx context.Context) (cursor int64, err error) {
+	defer func(begin time.Time) {
+		downstreamMetrics().observe(ctx, dependencyRDS, err, begin)
+	}(time.Now())
+
+	if err := r.reader.QueryRowContext(ctx, selectLatestCursor).Scan(&cursor); err != nil {
+		return 0, databaseError(err)
+	}
+	return cursor, nil
+}
+
+// args are the parameters of selectTransactions
+func (q ListQuery) args() []interface{} {
+	return []interface{}{nullCursor(q.Cursor), nullTime(q.Since), q.Limit, q.Offset, nullTime(q.From), nullTime(q.To)}
+}
+
+// getAdoptions reads the transactions selected by sql and looks up their pets
+func (r *repo) getAdoptions(ctx context.Context, logger log.Logger, petSearchURL string, q ListQuery, sql string, args ...interface{}) ([]Adoption, error) {
+	return collectAdoptions(q, func(emit func(Adoption) error) error {
+		return r.streamAdoptions(ctx, logger, petSearchURL, q, emit, sql, args...)
+	})
+}
+
+// collectAdoptions gathers the adoptions passed to emit by stream in the
+// order of the page
+func collectAdoptions(q ListQuery, stream func(emit func(Adoption) error) error) ([]Adoption, error) {
+	res := []Adoption{}
+	collect := func(a Adoption) error {
+		res = append(res, a)
+		return nil
+	}
+	if err := stream(collect); err != nil {
+		return nil, err
+	}
+
+	// pet lookups complete in any order, restore the page order
+	sort.SliceStable(res, func(i, j int) bool { return q.before(res[i], res[j]) })
+
+	return res, nil
+}
+
+// streamAdoptions reads the transactions selected by sql and calls emit with
+// the adoptions matching q as their pet lookups complete, one at a time
+func (r *repo) streamAdoptions(ctx context.Context, logger log.Logger, petSearchURL string, q ListQuery, emit func(Adoption) error, sql string, args ...interface{}) error {
+	// the statement spans come from the driver, the request span gets what
+	// the query was asked for
+	trace.SpanFromContext(ctx).SetAttributes(
+		label.String("db.url", r.safeConnStr),
+		label.Bool("db.proxy", r.proxy),
+	)
 
-	sql := `SELECT pet_id, transaction_id, adoption_date FROM transactions ORDER BY id DESC LIMIT 25`
-	// TODO: implement native sql instrumentation when issue is closed.
-	// https://github.com/open-telemetry/opentelemetry-go-contrib/issues/5
-	//rows, err := r.db.QueryContext(ctx, sql)
+	txs, err := r.readTransactions(ctx, logger, sql, args...)
+	if err != nil {
+		return err
+	}
 
+	return r.lookupAdoptions(ctx, logger, petSearchURL, q, txs, emit)
+}
+
+// lookupAdoptions looks up the pets of txs and calls emit with the adoptions
+// matching q as the lookups complete, one at a time
+func (r *repo) lookupAdoptions(ctx context.Context, logger log.Logger, petSearchURL string, q ListQuery, txs []transaction, emit func(Adoption) error) error {
+	span := trace.SpanFromContext(ctx)
 	span.SetAttributes(
-		label.String("sql", sql),
-		label.String("url", r.safeConnStr),
+		label.Int("limit", q.Limit),
+		label.Int("offset", q.Offset),
 	)
+	span.SetAttributes(q.filterAttributes()...)
+	span.SetAttributes(baggage.Set(ctx).ToSlice()...)
 
-	rows, err := r.db.Query(sql)
+	filtered := 0
+	failures, err := r.searchForPets(ctx, txs, petSearchURL, func(ax []Adoption) error {
+		for _, a := range ax {
+			if !q.matches(a) {
+				filtered++
+				continue
+			}
+			logger.Log("petid", a.PetID, "pettype", a.PetType, "petcolor", a.PetColor)
+			if err := emit(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if len(failures) > 0 {
+		r.reportFailures(ctx, logger, failures)
+	}
 	if err != nil {
-		logger.Log("error", err)
-		return nil, err
+		return err
 	}
-	span.End()
 
-	var wg sync.WaitGroup
-	adoptions := make(chan Adoption)
+	span.SetAttributes(label.Int("filter.excluded", filtered))
+
+	return nil
+}
 
+// readTransactions runs sql on the reader pool, the call lasts until the last
+// row has been read
+func (r *repo) readTransactions(ctx context.Context, logger log.Logger, sql string, args ...interface{}) (txs []transaction, err error) {
+	defer func(begin time.Time) {
+		downstreamMetrics().observe(ctx, dependencyRDS, err, begin)
+	}(time.Now())
+
+	rows, err := r.reader.QueryContext(ctx, sql, args...)
+	if err != nil {
+		logger.Log("error", err)
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	txs = []transaction{}
 	for rows.Next() {
 		t := transaction{}
 
-		err := rows.Scan(&t.PetID, &t.TransactionID, &t.AdoptionDate)
+		err := rows.Scan(&t.ID, &t.PetID, &t.TransactionID, &t.AdoptionDate)
 
 		if err != nil {
 			level.Error(logger).Log("err", err)
 			continue
 		}
-		wg.Add(1)
-		go searchForPet(ctx, r.logger, &wg, adoptions, t, petSearchURL)
+		txs = append(txs, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, databaseError(err)
 	}
 
-	go func() {
-		wg.Wait()
-		close(adoptions)
-	}()
+	return txs, nil
+}
 
-	res := []Adoption{}
+// nullCursor maps a zero cursor to a sql NULL so the first page is returned
+func nullCursor(c int64) interface{} {
+	if c == 0 {
+		return nil
+	}
+	return c
+}
 
-	for i := range adoptions {
-		logger.Log("petid", i.PetID, "pettype", i.PetType, "petcolor", i.PetColor)
-		res = append(res, i)
+// nullTime maps a zero time to a sql NULL so the bound stays open
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
 	}
+	return t
+}
 
-	return res, nil
+// searchFailure is a pet search lookup that failed, the adoption is returned
+// without the pet details
+type searchFailure struct {
+	petID string
+	err   error
+}
+
+// searchForPets looks up the pets of txs, running at most the configured
+// number of lookups at once, each bounded by the lookup timeout. The adoptions
+// of each transaction are passed to emit as soon as they are known, never
+// concurrently. Failed lookups are returned, an error is only returned when
+// the request itself was cancelled or emit failed.
+func (r *repo) searchForPets(ctx context.Context, txs []transaction, petSearchURL string, emit func([]Adoption) error) ([]searchFailure, error) {
+	var (
+		mu       sync.Mutex
+		failures []searchFailure
+	)
+
+	sem := semaphore.NewWeighted(int64(r.search.Concurrency))
+	g, gctx := errgroup.WithContext(ctx)
+
+	for _, t := range txs {
+		t := t
+		if err := sem.Acquire(gctx, 1); err != nil {
+			break
+		}
+
+		g.Go(func() error {
+			defer sem.Release(1)
+
+			res, err := r.searchForPet(gctx, t, petSearchURL)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, searchFailure{petID: t.PetID, err: err})
+				// stop the other lookups when the caller went away
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				// keep the adoption in the list with what the transaction knows
+				return emit([]Adoption{degradedAdoption(t)})
+			}
+			return emit(res)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return failures, err
+	}
+	if err := ctx.Err(); err != nil {
+		return failures, err
+	}
+
+	return failures, nil
 }
 
-func searchForPet(ctx context.Context, logger log.Logger, wg *sync.WaitGroup, queue chan Adoption, t transaction, petSearchURL string) {
-	logger = log.With(logger, "method", "searchForPet", "petid", t.PetID)
-	defer wg.Done()
+// degradedAdoption is the adoption of t without the pet details, for when the
+// pet search lookup failed
+func degradedAdoption(t transaction) Adoption {
+	a := transactionAdoption(t)
+	a.Degraded = true
+	return a
+}
 
-	url := fmt.Sprintf("%spetid=%s", petSearchURL, t.PetID)
+// transactionAdoption is the adoption of t before its pet is looked up
+func transactionAdoption(t transaction) Adoption {
+	return Adoption{
+		id:            t.ID,
+		Cursor:        strconv.FormatInt(t.ID, 10),
+		AdoptionDate:  t.AdoptionDate,
+		PetID:         t.PetID,
+		TransactionID: t.TransactionID,
+	}
+}
+
+func transactionAdoptions(txs []transaction) []Adoption {
+	res := make([]Adoption, 0, len(txs))
+	for _, t := range txs {
+		res = append(res, transactionAdoption(t))
+	}
+	return res
+}
 
-	client := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+func (r *repo) searchForPet(ctx context.Context, t transaction, petSearchURL string) ([]Adoption, error) {
+	logger := log.With(r.logger, "method", "searchForPet", "petid", t.PetID)
 
-	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
-	resp, err := client.Do(req)
+	// a failing cache only costs the lookup, the pet search API still answers
+	pets, ok, err := r.cache.Get(ctx, t.PetID)
 	if err != nil {
-		level.Error(logger).Log("err", err)
-		return
+		level.Warn(logger).Log("cache", "get", "err", err)
 	}
 
-	pets := []pet{}
-	err = json.NewDecoder(resp.Body).Decode(&pets)
-	if err != nil {
-		level.Error(logger).Log("err", err)
-		return
+	if !ok {
+		pets, err = r.petSearch.fetch(ctx, t.PetID, petSearchURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := r.cache.Set(ctx, t.PetID, pets); err != nil {
+			level.Warn(logger).Log("cache", "set", "err", err)
+		}
 	}
 
+	res := make([]Adoption, 0, len(pets))
 	for _, p := range pets {
 		// Merging elements from response. Result for petsearch is return as array
 
-		queue <- Adoption{
+		res = append(res, Adoption{
+			id:            t.ID,
+			Cursor:        strconv.FormatInt(t.ID, 10),
 			AdoptionDate:  t.AdoptionDate,
 			Availability:  p.Availability,
 			CutenessRate:  p.CutenessRate,
@@ -142,6 +580,40 @@ func searchForPet(ctx context.Context, logger log.Logger, wg *sync.WaitGroup, qu
 			PetURL:        p.PetURL,
 			Price:         p.Price,
 			TransactionID: t.TransactionID,
-		}
+		})
+	}
+
+	return res, nil
+}
+
+// reportFailures logs the failed lookups once per request and records them on
+// the request span, so a partial page is visible without reading every log
+func (r *repo) reportFailures(ctx context.Context, logger log.Logger, failures []searchFailure) {
+	petIDs := make([]string, 0, len(failures))
+	for _, f := range failures {
+		petIDs = append(petIDs, f.petID)
+		r.searchFailures.With("reason", failureReason(f.err)).Add(1)
+	}
+
+	level.Warn(logger).Log(
+		"msg", "partial pet search failure",
+		"failed", len(failures),
+		"petids", strings.Join(petIDs, ","),
+		"err", failures[0].err)
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		label.Int("petsearch.failed", len(failures)),
+		label.Array("petsearch.failed_petids", petIDs),
+	)
+}
+
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
 	}
 }
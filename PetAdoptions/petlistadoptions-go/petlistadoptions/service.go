@@ -2,6 +2,7 @@ package petlistadoptions
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -18,12 +19,33 @@ type Adoption struct {
 	PetType       string    `json:"pettype,omitempty"`
 	PetURL        string    `json:"peturl,omitempty"`
 	Price         string    `json:"price,omitempty"`
+	// Cursor is passed back as the cursor parameter to get the next page
+	Cursor string `json:"cursor,omitempty"`
+	// Degraded is set when the pet details could not be looked up, only the
+	// transaction fields are filled
+	Degraded bool `json:"degraded,omitempty"`
+
+	id int64
+}
+
+func countDegraded(ax []Adoption) int {
+	n := 0
+	for _, a := range ax {
+		if a.Degraded {
+			n++
+		}
+	}
+	return n
 }
 
 // links endpoints to transport
 type Service interface {
-	HealthCheck(ctx context.Context) (string, error)
-	ListAdoptions(ctx context.Context) ([]Adoption, error)
+	HealthCheck(ctx context.Context) (Health, error)
+	ListAdoptions(ctx context.Context, q ListQuery) ([]Adoption, error)
+	ListUserAdoptions(ctx context.Context, userID string, q ListQuery) ([]Adoption, error)
+	// StreamAdoptions calls emit with the adoptions of ListAdoptions as soon
+	// as each pet lookup completes, in completion order
+	StreamAdoptions(ctx context.Context, q ListQuery, emit func(Adoption) error) error
 }
 
 // object that handles the logic and complies with interface
@@ -31,24 +53,34 @@ type service struct {
 	logger       log.Logger
 	repository   Repository
 	petSearchURL string
+	health       *healthChecker
 }
 
-//inject dependencies into core logic
-func NewService(logger log.Logger, rep Repository, petSearchURL string) Service {
+//inject dependencies into core logic, checks are the dependencies probed by
+//the health check
+func NewService(logger log.Logger, rep Repository, petSearchURL string, checks []Check) Service {
 	return &service{
 		logger:       logger,
 		repository:   rep,
 		petSearchURL: petSearchURL,
+		health:       newHealthChecker(checks),
 	}
 }
 
-func (s service) HealthCheck(ctx context.Context) (string, error) {
-	return "alive", nil
+func (s service) HealthCheck(ctx context.Context) (Health, error) {
+	h := s.health.run(ctx)
+
+	if h.Status != HealthUp {
+		logger := log.With(s.logger, "method", "HealthCheck")
+		level.Warn(logger).Log("status", h.Status, "checks", fmt.Sprintf("%+v", h.Checks))
+	}
+
+	return h, nil
 }
 
-func (s service) ListAdoptions(ctx context.Context) ([]Adoption, error) {
+func (s service) ListAdoptions(ctx context.Context, q ListQuery) ([]Adoption, error) {
 
-	res, err := s.repository.GetLatestAdoptions(ctx, s.petSearchURL)
+	res, err := s.repository.GetLatestAdoptions(ctx, s.petSearchURL, q)
 
 	if err != nil {
 		logger := log.With(s.logger, "method", "ListAdoptions")
@@ -57,3 +89,25 @@ func (s service) ListAdoptions(ctx context.Context) ([]Adoption, error) {
 
 	return res, err
 }
+
+func (s service) ListUserAdoptions(ctx context.Context, userID string, q ListQuery) ([]Adoption, error) {
+	res, err := s.repository.GetUserAdoptions(ctx, s.petSearchURL, userID, q)
+
+	if err != nil {
+		logger := log.With(s.logger, "method", "ListUserAdoptions")
+		level.Error(logger).Log("err", err)
+	}
+
+	return res, err
+}
+
+func (s service) StreamAdoptions(ctx context.Context, q ListQuery, emit func(Adoption) error) error {
+	err := s.repository.StreamLatestAdoptions(ctx, s.petSearchURL, q, emit)
+
+	if err != nil {
+		logger := log.With(s.logger, "method", "StreamAdoptions")
+		level.Error(logger).Log("err", err)
+	}
+
+	return err
+}
@@ -0,0 +1,153 @@
+package petlistadoptions
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"petadoptions/logging"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	streamPath        = "/api/adoptionlist/stream"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// streamLine is a line of the stream, either an adoption or, once the status
+// has been sent, the error that ended the stream early
+type streamLine struct {
+	Adoption
+	Error *errorResponse `json:"error,omitempty"`
+}
+
+// makeStreamAdoptionsHandler writes the adoptions as newline-delimited JSON,
+// flushing every adoption as soon as its pet lookup completes. The request
+// span records an event per adoption sent, so the trace shows when each line
+// left the service.
+func makeStreamAdoptionsHandler(e endpoint.Endpoint, al *accessLog) http.Handler {
+	tracer := otel.GetTracerProvider().Tracer("petlistadoptions")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, streamPath,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("petlistadoptions", streamPath, r)...),
+			trace.WithAttributes(requestIDKey.String(logging.RequestID(ctx))),
+		)
+		defer span.End()
+
+		flusher, _ := w.(http.Flusher)
+		cw := &countingWriter{ResponseWriter: w}
+		w = cw
+
+		status := http.StatusOK
+		defer func(begin time.Time) {
+			span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+			al.log(ctx, status, r, int64(cw.n), time.Since(begin))
+		}(time.Now())
+
+		if r.Method != http.MethodGet {
+			status = http.StatusMethodNotAllowed
+			w.WriteHeader(status)
+			return
+		}
+
+		ctx = populateTimeoutHint(ctx, r)
+		req, err := decodeListAdoptionsRequest(ctx, r)
+		// the adoptions are sent in completion order
+		if err == nil && req.(ListQuery).Sort != "" {
+			err = ErrBadRequest
+		}
+		if err != nil {
+			_, status = newErrorResponse(ctx, err)
+			encodeError(ctx, err, w)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+		begin := time.Now()
+		sent := 0
+
+		writeHeader := func() {
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.WriteHeader(http.StatusOK)
+		}
+
+		emit := func(a Adoption) error {
+			if sent == 0 {
+				writeHeader()
+				span.SetAttributes(label.Int64("stream.first_adoption_ms", time.Since(begin).Milliseconds()))
+			}
+			if err := enc.Encode(a); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			sent++
+			span.AddEvent("adoption sent", trace.WithAttributes(
+				label.String("transactionid", a.TransactionID),
+				label.Bool("degraded", a.Degraded),
+			))
+			return nil
+		}
+
+		_, err = e(ctx, streamAdoptionsRequest{Query: req.(ListQuery), Emit: emit})
+		span.SetAttributes(label.Int("stream.sent", sent))
+
+		switch {
+		case err != nil && sent == 0:
+			_, status = newErrorResponse(ctx, err)
+			encodeError(ctx, err, w)
+		case err != nil:
+			// the status is already sent, the last line tells the client the
+			// stream is incomplete
+			res, _ := newErrorResponse(ctx, err)
+			enc.Encode(streamLine{Error: &res})
+		case sent == 0:
+			writeHeader()
+		}
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	})
+}
+
+// readAdoptionStream calls emit with the adoptions of an NDJSON stream, the
+// error line of a stream ended early is returned as an *Error
+func readAdoptionStream(body io.Reader, emit func(Adoption) error) error {
+	dec := json.NewDecoder(body)
+	for {
+		var line streamLine
+		if err := dec.Decode(&line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if line.Error != nil {
+			// the stream had been answered with 200 already
+			return &Error{
+				Code:      line.Error.Code,
+				Status:    http.StatusOK,
+				Retryable: line.Error.Retryable,
+				Err:       errors.New(line.Error.Message),
+			}
+		}
+		if err := emit(line.Adoption); err != nil {
+			return err
+		}
+	}
+}
@@ -4,45 +4,174 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/httpmetrics"
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability/slo"
+	"petadoptions/canary"
+	"petadoptions/logging"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func MakeHTTPHandler(s Service, logger log.Logger) http.Handler {
+// MakeHTTPHandler serves the API, the adoption feed unless feed is nil and
+// the GraphQL schema unless graphQL is nil. Successful requests are logged at
+// accessLogSampleRate, and the API requests are recorded in slos. Every
+// request is recorded in the RED metrics of requests.
+func MakeHTTPHandler(s Service, logger log.Logger, slos *slo.Tracker, requests *httpmetrics.Metrics, timeout time.Duration, feed *AdoptionFeed, graphQL http.Handler, accessLogSampleRate float64) http.Handler {
 	r := mux.NewRouter()
 
 	//Use open telementry instrumentation provided by gorilla
-	r.Use(otelmux.Middleware("petlistadoptions"))
+	r.Use(exceptStreams(otelmux.Middleware("petlistadoptions")))
+	// the customer segment labels the request metrics
+	r.Use(populateCustomerSegment)
+	// within the request span, its trace is the exemplar of the duration
+	r.Use(requests.Middleware(routeTemplate))
+	r.Use(annotateRequestID)
+	r.Use(compressionMiddlewares()...)
+	r.Use(profileLabels("pettype"))
 
 	e := MakeEndpoints(s)
+	e.ListAdoptionsEndpoint = withDeadline(timeout, "adoptionlist", deadlineExceededCounter())(e.ListAdoptionsEndpoint)
+	e.ListUserAdoptionsEndpoint = withDeadline(timeout, "useradoptions", deadlineExceededCounter())(e.ListUserAdoptionsEndpoint)
+	e.StreamAdoptionsEndpoint = withDeadline(timeout, "adoptionstream", deadlineExceededCounter())(e.StreamAdoptionsEndpoint)
+
+	al := newAccessLog(logger, accessLogSampleRate)
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 		httptransport.ServerErrorEncoder(encodeError),
-		httptransport.ServerFinalizer(loggingMiddleware),
+		httptransport.ServerBefore(populateTimeoutHint),
 	}
+	options = append(options, al.serverOptions()...)
+	options = append(options, httptransport.ServerFinalizer(observeSLO(slos)))
+
+	// liveness for the load balancer, the dependencies are reported by
+	// /health/status
+	r.Methods("GET").Path(livenessPath).HandlerFunc(liveness)
 
 	r.Methods("GET").Path("/health/status").Handler(httptransport.NewServer(
 		e.HealthCheckEndpoint,
 		decodeEmptyRequest,
-		encodeResponse,
+		encodeHealthResponse,
 		options...,
 	))
 
 	r.Methods("GET").Path("/api/adoptionlist/").Handler(httptransport.NewServer(
 		e.ListAdoptionsEndpoint,
-		decodeEmptyRequest,
+		decodeListAdoptionsRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("GET").Path("/api/adoptionlist/user/{userId}").Handler(httptransport.NewServer(
+		e.ListUserAdoptionsEndpoint,
+		decodeListUserAdoptionsRequest,
 		encodeResponse,
 		options...,
 	))
 
-	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
+	if graphQL != nil {
+		r.Methods("POST").Path("/graphql").Handler(graphQL)
+	}
+
+	r.Methods("GET").Path("/openapi.json").HandlerFunc(openAPIHandler)
+
+	r.Methods("GET").Path(streamPath).Handler(makeStreamAdoptionsHandler(e.StreamAdoptionsEndpoint, al))
+	if feed != nil {
+		r.Methods("GET").Path(feedPath).Handler(makeFeedHandler(feed, al))
+	}
+
+	return logging.RequestIDHandler(r)
+}
+
+// exceptStreams applies mw to every route but the streams, which start their
+// own server span and flush the response as they write it
+func exceptStreams(mw mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch routeTemplate(r) {
+			case streamPath, feedPath:
+				next.ServeHTTP(w, r)
+			default:
+				wrapped.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Span attributes holding the X-Request-Id of the request and marking the
+// requests of the canary
+const (
+	requestIDKey = label.Key("http.request_id")
+	syntheticKey = label.Key("synthetic")
+)
+
+// routeTemplate is the path template of the matched route, the path when it
+// has none
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// RequestTraceID is the trace of the request span, in the X-Ray format of the
+// other exemplars
+func RequestTraceID(r *http.Request, _ http.ResponseWriter) string {
+	if spanCtx := trace.SpanContextFromContext(r.Context()); spanCtx.IsValid() {
+		return logging.XRayTraceID(spanCtx.TraceID)
+	}
+	return ""
+}
+
+// annotateRequestID records the request id on the request span, so a trace can
+// be found from the id a user quotes, and marks the requests of the canary so
+// they can be filtered out
+func annotateRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(requestIDKey.String(logging.RequestID(r.Context())))
+		if canary.IsSynthetic(r) {
+			span.SetAttributes(syntheticKey.Bool(true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MakeAdminHandler serves the metrics, profiling, SLO and log level endpoints, it is bound
+// to its own port so they are neither public nor behind the API middlewares
+func MakeAdminHandler(lv *logging.LevelVar, slos *slo.Tracker) http.Handler {
+	r := http.NewServeMux()
+
+	// exemplars are only exposed in the OpenMetrics format
+	r.Handle("/metrics", promhttp.HandlerFor(
+		stdprometheus.DefaultGatherer,
+		promhttp.HandlerOpts{EnableOpenMetrics: true},
+	))
+
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	r.Handle("/slo", slos.Handler())
+
+	r.Handle("/admin/loglevel", logging.LevelHandler(lv))
 
 	return r
 }
@@ -51,6 +180,13 @@ type errorer interface {
 	error() error
 }
 
+const (
+	defaultListLimit = 25
+	maxListLimit     = 100
+	maxFilterLength  = 64
+	maxUserIDLength  = 64
+)
+
 var (
 	ErrNotFound   = errors.New("not found")
 	ErrBadRequest = errors.New("bad request parameters")
@@ -60,15 +196,155 @@ func decodeEmptyRequest(_ context.Context, r *http.Request) (interface{}, error)
 	return nil, nil
 }
 
+// decodeListAdoptionsRequest accepts since as RFC 3339 or YYYY-MM-DD
+func decodeListAdoptionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := ListQuery{Limit: defaultListLimit}
+	params := r.URL.Query()
+	var err error
+
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if v := params.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		if q.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil || q.Cursor < 1 || q.Offset != 0 {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if v := params.Get("from"); v != "" {
+		if q.From, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if v := params.Get("to"); v != "" {
+		if q.To, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return nil, ErrBadRequest
+	}
+
+	q.PetType = params.Get("pettype")
+	q.PetColor = params.Get("color")
+	if len(q.PetType) > maxFilterLength || len(q.PetColor) > maxFilterLength {
+		return nil, ErrBadRequest
+	}
+
+	if v := params.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			if q.Since, err = time.Parse("2006-01-02", v); err != nil {
+				return nil, ErrBadRequest
+			}
+		}
+	}
+
+	q.Sort = params.Get("sort")
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return nil, ErrBadRequest
+	}
+	if !validSort(q) {
+		return nil, ErrBadRequest
+	}
+
+	return q, nil
+}
+
+// decodeListUserAdoptionsRequest takes the parameters of the adoption list
+func decodeListUserAdoptionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	userID := mux.Vars(r)["userId"]
+	if userID == "" || len(userID) > maxUserIDLength {
+		return nil, ErrBadRequest
+	}
+
+	q, err := decodeListAdoptionsRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return listUserAdoptionsRequest{UserID: userID, Query: q.(ListQuery)}, nil
+}
+
+// validSort only accepts the known sort keys. The cursor follows the
+// transaction order, newest first, so it cannot be combined with another one.
+func validSort(q ListQuery) bool {
+	switch q.Sort {
+	case "", SortAdoptionDate, SortPrice, SortCuteness:
+	default:
+		return false
+	}
+	return q.Cursor == 0 || (q.Sort == "" && !q.Ascending)
+}
+
+// checkListQuery applies the bounds of the HTTP query parameters to the
+// queries decoded by the other transports, a zero limit is the default
+func checkListQuery(q ListQuery) (ListQuery, error) {
+	if q.Limit == 0 {
+		q.Limit = defaultListLimit
+	}
+	if q.Limit < 1 || q.Limit > maxListLimit || q.Offset < 0 || q.Cursor < 0 || (q.Cursor != 0 && q.Offset != 0) {
+		return q, ErrBadRequest
+	}
+	if len(q.PetType) > maxFilterLength || len(q.PetColor) > maxFilterLength {
+		return q, ErrBadRequest
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return q, ErrBadRequest
+	}
+	if !validSort(q) {
+		return q, ErrBadRequest
+	}
+	return q, nil
+}
+
+// degradedHeader counts the adoptions returned without their pet details
+const degradedHeader = "X-Degraded-Count"
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
 		encodeError(ctx, e.error(), w)
 		return nil
 	}
+	if ax, ok := response.([]Adoption); ok {
+		w.Header().Set(degradedHeader, strconv.Itoa(countDegraded(ax)))
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
 
+const livenessPath = "/health/live"
+
+// liveness answers as long as the process serves requests
+func liveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(`{"status":"alive"}` + "\n"))
+}
+
+// encodeHealthResponse answers 503 when a critical dependency is down, a
+// degraded service keeps serving partial results
+func encodeHealthResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	h := response.(Health)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if h.Status == HealthDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(h)
+}
+
 func encodeEmptyResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
 		encodeError(ctx, e.error(), w)
@@ -77,28 +353,30 @@ func encodeEmptyResponse(ctx context.Context, w http.ResponseWriter, response in
 	return nil
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	if err == nil {
 		panic("encodeError with nil error")
 	}
+
+	res, status := newErrorResponse(ctx, err)
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(codeFrom(err))
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
 }
 
-func codeFrom(err error) int {
-	switch err {
-	case ErrNotFound:
-		return http.StatusNotFound
-	case ErrBadRequest:
-		return http.StatusBadRequest
-	default:
-		return http.StatusInternalServerError
+// newErrorResponse is the body and status code of err
+func newErrorResponse(ctx context.Context, err error) (errorResponse, int) {
+	e := errorFrom(err)
+	res := errorResponse{
+		Code:      e.Code,
+		Message:   err.Error(),
+		Retryable: e.Retryable,
+		RequestID: logging.RequestID(ctx),
 	}
-}
 
-func loggingMiddleware(ctx context.Context, code int, r *http.Request) {
-	fmt.Println(r.Method, r.RequestURI, r.Proto, r.RemoteAddr, code)
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		res.TraceID = logging.XRayTraceID(spanCtx.TraceID)
+	}
+	return res, e.Status
 }
@@ -0,0 +1,146 @@
+package petlistadoptions
+
+import (
+	"context"
+	"time"
+
+	"petadoptions/pb"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct {
+	pb.UnimplementedPetListAdoptionsServer
+
+	healthCheck   grpctransport.Handler
+	listAdoptions grpctransport.Handler
+}
+
+// MakeGRPCServer serves the endpoints over gRPC, the deadline of the caller
+// is carried by the context so timeout only caps it
+func MakeGRPCServer(s Service, logger log.Logger, timeout time.Duration) pb.PetListAdoptionsServer {
+	e := MakeEndpoints(s)
+	e.ListAdoptionsEndpoint = withDeadline(timeout, "adoptionlist", deadlineExceededCounter())(e.ListAdoptionsEndpoint)
+
+	options := []grpctransport.ServerOption{
+		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+
+	return &grpcServer{
+		healthCheck: grpctransport.NewServer(
+			e.HealthCheckEndpoint,
+			decodeGRPCHealthCheckRequest,
+			encodeGRPCHealthCheckResponse,
+			options...,
+		),
+		listAdoptions: grpctransport.NewServer(
+			e.ListAdoptionsEndpoint,
+			decodeGRPCListAdoptionsRequest,
+			encodeGRPCListAdoptionsResponse,
+			options...,
+		),
+	}
+}
+
+func (s *grpcServer) HealthCheck(ctx context.Context, req *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
+	_, res, err := s.healthCheck.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return res.(*pb.HealthCheckResponse), nil
+}
+
+func (s *grpcServer) ListAdoptions(ctx context.Context, req *pb.ListAdoptionsRequest) (*pb.ListAdoptionsResponse, error) {
+	_, res, err := s.listAdoptions.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return res.(*pb.ListAdoptionsResponse), nil
+}
+
+func decodeGRPCHealthCheckRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func encodeGRPCHealthCheckResponse(_ context.Context, response interface{}) (interface{}, error) {
+	return &pb.HealthCheckResponse{Status: response.(Health).Status}, nil
+}
+
+// decodeGRPCListAdoptionsRequest applies the bounds of the HTTP query
+// parameters, zero values keep the defaults
+func decodeGRPCListAdoptionsRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(*pb.ListAdoptionsRequest)
+	q := ListQuery{
+		Limit:    int(req.Limit),
+		Offset:   int(req.Offset),
+		Cursor:   req.Cursor,
+		PetType:  req.PetType,
+		PetColor: req.PetColor,
+	}
+	var err error
+
+	if req.Since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, req.Since); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+	if req.From != "" {
+		if q.From, err = time.Parse("2006-01-02", req.From); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+	if req.To != "" {
+		if q.To, err = time.Parse("2006-01-02", req.To); err != nil {
+			return nil, ErrBadRequest
+		}
+	}
+
+	return checkListQuery(q)
+}
+
+func encodeGRPCListAdoptionsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	ax := response.([]Adoption)
+	res := &pb.ListAdoptionsResponse{
+		Adoptions:     make([]*pb.Adoption, 0, len(ax)),
+		DegradedCount: int32(countDegraded(ax)),
+	}
+	for _, a := range ax {
+		res.Adoptions = append(res.Adoptions, &pb.Adoption{
+			TransactionId: a.TransactionID,
+			AdoptionDate:  a.AdoptionDate.Format(time.RFC3339),
+			Availability:  a.Availability,
+			CutenessRate:  a.CutenessRate,
+			PetColor:      a.PetColor,
+			PetId:         a.PetID,
+			PetType:       a.PetType,
+			PetUrl:        a.PetURL,
+			Price:         a.Price,
+			Cursor:        a.Cursor,
+			Degraded:      a.Degraded,
+		})
+	}
+	return res, nil
+}
+
+// grpcError maps the API error codes to gRPC status codes
+func grpcError(err error) error {
+	e := errorFrom(err)
+
+	code := codes.Internal
+	switch e.Code {
+	case CodeBadRequest:
+		code = codes.InvalidArgument
+	case CodeNotFound:
+		code = codes.NotFound
+	case CodeTimeout:
+		code = codes.DeadlineExceeded
+	case CodeDatabaseError, CodeDependencyFailure:
+		code = codes.Unavailable
+	}
+
+	return status.Error(code, err.Error())
+}
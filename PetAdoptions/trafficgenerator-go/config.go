@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"petadoptions/generator"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/spf13/viper"
+)
+
+// config is injected as environment variable
+type Config struct {
+	Targets   generator.Targets
+	AWSRegion string
+
+	Profile generator.Profile
+	Weights generator.Weights
+	Users   int
+	// Concurrency caps the journeys in flight
+	Concurrency int
+	// ChaosPause is the pause when the chaos parameters change, 0 to run
+	// through
+	ChaosPause        time.Duration
+	ChaosPollInterval time.Duration
+	// CleanupInterval is the minimum time between two cleanup journeys
+	CleanupInterval time.Duration
+	Seed            int64
+}
+
+func fetchConfig() (Config, error) {
+
+	// fetch from env
+	viper.SetEnvPrefix("app")
+	viper.AutomaticEnv() // Bind automatically all env vars that have the same prefix
+
+	viper.SetDefault("PROFILE", generator.ProfileSteady)
+	viper.SetDefault("RPS", 2)
+	viper.SetDefault("JOURNEYS", generator.DefaultWeights)
+	viper.SetDefault("CHAOS_PAUSE", time.Minute)
+	viper.SetDefault("CHAOS_POLL_INTERVAL", 30*time.Second)
+	viper.SetDefault("CLEANUP_INTERVAL", 10*time.Minute)
+
+	cfg := Config{
+		Targets: generator.Targets{
+			SearchURL:           viper.GetString("SEARCH_API_URL"),
+			PaymentURL:          viper.GetString("PAYMENT_API_URL"),
+			CleanupURL:          viper.GetString("CLEANUP_ADOPTIONS_URL"),
+			PetListAdoptionsURL: viper.GetString("PET_LIST_ADOPTIONS_URL"),
+		},
+		AWSRegion:         os.Getenv("AWS_REGION"),
+		Users:             viper.GetInt("USERS"),
+		Concurrency:       viper.GetInt("CONCURRENCY"),
+		ChaosPause:        viper.GetDuration("CHAOS_PAUSE"),
+		ChaosPollInterval: viper.GetDuration("CHAOS_POLL_INTERVAL"),
+		CleanupInterval:   viper.GetDuration("CLEANUP_INTERVAL"),
+		Seed:              viper.GetInt64("SEED"),
+	}
+
+	var err error
+	if cfg.Profile, err = generator.ParseProfile(viper.GetString("PROFILE"), viper.GetFloat64("RPS")); err != nil {
+		return cfg, err
+	}
+	if cfg.Weights, err = generator.ParseWeights(viper.GetString("JOURNEYS")); err != nil {
+		return cfg, err
+	}
+
+	t := cfg.Targets
+	if t.SearchURL == "" || t.PaymentURL == "" || t.CleanupURL == "" || t.PetListAdoptionsURL == "" {
+		return fetchConfigFromParameterStore(cfg)
+	}
+
+	return cfg, nil
+}
+
+// fetchConfigFromParameterStore fills the URLs left unset by the environment
+func fetchConfigFromParameterStore(cfg Config) (Config, error) {
+	svc := ssm.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+
+	res, err := svc.GetParametersWithContext(context.Background(), &ssm.GetParametersInput{
+		Names: []*string{
+			aws.String("/petstore/searchapiurl"),
+			aws.String("/petstore/paymentapiurl"),
+			aws.String("/petstore/cleanupadoptionsurl"),
+			aws.String("/petstore/petlistadoptionsurl"),
+		},
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, p := range res.Parameters {
+		value := aws.StringValue(p.Value)
+		switch aws.StringValue(p.Name) {
+		case "/petstore/searchapiurl":
+			setIfEmpty(&cfg.Targets.SearchURL, value)
+		case "/petstore/paymentapiurl":
+			setIfEmpty(&cfg.Targets.PaymentURL, value)
+		case "/petstore/cleanupadoptionsurl":
+			setIfEmpty(&cfg.Targets.CleanupURL, value)
+		case "/petstore/petlistadoptionsurl":
+			setIfEmpty(&cfg.Targets.PetListAdoptionsURL, value)
+		}
+	}
+
+	return cfg, nil
+}
+
+func setIfEmpty(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Parameters the workshop turns the degradations of payforadoption on with
+const (
+	errorModeParameter = "/petstore/errormode1"
+	scenarioParameter  = "/petstore/degradation_scenario"
+	latencyParameter   = "/petstore/latencyinjection"
+	errorsParameter    = "/petstore/errorinjection"
+)
+
+// ChaosWatcher polls the chaos parameters. The generator pauses when the
+// active degradations change, so the dashboards show the traffic before and
+// after as two distinct periods rather than a ramp blending them.
+type ChaosWatcher struct {
+	svc      *ssm.SSM
+	interval time.Duration
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	active  string
+	changes chan string
+}
+
+// NewChaosWatcher polls every interval once Run is called
+func NewChaosWatcher(svc *ssm.SSM, interval time.Duration, logger log.Logger) *ChaosWatcher {
+	return &ChaosWatcher{
+		svc:      svc,
+		interval: interval,
+		logger:   logger,
+		changes:  make(chan string, 1),
+	}
+}
+
+// Active lists the degradations turned on, comma separated, empty when none
+func (w *ChaosWatcher) Active() string {
+	if w == nil {
+		return ""
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.active
+}
+
+// Changes receives the active degradations when they change. A nil watcher
+// never changes.
+func (w *ChaosWatcher) Changes() <-chan string {
+	if w == nil {
+		return nil
+	}
+	return w.changes
+}
+
+// Run polls the parameters until ctx is done. A failed poll keeps the last
+// state, the generator runs on rather than stop with SSM.
+func (w *ChaosWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	first := true
+	for {
+		active, err := w.fetch(ctx)
+		if err != nil {
+			level.Warn(w.logger).Log("msg", "chaos parameters not read", "err", err)
+		} else {
+			w.mtx.Lock()
+			changed := active != w.active
+			w.active = active
+			w.mtx.Unlock()
+
+			// the state at startup is not a change
+			if changed && !first {
+				level.Info(w.logger).Log("msg", "chaos changed", "active", active)
+				select {
+				case <-w.changes:
+				default:
+				}
+				w.changes <- active
+			}
+			first = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *ChaosWatcher) fetch(ctx context.Context) (string, error) {
+	res, err := w.svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{
+		Names: []*string{
+			aws.String(errorModeParameter),
+			aws.String(scenarioParameter),
+			aws.String(latencyParameter),
+			aws.String(errorsParameter),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var active []string
+	for _, p := range res.Parameters {
+		value := aws.StringValue(p.Value)
+		switch aws.StringValue(p.Name) {
+		case errorModeParameter:
+			if value == "true" {
+				active = append(active, "errormode")
+			}
+		case scenarioParameter:
+			if value != "" && value != "none" {
+				active = append(active, "scenario:"+value)
+			}
+		case latencyParameter:
+			if injectionEnabled(value) {
+				active = append(active, "latencyinjection")
+			}
+		case errorsParameter:
+			if injectionEnabled(value) {
+				active = append(active, "errorinjection")
+			}
+		}
+	}
+	sort.Strings(active)
+	return strings.Join(active, ","), nil
+}
+
+// injectionEnabled reads the enabled field of an injection parameter
+func injectionEnabled(value string) bool {
+	var v struct {
+		Enabled bool `json:"enabled"`
+	}
+	return json.Unmarshal([]byte(value), &v) == nil && v.Enabled
+}
@@ -0,0 +1,248 @@
+// Package generator drives the traffic of simulated users against the pet
+// adoption services: browsing the pets, adopting one, listing the adoptions
+// and cleaning them up, at the rate of a profile.
+package generator
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tick is the granularity of the rate, journeys are started in small batches
+const tick = 100 * time.Millisecond
+
+// Options of the generator
+type Options struct {
+	Profile Profile
+	Weights Weights
+	Targets Targets
+	// Client sends the requests, its transport is expected to be traced
+	Client *http.Client
+	// Users is the number of distinct users adopting, 100 when 0
+	Users int
+	// Concurrency caps the journeys in flight, the journeys beyond it are
+	// skipped and counted rather than queued. 64 when 0.
+	Concurrency int
+	// Chaos, when set, pauses the generator for ChaosPause each time the
+	// active degradations change
+	Chaos      *ChaosWatcher
+	ChaosPause time.Duration
+	// CleanupInterval is the minimum time between two cleanups, the cleanups
+	// drawn sooner are counted as throttled. 10 minutes when 0.
+	CleanupInterval time.Duration
+	// Seed replays the same sequence of journeys, random when 0
+	Seed int64
+}
+
+// Generator starts the journeys
+type Generator struct {
+	opts   Options
+	logger log.Logger
+	picker *picker
+	client *client
+	tracer trace.Tracer
+
+	rndMtx sync.Mutex
+	rnd    *rand.Rand
+
+	// lastCleanup is only used by Run
+	lastCleanup time.Time
+
+	journeys *stdprometheus.CounterVec
+	duration *stdprometheus.HistogramVec
+	target   stdprometheus.Gauge
+	paused   stdprometheus.Gauge
+}
+
+// New returns a generator, it registers its metrics so it is called once per
+// process
+func New(opts Options, logger log.Logger) *Generator {
+	if opts.Users <= 0 {
+		opts.Users = 100
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 64
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = 10 * time.Minute
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+
+	g := &Generator{
+		opts:   opts,
+		logger: logger,
+		picker: newPicker(opts.Weights),
+		client: &client{http: opts.Client, targets: opts.Targets, users: opts.Users},
+		tracer: otel.Tracer("petadoptions/generator"),
+		rnd:    rand.New(rand.NewSource(opts.Seed)),
+		journeys: stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+			Namespace: "trafficgenerator",
+			Name:      "journeys_total",
+			Help:      "Number of journeys by result: succeeded, failed, skipped when the concurrency was reached or throttled when a cleanup ran less than the cleanup interval ago",
+		}, []string{"journey", "result"}),
+		duration: stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+			Namespace: "trafficgenerator",
+			Name:      "journey_duration_seconds",
+			Help:      "Durations of the journeys in seconds",
+		}, []string{"journey", "result"}),
+		target: stdprometheus.NewGauge(stdprometheus.GaugeOpts{
+			Namespace: "trafficgenerator",
+			Name:      "target_rate",
+			Help:      "Journeys per second the profile asks for",
+		}),
+		paused: stdprometheus.NewGauge(stdprometheus.GaugeOpts{
+			Namespace: "trafficgenerator",
+			Name:      "paused",
+			Help:      "1 while the generator pauses after a chaos change",
+		}),
+	}
+	stdprometheus.MustRegister(g.journeys, g.duration, g.target, g.paused)
+
+	return g
+}
+
+// Run starts journeys until ctx is done, then waits for those in flight
+func (g *Generator) Run(ctx context.Context) {
+	var inflight sync.WaitGroup
+	defer inflight.Wait()
+
+	slots := make(chan struct{}, g.opts.Concurrency)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var pausedUntil time.Time
+	// the fraction of a journey carried over to the next tick, so low rates
+	// are honoured too
+	var due float64
+
+	level.Info(g.logger).Log("msg", "generating traffic", "profile", g.opts.Profile.Name, "rps", g.opts.Profile.RPS)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case active := <-g.opts.Chaos.Changes():
+			if g.opts.ChaosPause > 0 {
+				pausedUntil = time.Now().Add(g.opts.ChaosPause)
+				level.Info(g.logger).Log("msg", "pausing on chaos change", "active", active, "until", pausedUntil)
+			}
+			continue
+		case now := <-ticker.C:
+			if now.Before(pausedUntil) {
+				g.paused.Set(1)
+				due = 0
+				continue
+			}
+			g.paused.Set(0)
+
+			rate := g.opts.Profile.Rate(now.Sub(start))
+			g.target.Set(rate)
+			due += rate * tick.Seconds()
+		}
+
+		for ; due >= 1; due-- {
+			journey := g.pick()
+			if journey == JourneyCleanup && !g.cleanupDue(time.Now()) {
+				g.journeys.WithLabelValues(journey, "throttled").Inc()
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+				g.journeys.WithLabelValues(journey, "skipped").Inc()
+				continue
+			}
+
+			inflight.Add(1)
+			go func(journey string, seed int64) {
+				defer func() {
+					<-slots
+					inflight.Done()
+				}()
+				g.run(ctx, journey, rand.New(rand.NewSource(seed)))
+			}(journey, g.seed())
+		}
+	}
+}
+
+// run traces a journey as its own trace, the resource of the generator tells
+// the generated traces apart
+func (g *Generator) run(ctx context.Context, journey string, rnd *rand.Rand) {
+	ctx, span := g.tracer.Start(ctx, "journey "+journey,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			label.String("journey", journey),
+			label.String("profile", g.opts.Profile.Name),
+			label.String("chaos", g.opts.Chaos.Active()),
+		),
+	)
+	defer span.End()
+
+	begin := time.Now()
+	err := g.journey(journey)(ctx, rnd)
+
+	result := "succeeded"
+	switch {
+	case errors.Is(err, errNoPet):
+		// every pet of the type is adopted until the next cleanup
+		span.AddEvent("no pet available")
+	case err != nil && ctx.Err() == nil:
+		result = "failed"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		level.Debug(g.logger).Log("journey", journey, "err", err)
+	}
+	g.journeys.WithLabelValues(journey, result).Inc()
+	g.duration.WithLabelValues(journey, result).Observe(time.Since(begin).Seconds())
+}
+
+func (g *Generator) journey(name string) func(context.Context, *rand.Rand) error {
+	switch name {
+	case JourneyAdopt:
+		return g.client.adopt
+	case JourneyList:
+		return g.client.list
+	case JourneyCleanup:
+		return g.client.cleanup
+	}
+	return g.client.browse
+}
+
+// cleanupDue reports whether a cleanup may start now and records it, only Run
+// calls it
+func (g *Generator) cleanupDue(now time.Time) bool {
+	if now.Sub(g.lastCleanup) < g.opts.CleanupInterval {
+		return false
+	}
+	g.lastCleanup = now
+	return true
+}
+
+func (g *Generator) pick() string {
+	g.rndMtx.Lock()
+	defer g.rndMtx.Unlock()
+	return g.picker.pick(g.rnd)
+}
+
+// seed gives every journey its own source, rand.Rand is not safe for
+// concurrent use
+func (g *Generator) seed() int64 {
+	g.rndMtx.Lock()
+	defer g.rndMtx.Unlock()
+	return g.rnd.Int63()
+}
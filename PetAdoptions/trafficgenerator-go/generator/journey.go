@@ -0,0 +1,237 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Journeys of a simulated user
+const (
+	JourneyBrowse  = "browse"
+	JourneyAdopt   = "adopt"
+	JourneyList    = "list"
+	JourneyCleanup = "cleanup"
+)
+
+// SyntheticHeader marks the generated requests, the Go services tag their
+// traces with synthetic=true when it is set, like for the canaries
+const SyntheticHeader = "X-Synthetic"
+
+// DefaultWeights favour browsing. A cleanup makes every pet available again
+// and resets the state of the workshop, so it is left out unless asked for
+// and even then runs at most once per Options.CleanupInterval.
+const DefaultWeights = "browse=60,adopt=25,list=15,cleanup=0"
+
+var (
+	petTypes  = []string{"bunny", "kitten", "puppy"}
+	petColors = []string{"black", "brown", "grey", "white"}
+
+	errNoPet = errors.New("no pet available")
+)
+
+// Targets are the URLs of the services, as published in the /petstore
+// parameters
+type Targets struct {
+	// SearchURL ends with the query separator, e.g. http://host/api/search?
+	SearchURL           string
+	PaymentURL          string
+	CleanupURL          string
+	PetListAdoptionsURL string
+}
+
+// StatusError is the unexpected status of a step
+type StatusError struct {
+	Step   string
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d", e.Step, e.Status)
+}
+
+type pet struct {
+	PetID        string `json:"petid"`
+	PetType      string `json:"pettype"`
+	PetColor     string `json:"petcolor"`
+	Availability string `json:"availability"`
+}
+
+// client runs the steps of the journeys over a traced HTTP client
+type client struct {
+	http    *http.Client
+	targets Targets
+	users   int
+}
+
+// browse searches pets by type and color, then looks at one of them
+func (c *client) browse(ctx context.Context, rnd *rand.Rand) error {
+	pets, err := c.search(ctx, url.Values{
+		"pettype":  {pick(rnd, petTypes)},
+		"petcolor": {pick(rnd, petColors)},
+	})
+	if err != nil || len(pets) == 0 {
+		return err
+	}
+	_, err = c.search(ctx, url.Values{"petid": {pets[rnd.Intn(len(pets))].PetID}})
+	return err
+}
+
+// adopt searches a pet of a type and pays for one still available
+func (c *client) adopt(ctx context.Context, rnd *rand.Rand) error {
+	pets, err := c.search(ctx, url.Values{"pettype": {pick(rnd, petTypes)}})
+	if err != nil {
+		return err
+	}
+
+	var available []pet
+	for _, p := range pets {
+		if p.Availability == "yes" {
+			available = append(available, p)
+		}
+	}
+	if len(available) == 0 {
+		return errNoPet
+	}
+	p := available[rnd.Intn(len(available))]
+
+	q := url.Values{
+		"petId":   {p.PetID},
+		"petType": {p.PetType},
+		"userId":  {c.user(rnd)},
+	}
+	return c.do(ctx, JourneyAdopt, "POST", c.targets.PaymentURL+"?"+q.Encode(), nil)
+}
+
+// list reads the adoptions, as the petsite page does
+func (c *client) list(ctx context.Context, _ *rand.Rand) error {
+	return c.do(ctx, JourneyList, "GET", c.targets.PetListAdoptionsURL, nil)
+}
+
+// cleanup marks every pet available again, the housekeeping of the petsite
+func (c *client) cleanup(ctx context.Context, _ *rand.Rand) error {
+	return c.do(ctx, JourneyCleanup, "POST", c.targets.CleanupURL, nil)
+}
+
+func (c *client) search(ctx context.Context, q url.Values) ([]pet, error) {
+	var pets []pet
+	if err := c.do(ctx, "search", "GET", c.targets.SearchURL+q.Encode(), &pets); err != nil {
+		return nil, err
+	}
+	return pets, nil
+}
+
+// do sends a request and decodes its JSON response into out when not nil
+func (c *client) do(ctx context.Context, step, method, u string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(SyntheticHeader, "true")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// drained so the connection goes back to the pool
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &StatusError{Step: step, Status: resp.StatusCode}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// user is one of a fixed set of users, so the per user adoption lists fill up
+func (c *client) user(rnd *rand.Rand) string {
+	return "user" + strconv.Itoa(rnd.Intn(c.users)+1)
+}
+
+func pick(rnd *rand.Rand, values []string) string {
+	return values[rnd.Intn(len(values))]
+}
+
+// Weights are the relative frequencies of the journeys
+type Weights map[string]int
+
+// ParseWeights reads a comma separated list of journey=weight, e.g.
+// browse=60,adopt=25. The journeys left out are not run.
+func ParseWeights(s string) (Weights, error) {
+	w := Weights{}
+	total := 0
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid journey weight %q, expected journey=weight", item)
+		}
+		name := strings.TrimSpace(parts[0])
+		if !knownJourney(name) {
+			return nil, fmt.Errorf("unknown journey %q", name)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight of journey %s: %q", name, parts[1])
+		}
+		w[name] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("no journey to run, every weight is 0")
+	}
+	return w, nil
+}
+
+func knownJourney(name string) bool {
+	switch name {
+	case JourneyBrowse, JourneyAdopt, JourneyList, JourneyCleanup:
+		return true
+	}
+	return false
+}
+
+// picker draws journeys with the frequencies of the weights
+type picker struct {
+	names      []string
+	cumulative []int
+}
+
+func newPicker(w Weights) *picker {
+	p := &picker{}
+	for name := range w {
+		p.names = append(p.names, name)
+	}
+	// sorted so a seed replays the same journeys
+	sort.Strings(p.names)
+
+	total := 0
+	for _, name := range p.names {
+		total += w[name]
+		p.cumulative = append(p.cumulative, total)
+	}
+	return p
+}
+
+func (p *picker) pick(rnd *rand.Rand) string {
+	n := rnd.Intn(p.cumulative[len(p.cumulative)-1])
+	i := sort.SearchInts(p.cumulative, n+1)
+	return p.names[i]
+}
@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profiles selected with -profile
+const (
+	ProfileSteady  = "steady"
+	ProfileDiurnal = "diurnal"
+	ProfilePeak    = "peak"
+	ProfileSpiky   = "spiky"
+)
+
+// Profile shapes the rate of journeys over time, around a base rate
+type Profile struct {
+	Name string
+	// RPS is the base rate of journeys started per second
+	RPS float64
+	// shape is the multiplier of RPS after elapsed since the start
+	shape func(elapsed time.Duration) float64
+}
+
+var shapes = map[string]func(time.Duration) float64{
+	ProfileSteady: func(time.Duration) float64 { return 1 },
+	// a day compressed into an hour, so a workshop sees the low and the
+	// high of the traffic: from 0.2 to 1.8 times the base rate
+	ProfileDiurnal: func(elapsed time.Duration) float64 {
+		return 1 - 0.8*math.Cos(2*math.Pi*elapsed.Hours())
+	},
+	// a sale: 3 times the base rate for 2 minutes every 15 minutes
+	ProfilePeak: func(elapsed time.Duration) float64 {
+		if elapsed%(15*time.Minute) < 2*time.Minute {
+			return 3
+		}
+		return 1
+	},
+	// bursts of 5 times the base rate for 10 seconds every minute, short
+	// enough to be missed by 1 minute metrics but not by the traces
+	ProfileSpiky: func(elapsed time.Duration) float64 {
+		if elapsed%time.Minute < 10*time.Second {
+			return 5
+		}
+		return 1
+	},
+}
+
+// ParseProfile returns the named profile around rps
+func ParseProfile(name string, rps float64) (Profile, error) {
+	if name == "" {
+		name = ProfileSteady
+	}
+	shape, ok := shapes[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(profileNames(), ", "))
+	}
+	if rps <= 0 {
+		return Profile{}, fmt.Errorf("invalid rate %g, it must be positive", rps)
+	}
+	return Profile{Name: name, RPS: rps, shape: shape}, nil
+}
+
+// Rate is the number of journeys per second to start after elapsed
+func (p Profile) Rate(elapsed time.Duration) float64 {
+	return p.RPS * p.shape(elapsed)
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(shapes))
+	for name := range shapes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws-samples/one-observability-demo/PetAdoptions/observability"
+	"petadoptions/generator"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/label"
+)
+
+// Resource attributes marking the telemetry of the generator, the traces it
+// starts are told apart from the traffic of real users by them
+const (
+	syntheticAttribute = label.Key("petadoptions.traffic.synthetic")
+	profileAttribute   = label.Key("petadoptions.traffic.profile")
+)
+
+func main() {
+	var (
+		adminAddr     = flag.String("admin.addr", ":9090", "Metrics, health and pprof port binding")
+		clientTimeout = flag.Duration("client.timeout", 10*time.Second, "Timeout of every request to the services")
+	)
+
+	flag.Parse()
+
+	// GC, heap and scheduler metrics on /metrics
+	observability.RegisterRuntimeMetrics()
+
+	var logger log.Logger
+	{
+		logger = log.NewJSONLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	var cfg Config
+	{
+		var err error
+		cfg, err = fetchConfig()
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+	}
+
+	{
+		shutdown, err := observability.InitTracerProvider(context.Background(), observability.Config{
+			ServiceName: "trafficgenerator",
+			ResourceAttributes: []label.KeyValue{
+				syntheticAttribute.Bool(true),
+				profileAttribute.String(cfg.Profile.Name),
+			},
+		})
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		defer shutdown(context.Background())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var chaos *generator.ChaosWatcher
+	if cfg.ChaosPause > 0 {
+		svc := ssm.New(session.New(&aws.Config{Region: aws.String(cfg.AWSRegion)}))
+		chaos = generator.NewChaosWatcher(svc, cfg.ChaosPollInterval, logger)
+		go chaos.Run(ctx)
+	}
+
+	g := generator.New(generator.Options{
+		Profile: cfg.Profile,
+		Weights: cfg.Weights,
+		Targets: cfg.Targets,
+		Client: &http.Client{
+			Timeout:   *clientTimeout,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+		Users:           cfg.Users,
+		Concurrency:     cfg.Concurrency,
+		Chaos:           chaos,
+		ChaosPause:      cfg.ChaosPause,
+		CleanupInterval: cfg.CleanupInterval,
+		Seed:            cfg.Seed,
+	}, logger)
+
+	errs := make(chan error)
+	go func() {
+		c := make(chan os.Signal)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		errs <- fmt.Errorf("%s", <-c)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Run(ctx)
+	}()
+
+	go func() {
+		logger.Log("transport", "admin", "addr", *adminAddr)
+		errs <- http.ListenAndServe(*adminAddr, makeAdminHandler())
+	}()
+
+	logger.Log("exit", <-errs)
+
+	// the journeys in flight end before their spans are flushed
+	cancel()
+	<-done
+}
+
+// makeAdminHandler serves the health check, the metrics and the profiling
+// endpoints, the generator has no API of its own
+func makeAdminHandler() http.Handler {
+	r := http.NewServeMux()
+
+	r.HandleFunc("/health/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+	})
+
+	// exemplars are only exposed in the OpenMetrics format
+	r.Handle("/metrics", promhttp.HandlerFor(
+		stdprometheus.DefaultGatherer,
+		promhttp.HandlerOpts{EnableOpenMetrics: true},
+	))
+
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return r
+}